@@ -4,10 +4,16 @@ import (
 	"errors"
 	"strings"
 
-	hs256 "local/authorizer/jwt_hs256"
-	rs256 "local/authorizer/jwt_rs256"
+	"local/authorizer/jwe"
+	jwtECDSA "local/authorizer/jwt_ecdsa"
+	jwtEdDSA "local/authorizer/jwt_eddsa"
+	jwtHMAC "local/authorizer/jwt_hmac"
+	jwtRSA "local/authorizer/jwt_rsa"
 	jwtSM2 "local/authorizer/jwt_sm2"
 	jwtSM4 "local/authorizer/jwt_sm4"
+	"local/authorizer/opaque"
+	pasetoV4Local "local/authorizer/paseto_v4_local"
+	pasetoV4Public "local/authorizer/paseto_v4_public"
 	"local/global"
 
 	"github.com/rs/zerolog/log"
@@ -17,15 +23,29 @@ import (
 func Build(name, config string) (global.AuthorizerInstance, error) {
 	name = strings.ToUpper(name)
 	switch name {
-	case "JWT_HS256":
-		instance, err := hs256.New(config)
+	case "JWT_HS256", "JWT_HS384", "JWT_HS512":
+		instance, err := jwtHMAC.New(strings.TrimPrefix(name, "JWT_"), config)
 		if err != nil {
 			log.Err(err).Caller().Send()
 			return nil, err
 		}
 		return instance, nil
-	case "JWT_RS256":
-		instance, err := rs256.New(config)
+	case "JWT_RS256", "JWT_RS384", "JWT_RS512", "JWT_PS256", "JWT_PS384", "JWT_PS512":
+		instance, err := jwtRSA.New(strings.TrimPrefix(name, "JWT_"), config)
+		if err != nil {
+			log.Err(err).Caller().Send()
+			return nil, err
+		}
+		return instance, nil
+	case "JWT_ES256", "JWT_ES384", "JWT_ES512":
+		instance, err := jwtECDSA.New(strings.TrimPrefix(name, "JWT_"), config)
+		if err != nil {
+			log.Err(err).Caller().Send()
+			return nil, err
+		}
+		return instance, nil
+	case "JWT_EDDSA":
+		instance, err := jwtEdDSA.New(config)
 		if err != nil {
 			log.Err(err).Caller().Send()
 			return nil, err
@@ -45,6 +65,69 @@ func Build(name, config string) (global.AuthorizerInstance, error) {
 			return nil, err
 		}
 		return instance, nil
+	case "JWE_DIR_A256GCM":
+		instance, err := jwe.New(jwe.AlgDir, config)
+		if err != nil {
+			log.Err(err).Caller().Send()
+			return nil, err
+		}
+		return instance, nil
+	case "JWE_RSA_OAEP_256_A256GCM":
+		instance, err := jwe.New(jwe.AlgRSAOAEP256, config)
+		if err != nil {
+			log.Err(err).Caller().Send()
+			return nil, err
+		}
+		return instance, nil
+	case "OPAQUE":
+		instance, err := opaque.New(config)
+		if err != nil {
+			log.Err(err).Caller().Send()
+			return nil, err
+		}
+		return instance, nil
+	case "PASETO_V4_LOCAL":
+		instance, err := pasetoV4Local.New(config)
+		if err != nil {
+			log.Err(err).Caller().Send()
+			return nil, err
+		}
+		return instance, nil
+	case "PASETO_V4_PUBLIC":
+		instance, err := pasetoV4Public.New(config)
+		if err != nil {
+			log.Err(err).Caller().Send()
+			return nil, err
+		}
+		return instance, nil
 	}
 	return nil, errors.New("不支持的规则类型")
 }
+
+// 生成授权器的密钥配置，config为授权器的基础配置
+func GenerateKey(name, config string) (string, error) {
+	name = strings.ToUpper(name)
+	switch name {
+	case "JWT_HS256", "JWT_HS384", "JWT_HS512":
+		return jwtHMAC.GenerateKey(strings.TrimPrefix(name, "JWT_"))
+	case "JWT_RS256", "JWT_RS384", "JWT_RS512", "JWT_PS256", "JWT_PS384", "JWT_PS512":
+		return jwtRSA.GenerateKey(strings.TrimPrefix(name, "JWT_"), config)
+	case "JWT_ES256", "JWT_ES384", "JWT_ES512":
+		return jwtECDSA.GenerateKey(strings.TrimPrefix(name, "JWT_"))
+	case "JWT_EDDSA":
+		return jwtEdDSA.GenerateKey()
+	case "JWT_SM2":
+		return jwtSM2.GenerateKey()
+	case "JWT_SM4":
+		return jwtSM4.GenerateKey()
+	case "JWE_DIR_A256GCM":
+		return jwe.GenerateKey(jwe.AlgDir, config)
+	case "JWE_RSA_OAEP_256_A256GCM":
+		return jwe.GenerateKey(jwe.AlgRSAOAEP256, config)
+	case "PASETO_V4_LOCAL":
+		return pasetoV4Local.GenerateKey()
+	case "PASETO_V4_PUBLIC":
+		return pasetoV4Public.GenerateKey()
+	}
+	return "", errors.New("规则类型不支持生成密钥")
+}
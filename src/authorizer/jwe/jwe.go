@@ -0,0 +1,319 @@
+package jwe
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"local/claimset"
+	"local/global"
+	"local/keyutil"
+
+	"github.com/rs/zerolog/log"
+)
+
+// 加密的JWT(JWE Compact Serialization)，内容加密算法固定为A256GCM
+// 密钥管理算法支持dir(直接使用共享密钥)和RSA-OAEP-256
+
+const (
+	AlgDir          = "dir"
+	AlgRSAOAEP256   = "RSA-OAEP-256"
+	encA256GCM      = "A256GCM"
+	contentKeySize  = 32
+	gcmNonceSize    = 12
+	gcmTagSize      = 16
+	compactPartsLen = 5
+)
+
+type Instance struct {
+	Alg           string          `json:"-"`
+	Expires       int64           `json:"expires"`
+	KeyStr        string          `json:"key,omitempty"`
+	Key           []byte          `json:"-"`
+	PrivateKeyStr string          `json:"private_key,omitempty"`
+	PrivateKey    *rsa.PrivateKey `json:"-"`
+	PublicKeyStr  string          `json:"public_key,omitempty"` // 可选，传入时必须与私钥匹配
+	KeyID         string          `json:"key_id,omitempty"`
+	KeySize       int             `json:"key_size,omitempty"` // 自动生成的RSA密钥的位数，默认2048
+}
+
+type _Header struct {
+	Alg   string `json:"alg"`
+	Enc   string `json:"enc"`
+	Typ   string `json:"typ,omitempty"`
+	KeyID string `json:"kid,omitempty"`
+}
+
+type _Claims struct {
+	Expires   int64                  `json:"exp,omitempty"`
+	IssuedAt  int64                  `json:"iat,omitempty"`
+	NotBefore int64                  `json:"nbf,omitempty"`
+	Issuer    string                 `json:"iss,omitempty"`
+	Subject   string                 `json:"sub,omitempty"`
+	ID        string                 `json:"jti,omitempty"`
+	Aud       string                 `json:"aud,omitempty"`
+	Payload   string                 `json:"payload,omitempty"`
+	IP        string                 `json:"ip,omitempty"`
+	Scope     string                 `json:"scope,omitempty"`
+	Custom    map[string]interface{} `json:"-"` // 自定义claims
+}
+
+// alg的值为dir或RSA-OAEP-256
+func New(alg, config string) (*Instance, error) {
+	var instance Instance
+	err := json.Unmarshal(global.StrToBytes(config), &instance)
+	if err != nil {
+		return nil, err
+	}
+	instance.Alg = alg
+	switch alg {
+	case AlgDir:
+		if instance.KeyStr == "" {
+			return nil, errors.New("key不能为空")
+		}
+		instance.Key, err = base64.RawURLEncoding.DecodeString(instance.KeyStr)
+		if err != nil {
+			return nil, errors.New("无效的key Base64字符串：" + err.Error())
+		}
+		if len(instance.Key) != contentKeySize {
+			return nil, errors.New("key的长度必须是32字节")
+		}
+	case AlgRSAOAEP256:
+		if instance.PrivateKeyStr == "" {
+			return nil, errors.New("private_key不能为空")
+		}
+		instance.PrivateKey, err = keyutil.ParseRSAPrivateKey(instance.PrivateKeyStr)
+		if err != nil {
+			return nil, errors.New("无效的私钥：" + err.Error())
+		}
+		if err = keyutil.CheckPublicKey(instance.PublicKeyStr, &instance.PrivateKey.PublicKey); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("不支持的JWE密钥管理算法：" + alg)
+	}
+	return &instance, nil
+}
+
+func (receiver *Instance) Sign(params global.SignParams) (tokenStr string, err error) {
+	var (
+		claims      _Claims
+		claimsBytes []byte
+		headerBytes []byte
+		contentKey  []byte
+		encKey      []byte
+		now         = time.Now()
+		token       strings.Builder
+	)
+	claims.IssuedAt = now.Unix()
+	claims.Expires = claimset.ExpiresAt(now, receiver.Expires, params.Expires)
+	claims.NotBefore = params.NotBefore
+	claims.Issuer = params.Issuer
+	claims.Subject = params.Subject
+	claims.ID = params.ID
+	claims.Payload = params.Payload
+	claims.Aud = params.Aud
+	claims.IP = params.IP
+	claims.Scope = params.Scope
+	if claimsBytes, err = json.Marshal(&claims); err != nil {
+		log.Err(err).Caller().Send()
+		return
+	}
+	if claimsBytes, err = claimset.Merge(claimsBytes, params.Claims); err != nil {
+		log.Err(err).Caller().Send()
+		return
+	}
+	headerBytes, err = json.Marshal(&_Header{Alg: receiver.Alg, Enc: encA256GCM, Typ: "JWT", KeyID: receiver.KeyID})
+	if err != nil {
+		log.Err(err).Caller().Send()
+		return
+	}
+
+	// 生成内容加密密钥
+	if receiver.Alg == AlgDir {
+		contentKey = receiver.Key
+	} else {
+		contentKey = make([]byte, contentKeySize)
+		if _, err = rand.Read(contentKey); err != nil {
+			log.Err(err).Caller().Send()
+			return
+		}
+		encKey, err = rsa.EncryptOAEP(sha256.New(), rand.Reader, &receiver.PrivateKey.PublicKey, contentKey, nil)
+		if err != nil {
+			log.Err(err).Caller().Send()
+			return
+		}
+	}
+
+	// 加密claims，header做为附加认证数据
+	header := base64.RawURLEncoding.EncodeToString(headerBytes)
+	nonce := make([]byte, gcmNonceSize)
+	if _, err = rand.Read(nonce); err != nil {
+		log.Err(err).Caller().Send()
+		return
+	}
+	aead, err := newGCM(contentKey)
+	if err != nil {
+		log.Err(err).Caller().Send()
+		return
+	}
+	sealed := aead.Seal(nil, nonce, claimsBytes, global.StrToBytes(header))
+	cipherText, tag := sealed[:len(sealed)-gcmTagSize], sealed[len(sealed)-gcmTagSize:]
+
+	token.WriteString(header)
+	token.WriteString(".")
+	token.WriteString(base64.RawURLEncoding.EncodeToString(encKey))
+	token.WriteString(".")
+	token.WriteString(base64.RawURLEncoding.EncodeToString(nonce))
+	token.WriteString(".")
+	token.WriteString(base64.RawURLEncoding.EncodeToString(cipherText))
+	token.WriteString(".")
+	token.WriteString(base64.RawURLEncoding.EncodeToString(tag))
+	tokenStr = token.String()
+	return
+}
+
+func (receiver *Instance) VeritySign(tokenStr string) (global.AuthorizerClaims, error) {
+	var claims global.AuthorizerClaims
+	tokenClaims, err := receiver.decrypt(tokenStr)
+	if err != nil {
+		log.Debug().Err(err).Caller().Send()
+		return claims, err
+	}
+	claims.Expires = tokenClaims.Expires
+	claims.IssuedAt = tokenClaims.IssuedAt
+	claims.NotBefore = tokenClaims.NotBefore
+	claims.Issuer = tokenClaims.Issuer
+	claims.Subject = tokenClaims.Subject
+	claims.ID = tokenClaims.ID
+	claims.Payload = tokenClaims.Payload
+	claims.Aud = tokenClaims.Aud
+	claims.IP = tokenClaims.IP
+	claims.Scope = tokenClaims.Scope
+	claims.Claims = tokenClaims.Custom
+	return claims, nil
+}
+
+// 解密并认证token，得到claims
+func (receiver *Instance) decrypt(tokenStr string) (claims _Claims, err error) {
+	var (
+		header                           _Header
+		headerBytes, encKey, contentKey  []byte
+		nonce, cipherText, tag, plainBuf []byte
+	)
+	arr := strings.Split(tokenStr, ".")
+	if len(arr) != compactPartsLen {
+		err = global.ErrTokenMalformed
+		return
+	}
+	if headerBytes, err = base64.RawURLEncoding.DecodeString(arr[0]); err != nil {
+		err = global.ErrTokenMalformed
+		return
+	}
+	if err = json.Unmarshal(headerBytes, &header); err != nil {
+		err = global.ErrTokenMalformed
+		return
+	}
+	// 只接受与实例一致的算法，防止算法混淆
+	if header.Alg != receiver.Alg || header.Enc != encA256GCM {
+		err = global.ErrAlgorithmMismatch
+		return
+	}
+	if encKey, err = base64.RawURLEncoding.DecodeString(arr[1]); err != nil {
+		err = global.ErrTokenMalformed
+		return
+	}
+	if nonce, err = base64.RawURLEncoding.DecodeString(arr[2]); err != nil {
+		err = global.ErrTokenMalformed
+		return
+	}
+	if cipherText, err = base64.RawURLEncoding.DecodeString(arr[3]); err != nil {
+		err = global.ErrTokenMalformed
+		return
+	}
+	if tag, err = base64.RawURLEncoding.DecodeString(arr[4]); err != nil {
+		err = global.ErrTokenMalformed
+		return
+	}
+	if len(nonce) != gcmNonceSize || len(tag) != gcmTagSize {
+		err = global.ErrTokenMalformed
+		return
+	}
+
+	// 得到内容加密密钥
+	if receiver.Alg == AlgDir {
+		if len(encKey) != 0 {
+			err = global.ErrTokenMalformed
+			return
+		}
+		contentKey = receiver.Key
+	} else {
+		contentKey, err = rsa.DecryptOAEP(sha256.New(), nil, receiver.PrivateKey, encKey, nil)
+		if err != nil {
+			err = global.ErrSignatureInvalid
+			return
+		}
+	}
+
+	aead, err := newGCM(contentKey)
+	if err != nil {
+		return
+	}
+	plainBuf, err = aead.Open(nil, nonce, append(cipherText, tag...), global.StrToBytes(arr[0]))
+	if err != nil {
+		err = global.ErrSignatureInvalid
+		return
+	}
+	if err = json.Unmarshal(plainBuf, &claims); err != nil {
+		err = global.ErrTokenMalformed
+		return
+	}
+	if claims.Custom, err = claimset.Extract(plainBuf); err != nil {
+		err = global.ErrTokenMalformed
+	}
+	return
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// 生成密钥配置，dir使用32字节的key，RSA-OAEP-256的密钥位数由config中的key_size决定
+func GenerateKey(alg, config string) (string, error) {
+	var instance Instance
+	if config != "" {
+		if err := json.Unmarshal(global.StrToBytes(config), &instance); err != nil {
+			return "", err
+		}
+	}
+	switch alg {
+	case AlgDir:
+		key, err := keyutil.RandomBase64(contentKeySize)
+		if err != nil {
+			return "", err
+		}
+		return keyutil.EncodeConfig(map[string]string{"key": key})
+	case AlgRSAOAEP256:
+		bits, err := keyutil.RSAKeySize(instance.KeySize)
+		if err != nil {
+			return "", err
+		}
+		privateKeyStr, publicKeyStr, err := keyutil.GenerateRSAKey(bits)
+		if err != nil {
+			return "", err
+		}
+		return keyutil.EncodeConfig(map[string]string{"private_key": privateKeyStr, "public_key": publicKeyStr})
+	}
+	return "", errors.New("不支持的JWE密钥管理算法：" + alg)
+}
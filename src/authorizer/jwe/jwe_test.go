@@ -0,0 +1,172 @@
+package jwe
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"local/global"
+)
+
+func newInstance(t *testing.T, alg string) *Instance {
+	t.Helper()
+	config, err := GenerateKey(alg, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	instance, err := New(alg, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return instance
+}
+
+func sign(t *testing.T, instance *Instance) string {
+	t.Helper()
+	tokenStr, err := instance.Sign(global.SignParams{
+		Subject: "user1",
+		Aud:     "app",
+		Scope:   "read write",
+		ID:      "id1",
+		Claims:  map[string]interface{}{"role": "admin"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tokenStr
+}
+
+// 修改token的指定部份，modify传入解码后的数据并返回修改后的数据
+func modify(t *testing.T, tokenStr string, index int, modify func([]byte) []byte) string {
+	t.Helper()
+	arr := strings.Split(tokenStr, ".")
+	data, err := base64.RawURLEncoding.DecodeString(arr[index])
+	if err != nil {
+		t.Fatal(err)
+	}
+	arr[index] = base64.RawURLEncoding.EncodeToString(modify(data))
+	return strings.Join(arr, ".")
+}
+
+func flip(data []byte) []byte {
+	data[0] ^= 1
+	return data
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, alg := range []string{AlgDir, AlgRSAOAEP256} {
+		t.Run(alg, func(t *testing.T) {
+			instance := newInstance(t, alg)
+			claims, err := instance.VeritySign(sign(t, instance))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if claims.Subject != "user1" || claims.Aud != "app" || claims.Scope != "read write" || claims.ID != "id1" {
+				t.Fatalf("claims不一致：%+v", claims)
+			}
+			if claims.Claims["role"] != "admin" {
+				t.Fatalf("自定义claims不一致：%v", claims.Claims)
+			}
+			if claims.IssuedAt == 0 {
+				t.Fatalf("缺少iat：%+v", claims)
+			}
+		})
+	}
+}
+
+func TestTamper(t *testing.T) {
+	for _, alg := range []string{AlgDir, AlgRSAOAEP256} {
+		instance := newInstance(t, alg)
+		tokenStr := sign(t, instance)
+		cases := []struct {
+			name  string
+			index int
+		}{
+			{"iv", 2},
+			{"密文", 3},
+			{"认证标签", 4},
+		}
+		if alg == AlgRSAOAEP256 {
+			cases = append(cases, struct {
+				name  string
+				index int
+			}{"加密的密钥", 1})
+		}
+		for _, c := range cases {
+			t.Run(alg+"/"+c.name, func(t *testing.T) {
+				if _, err := instance.VeritySign(modify(t, tokenStr, c.index, flip)); err != global.ErrSignatureInvalid {
+					t.Fatalf("期望错误%v，实际为%v", global.ErrSignatureInvalid, err)
+				}
+			})
+		}
+		// header是附加认证数据，修改后认证失败
+		t.Run(alg+"/header", func(t *testing.T) {
+			tampered := modify(t, tokenStr, 0, func(data []byte) []byte {
+				return []byte(strings.Replace(string(data), `"typ":"JWT"`, `"typ":"JWX"`, 1))
+			})
+			if _, err := instance.VeritySign(tampered); err != global.ErrSignatureInvalid {
+				t.Fatalf("期望错误%v，实际为%v", global.ErrSignatureInvalid, err)
+			}
+		})
+	}
+}
+
+func TestWrongKey(t *testing.T) {
+	for _, alg := range []string{AlgDir, AlgRSAOAEP256} {
+		t.Run(alg, func(t *testing.T) {
+			tokenStr := sign(t, newInstance(t, alg))
+			if _, err := newInstance(t, alg).VeritySign(tokenStr); err != global.ErrSignatureInvalid {
+				t.Fatalf("期望错误%v，实际为%v", global.ErrSignatureInvalid, err)
+			}
+		})
+	}
+}
+
+func TestWrongEnc(t *testing.T) {
+	for _, alg := range []string{AlgDir, AlgRSAOAEP256} {
+		t.Run(alg, func(t *testing.T) {
+			instance := newInstance(t, alg)
+			tampered := modify(t, sign(t, instance), 0, func(data []byte) []byte {
+				return []byte(strings.Replace(string(data), `"enc":"A256GCM"`, `"enc":"A128GCM"`, 1))
+			})
+			if _, err := instance.VeritySign(tampered); err != global.ErrAlgorithmMismatch {
+				t.Fatalf("期望错误%v，实际为%v", global.ErrAlgorithmMismatch, err)
+			}
+		})
+	}
+}
+
+// RSA-OAEP-256的规则不接受alg为dir的token，防止攻击者使用自选的共享密钥伪造token
+func TestAlgConfusion(t *testing.T) {
+	rsaInstance := newInstance(t, AlgRSAOAEP256)
+	dirInstance := newInstance(t, AlgDir)
+	if _, err := rsaInstance.VeritySign(sign(t, dirInstance)); err != global.ErrAlgorithmMismatch {
+		t.Fatalf("期望错误%v，实际为%v", global.ErrAlgorithmMismatch, err)
+	}
+	if _, err := dirInstance.VeritySign(sign(t, rsaInstance)); err != global.ErrAlgorithmMismatch {
+		t.Fatalf("期望错误%v，实际为%v", global.ErrAlgorithmMismatch, err)
+	}
+}
+
+func TestMalformed(t *testing.T) {
+	instance := newInstance(t, AlgDir)
+	tokenStr := sign(t, instance)
+	arr := strings.Split(tokenStr, ".")
+	cases := []struct {
+		name  string
+		token string
+	}{
+		{"缺少部份", strings.Join(arr[:4], ".")},
+		{"无效的header", "!!." + strings.Join(arr[1:], ".")},
+		{"dir带有加密的密钥", arr[0] + ".AAAA." + strings.Join(arr[2:], ".")},
+		{"iv长度无效", modify(t, tokenStr, 2, func(data []byte) []byte { return data[1:] })},
+		{"认证标签长度无效", modify(t, tokenStr, 4, func(data []byte) []byte { return data[1:] })},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if _, err := instance.VeritySign(c.token); err != global.ErrTokenMalformed {
+				t.Fatalf("期望错误%v，实际为%v", global.ErrTokenMalformed, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package jwt_eddsa
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+
+	"local/claimset"
+	"local/global"
+	"local/keyutil"
+
+	"github.com/pascaldekloe/jwt"
+	"github.com/rs/zerolog/log"
+)
+
+type Instance struct {
+	Expires       int64              `json:"expires"`
+	KeyID         string             `json:"key_id,omitempty"`
+	PublicKey     ed25519.PublicKey  `json:"-"`
+	PrivateKey    ed25519.PrivateKey `json:"-"`
+	PrivateKeyStr string             `json:"private_key"`
+	PublicKeyStr  string             `json:"public_key,omitempty"`  // 可选，传入时必须与私钥匹配
+	Certificate   string             `json:"certificate,omitempty"` // X.509证书链，PEM或Base64编码
+	X5C           bool               `json:"x5c,omitempty"`         // 在header中嵌入证书链
+	X5T           bool               `json:"x5t,omitempty"`         // 在header中嵌入证书的SHA-1指纹
+	ExtraHeaders  []json.RawMessage  `json:"-"`
+}
+
+func New(config string) (*Instance, error) {
+	var instance Instance
+	err := json.Unmarshal(global.StrToBytes(config), &instance)
+	if err != nil {
+		return nil, err
+	}
+	if instance.PrivateKeyStr == "" {
+		return nil, errors.New("private_key不能为空")
+	}
+	// 转换私钥，支持原始种子、PEM和PKCS8格式
+	instance.PrivateKey, err = keyutil.ParseEd25519PrivateKey(instance.PrivateKeyStr)
+	if err != nil {
+		return nil, errors.New("无效的Ed25519私钥：" + err.Error())
+	}
+	instance.PublicKey = instance.PrivateKey.Public().(ed25519.PublicKey)
+	if err = keyutil.CheckPublicKey(instance.PublicKeyStr, instance.PublicKey); err != nil {
+		return nil, err
+	}
+
+	// 生成证书相关的header
+	instance.ExtraHeaders, err = keyutil.CertHeaders(instance.Certificate, instance.PrivateKeyStr, instance.PrivateKey.Public(), instance.X5C, instance.X5T)
+	if err != nil {
+		return nil, errors.New("无效的证书：" + err.Error())
+	}
+
+	// 未设置key_id时使用公钥的指纹(RFC 7638)做为kid，与JWKS中的kid一致
+	if instance.KeyID == "" {
+		if instance.KeyID, err = keyutil.Thumbprint(instance.ExportPublicKey()); err != nil {
+			return nil, err
+		}
+	}
+
+	return &instance, nil
+}
+
+func (receiver *Instance) Sign(params global.SignParams) (tokenStr string, err error) {
+	var (
+		claims     jwt.Claims
+		tokenBytes []byte
+	)
+	claimset.SetJWT(&claims, params, receiver.Expires)
+	claims.KeyID = receiver.KeyID
+	tokenBytes, err = claims.EdDSASign(receiver.PrivateKey, receiver.ExtraHeaders...)
+	if err != nil {
+		log.Err(err).Caller().Send()
+		return
+	}
+	tokenStr = global.BytesToStr(tokenBytes)
+	return
+}
+
+func (receiver *Instance) VeritySign(tokenStr string) (global.AuthorizerClaims, error) {
+	var claims global.AuthorizerClaims
+	// 解密得到claims
+	jwtClaims, err := jwt.EdDSACheck(global.StrToBytes(tokenStr), receiver.PublicKey)
+	if err != nil {
+		log.Debug().Err(err).Caller().Send()
+		return claims, claimset.CheckError(err)
+	}
+	claims = claimset.FromJWT(jwtClaims)
+	return claims, nil
+}
+
+// 导出公钥
+func (receiver *Instance) ExportPublicKey() crypto.PublicKey {
+	return receiver.PublicKey
+}
+
+// 导出header中的kid
+func (receiver *Instance) ExportKeyID() string {
+	return receiver.KeyID
+}
+
+// 生成密钥配置
+func GenerateKey() (string, error) {
+	privateKeyStr, publicKeyStr, err := keyutil.GenerateEd25519Key()
+	if err != nil {
+		return "", err
+	}
+	return keyutil.EncodeConfig(map[string]string{"private_key": privateKeyStr, "public_key": publicKeyStr})
+}
@@ -0,0 +1,77 @@
+package jwt_hmac
+
+import (
+	"encoding/json"
+	"errors"
+	"local/claimset"
+	"local/global"
+	"local/keyutil"
+
+	"github.com/pascaldekloe/jwt"
+	"github.com/rs/zerolog/log"
+)
+
+type Instance struct {
+	Alg     string `json:"-"`
+	Expires int64  `json:"expires"`
+	KeyID   string `json:"key_id,omitempty"`
+	Secret  string `json:"secret"`
+}
+
+// alg的值为HS256、HS384、HS512之一
+func New(alg, config string) (*Instance, error) {
+	var instance Instance
+	err := json.Unmarshal(global.StrToBytes(config), &instance)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := jwt.HMACAlgs[alg]; !ok {
+		return nil, errors.New("不支持的HMAC算法：" + alg)
+	}
+	instance.Alg = alg
+	return &instance, nil
+}
+
+func (receiver *Instance) Sign(params global.SignParams) (tokenStr string, err error) {
+	var (
+		claims     jwt.Claims
+		tokenBytes []byte
+	)
+	claimset.SetJWT(&claims, params, receiver.Expires)
+	claims.KeyID = receiver.KeyID
+	tokenBytes, err = claims.HMACSign(receiver.Alg, global.StrToBytes(receiver.Secret))
+	if err != nil {
+		log.Err(err).Caller().Send()
+		return
+	}
+	tokenStr = global.BytesToStr(tokenBytes)
+	return
+}
+
+func (receiver *Instance) VeritySign(tokenStr string) (global.AuthorizerClaims, error) {
+	var claims global.AuthorizerClaims
+	// 只接受实例算法的token
+	if err := claimset.CheckAlg(global.StrToBytes(tokenStr), receiver.Alg); err != nil {
+		return claims, err
+	}
+	// 解密得到claims
+	jwtClaims, err := jwt.HMACCheck(global.StrToBytes(tokenStr), global.StrToBytes(receiver.Secret))
+	if err != nil {
+		return claims, claimset.CheckError(err)
+	}
+	claims = claimset.FromJWT(jwtClaims)
+	return claims, nil
+}
+
+// 生成密钥配置，secret的长度与哈希长度一致
+func GenerateKey(alg string) (string, error) {
+	hash, ok := jwt.HMACAlgs[alg]
+	if !ok {
+		return "", errors.New("不支持的HMAC算法：" + alg)
+	}
+	secret, err := keyutil.RandomBase64(hash.Size())
+	if err != nil {
+		return "", err
+	}
+	return keyutil.EncodeConfig(map[string]string{"secret": secret})
+}
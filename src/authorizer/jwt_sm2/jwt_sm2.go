@@ -1,6 +1,7 @@
 package jwt_sm2
 
 import (
+	"crypto"
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
@@ -8,23 +9,47 @@ import (
 	"strings"
 	"time"
 
+	"local/claimset"
 	"local/global"
+	"local/keyutil"
 
 	"github.com/rs/zerolog/log"
 	"github.com/tjfoc/gmsm/sm2"
 )
 
 type Instance struct {
-	Expires       int64           `json:"expires"`
-	PrivateKey    *sm2.PrivateKey `json:"-"`
-	PrivateKeyStr string          `json:"private_key"`
+	Expires       int64             `json:"expires"`
+	KeyID         string            `json:"key_id,omitempty"`
+	PrivateKey    *sm2.PrivateKey   `json:"-"`
+	PrivateKeyStr string            `json:"private_key"`
+	PublicKeyStr  string            `json:"public_key,omitempty"`  // 可选，传入时必须与私钥匹配
+	Certificate   string            `json:"certificate,omitempty"` // X.509证书链(支持SM2证书)，PEM或Base64编码
+	X5C           bool              `json:"x5c,omitempty"`         // 在header中嵌入证书链
+	X5T           bool              `json:"x5t,omitempty"`         // 在header中嵌入证书的SHA-1指纹
+	ExtraHeaders  []json.RawMessage `json:"-"`
+}
+
+type _Header struct {
+	Alg   string   `json:"alg"`
+	Enc   string   `json:"enc,omitempty"`
+	Typ   string   `json:"typ"`
+	KeyID string   `json:"kid,omitempty"`
+	X5C   []string `json:"x5c,omitempty"`
+	X5T   string   `json:"x5t,omitempty"`
 }
 
 type _Claims struct {
-	Expires int64  `json:"expires,omitempty"`
-	Aud     string `json:"aud,omitempty"`
-	Payload string `json:"payload,omitempty"`
-	IP      string `json:"ip,omitempty"`
+	Expires   int64                  `json:"expires,omitempty"`
+	IssuedAt  int64                  `json:"iat,omitempty"`
+	NotBefore int64                  `json:"nbf,omitempty"`
+	Issuer    string                 `json:"iss,omitempty"`
+	Subject   string                 `json:"sub,omitempty"`
+	ID        string                 `json:"jti,omitempty"`
+	Aud       string                 `json:"aud,omitempty"`
+	Payload   string                 `json:"payload,omitempty"`
+	IP        string                 `json:"ip,omitempty"`
+	Scope     string                 `json:"scope,omitempty"`
+	Custom    map[string]interface{} `json:"-"` // 自定义claims
 }
 
 func New(config string) (*Instance, error) {
@@ -37,12 +62,27 @@ func New(config string) (*Instance, error) {
 		return nil, errors.New("private_key不能为空")
 	}
 	// 转换私钥
-	instance.PrivateKey, err = base64ToSM2PrivateKey(instance.PrivateKeyStr)
+	instance.PrivateKey, err = keyutil.ParseSM2PrivateKey(instance.PrivateKeyStr)
 	if err != nil {
-		return nil, errors.New("无效的SM2私钥Base64字符串：" + err.Error())
+		return nil, errors.New("无效的SM2私钥：" + err.Error())
+	}
+	if err = keyutil.CheckPublicKey(instance.PublicKeyStr, &instance.PrivateKey.PublicKey); err != nil {
+		return nil, err
+	}
+	// 生成证书相关的header
+	instance.ExtraHeaders, err = keyutil.CertHeaders(instance.Certificate, instance.PrivateKeyStr, &instance.PrivateKey.PublicKey, instance.X5C, instance.X5T)
+	if err != nil {
+		return nil, errors.New("无效的证书：" + err.Error())
 	}
 
-	return &instance, err
+	// 未设置key_id时使用公钥的指纹(RFC 7638)做为kid，与JWKS中的kid一致
+	if instance.KeyID == "" {
+		if instance.KeyID, err = keyutil.Thumbprint(instance.ExportPublicKey()); err != nil {
+			return nil, err
+		}
+	}
+
+	return &instance, nil
 }
 
 func (receiver *Instance) Sign(params global.SignParams) (tokenStr string, err error) {
@@ -53,9 +93,13 @@ func (receiver *Instance) Sign(params global.SignParams) (tokenStr string, err e
 		token       strings.Builder
 		signBytes   []byte
 	)
-	if receiver.Expires > 0 {
-		claims.Expires = time.Now().Add(time.Duration(receiver.Expires) * time.Second).Unix()
-	}
+	now := time.Now()
+	claims.IssuedAt = now.Unix()
+	claims.Expires = claimset.ExpiresAt(now, receiver.Expires, params.Expires)
+	claims.NotBefore = params.NotBefore
+	claims.Issuer = params.Issuer
+	claims.Subject = params.Subject
+	claims.ID = params.ID
 	if params.Payload != "" {
 		claims.Payload = params.Payload
 	}
@@ -65,14 +109,26 @@ func (receiver *Instance) Sign(params global.SignParams) (tokenStr string, err e
 	if params.IP != "" {
 		claims.IP = params.IP
 	}
+	if params.Scope != "" {
+		claims.Scope = params.Scope
+	}
 	// header部份
-	header = `{"alg":"SM2","typ":"JWT"}`
+	header, err = buildHeader("SM2", "", receiver.KeyID, receiver.ExtraHeaders...)
+	if err != nil {
+		log.Err(err).Caller().Send()
+		return
+	}
 	// payload部份
 	claimsBytes, err = json.Marshal(&claims)
 	if err != nil {
 		log.Err(err).Caller().Send()
 		return
 	}
+	claimsBytes, err = claimset.Merge(claimsBytes, params.Claims)
+	if err != nil {
+		log.Err(err).Caller().Send()
+		return
+	}
 	token.WriteString(base64.RawURLEncoding.EncodeToString(global.StrToBytes(header)))
 	token.WriteString(".")
 	token.WriteString(base64.RawURLEncoding.EncodeToString(claimsBytes))
@@ -91,66 +147,117 @@ func (receiver *Instance) Sign(params global.SignParams) (tokenStr string, err e
 	return
 }
 
-func (receiver *Instance) VeritySign(tokenStr string) (global.AuthorizerClaims, bool) {
+func (receiver *Instance) VeritySign(tokenStr string) (global.AuthorizerClaims, error) {
 	var claims global.AuthorizerClaims
 	jwtClaims, err := parseClaims(receiver.PrivateKey, tokenStr)
 	if err != nil {
-		return claims, false
+		return claims, err
 	}
 	claims.Expires = jwtClaims.Expires
+	claims.IssuedAt = jwtClaims.IssuedAt
+	claims.NotBefore = jwtClaims.NotBefore
+	claims.Issuer = jwtClaims.Issuer
+	claims.Subject = jwtClaims.Subject
+	claims.ID = jwtClaims.ID
 	claims.Payload = jwtClaims.Payload
 	claims.Aud = jwtClaims.Aud
 	claims.IP = jwtClaims.IP
-	return claims, true
+	claims.Scope = jwtClaims.Scope
+	claims.Claims = jwtClaims.Custom
+	return claims, nil
 }
 
-// base64转sm2私钥
-func base64ToSM2PrivateKey(privateKeyStr string) (*sm2.PrivateKey, error) {
-	var (
-		err        error
-		decoded    []byte
-		privateKey *sm2.PrivateKey
-	)
-	decoded, err = base64.RawURLEncoding.DecodeString(privateKeyStr)
-	if err != nil {
-		return nil, err
-	}
-	privateKey, err = sm2.ParsePKCS8PrivateKey(decoded, nil)
-	if err != nil {
-		return nil, err
-	}
-	return privateKey, nil
+// 导出公钥
+func (receiver *Instance) ExportPublicKey() crypto.PublicKey {
+	return &receiver.PrivateKey.PublicKey
+}
+
+// 导出header中的kid
+func (receiver *Instance) ExportKeyID() string {
+	return receiver.KeyID
 }
 
 func parseClaims(key *sm2.PrivateKey, tokenStr string) (claims _Claims, err error) {
 	var claimsBytes, signBytes []byte
 	arr := strings.Split(tokenStr, ".")
 	if len(arr) != 3 {
-		err = errors.New("token无效")
+		err = global.ErrTokenMalformed
 		log.Err(err).Caller().Send()
 		return
 	}
+	// 只接受与实例一致的算法
+	if err = checkHeader(arr[0], "SM2"); err != nil {
+		return
+	}
 	claimsBytes, err = base64.RawURLEncoding.DecodeString(arr[1])
 	if err != nil {
 		log.Err(err).Caller().Send()
+		err = global.ErrTokenMalformed
 		return
 	}
 	signBytes, err = base64.RawURLEncoding.DecodeString(arr[2])
 	if err != nil {
 		log.Err(err).Caller().Send()
+		err = global.ErrTokenMalformed
 		return
 	}
 	msg := arr[0] + "." + arr[1]
 	// 用私钥验签(也可以用公钥)
 	if !key.Verify(global.StrToBytes(msg), signBytes) {
-		err = errors.New("签名无效")
+		err = global.ErrSignatureInvalid
 		log.Err(err).Caller().Send()
 		return
 	}
 	// 解析claims
 	if err = json.Unmarshal(claimsBytes, &claims); err != nil {
 		log.Err(err).Caller().Send()
+		err = global.ErrTokenMalformed
+		return
+	}
+	if claims.Custom, err = claimset.Extract(claimsBytes); err != nil {
+		log.Err(err).Caller().Send()
+		err = global.ErrTokenMalformed
 		return
 	}
 	return
 }
+
+// 生成header部份，extraHeaders中的字段会合并到header中
+func buildHeader(alg, enc, keyID string, extraHeaders ...json.RawMessage) (string, error) {
+	header := _Header{Alg: alg, Enc: enc, Typ: "JWT", KeyID: keyID}
+	for k := range extraHeaders {
+		if err := json.Unmarshal(extraHeaders[k], &header); err != nil {
+			return "", err
+		}
+	}
+	headerBytes, err := json.Marshal(&header)
+	if err != nil {
+		return "", err
+	}
+	return global.BytesToStr(headerBytes), nil
+}
+
+// 生成密钥配置
+func GenerateKey() (string, error) {
+	privateKeyStr, publicKeyStr, err := keyutil.GenerateSM2Key()
+	if err != nil {
+		return "", err
+	}
+	return keyutil.EncodeConfig(map[string]string{"private_key": privateKeyStr, "public_key": publicKeyStr})
+}
+
+// 校验header中的算法
+func checkHeader(segment, alg string) error {
+	var header _Header
+	headerBytes, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return global.ErrTokenMalformed
+	}
+	if err = json.Unmarshal(headerBytes, &header); err != nil {
+		return global.ErrTokenMalformed
+	}
+	if header.Alg != alg {
+		return global.ErrAlgorithmMismatch
+	}
+	return nil
+}
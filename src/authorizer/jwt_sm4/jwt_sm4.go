@@ -3,6 +3,7 @@ package jwt_sm4
 import (
 	"bytes"
 	"crypto/cipher"
+	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
@@ -12,22 +13,40 @@ import (
 
 	"github.com/tjfoc/gmsm/sm4"
 
+	"local/claimset"
 	"local/global"
+	"local/keyutil"
 
 	"github.com/rs/zerolog/log"
 )
 
 type Instance struct {
-	Expires int64  `json:"expires,omitempty"`
-	Key     string `json:"key"`
-	IV      string `json:"iv,omitempty"`
+	Expires       int64  `json:"expires,omitempty"`
+	KeyID         string `json:"key_id,omitempty"`
+	Key           string `json:"key"`
+	IV            string `json:"iv,omitempty"`
+	EncryptClaims bool   `json:"encrypt_claims,omitempty"` // 使用SM4-GCM加密claims部份，使payload不可读
+}
+
+type _Header struct {
+	Alg   string `json:"alg"`
+	Enc   string `json:"enc,omitempty"`
+	Typ   string `json:"typ"`
+	KeyID string `json:"kid,omitempty"`
 }
 
 type _Claims struct {
-	Expires int64  `json:"expires,omitempty"`
-	Aud     string `json:"aud,omitempty"`
-	Payload string `json:"payload,omitempty"`
-	IP      string `json:"ip,omitempty"`
+	Expires   int64                  `json:"expires,omitempty"`
+	IssuedAt  int64                  `json:"iat,omitempty"`
+	NotBefore int64                  `json:"nbf,omitempty"`
+	Issuer    string                 `json:"iss,omitempty"`
+	Subject   string                 `json:"sub,omitempty"`
+	ID        string                 `json:"jti,omitempty"`
+	Aud       string                 `json:"aud,omitempty"`
+	Payload   string                 `json:"payload,omitempty"`
+	IP        string                 `json:"ip,omitempty"`
+	Scope     string                 `json:"scope,omitempty"`
+	Custom    map[string]interface{} `json:"-"` // 自定义claims
 }
 
 func New(config string) (*Instance, error) {
@@ -55,9 +74,13 @@ func (receiver *Instance) Sign(params global.SignParams) (tokenStr string, err e
 		token       strings.Builder
 		signBytes   []byte
 	)
-	if receiver.Expires > 0 {
-		claims.Expires = time.Now().Add(time.Duration(receiver.Expires) * time.Second).Unix()
-	}
+	now := time.Now()
+	claims.IssuedAt = now.Unix()
+	claims.Expires = claimset.ExpiresAt(now, receiver.Expires, params.Expires)
+	claims.NotBefore = params.NotBefore
+	claims.Issuer = params.Issuer
+	claims.Subject = params.Subject
+	claims.ID = params.ID
 	if params.Payload != "" {
 		claims.Payload = params.Payload
 	}
@@ -67,14 +90,38 @@ func (receiver *Instance) Sign(params global.SignParams) (tokenStr string, err e
 	if params.IP != "" {
 		claims.IP = params.IP
 	}
+	if params.Scope != "" {
+		claims.Scope = params.Scope
+	}
 	// header部份
-	header = `{"alg":"SM4","typ":"JWT"}`
+	header, err = buildHeader("SM4", "", receiver.KeyID)
+	if err != nil {
+		log.Err(err).Caller().Send()
+		return
+	}
 	// payload部份
 	claimsBytes, err = json.Marshal(&claims)
 	if err != nil {
 		log.Err(err).Caller().Send()
 		return
 	}
+	claimsBytes, err = claimset.Merge(claimsBytes, params.Claims)
+	if err != nil {
+		log.Err(err).Caller().Send()
+		return
+	}
+	if receiver.EncryptClaims {
+		header, err = buildHeader("SM4", "SM4-GCM", receiver.KeyID)
+		if err != nil {
+			log.Err(err).Caller().Send()
+			return
+		}
+		claimsBytes, err = sm4GCMEncrypt(global.StrToBytes(receiver.Key), claimsBytes, global.StrToBytes(header))
+		if err != nil {
+			log.Err(err).Caller().Send()
+			return
+		}
+	}
 	token.WriteString(base64.RawURLEncoding.EncodeToString(global.StrToBytes(header)))
 	token.WriteString(".")
 	token.WriteString(base64.RawURLEncoding.EncodeToString(claimsBytes))
@@ -93,52 +140,86 @@ func (receiver *Instance) Sign(params global.SignParams) (tokenStr string, err e
 	return
 }
 
-func (receiver *Instance) VeritySign(tokenStr string) (global.AuthorizerClaims, bool) {
+func (receiver *Instance) VeritySign(tokenStr string) (global.AuthorizerClaims, error) {
 	var claims global.AuthorizerClaims
-	jwtClaims, err := parseClaims(receiver.Key, receiver.IV, tokenStr)
+	jwtClaims, err := parseClaims(receiver, tokenStr)
 	if err != nil {
-		return claims, false
+		return claims, err
 	}
 	claims.Expires = jwtClaims.Expires
+	claims.IssuedAt = jwtClaims.IssuedAt
+	claims.NotBefore = jwtClaims.NotBefore
+	claims.Issuer = jwtClaims.Issuer
+	claims.Subject = jwtClaims.Subject
+	claims.ID = jwtClaims.ID
 	claims.Payload = jwtClaims.Payload
 	claims.Aud = jwtClaims.Aud
 	claims.IP = jwtClaims.IP
-	return claims, true
+	claims.Scope = jwtClaims.Scope
+	claims.Claims = jwtClaims.Custom
+	return claims, nil
 }
 
-func parseClaims(key string, iv string, tokenStr string) (claims _Claims, err error) {
-	var claimsBytes, signBytes, plainTextBytes []byte
+func parseClaims(receiver *Instance, tokenStr string) (claims _Claims, err error) {
+	var headerBytes, claimsBytes, signBytes, plainTextBytes []byte
 	arr := strings.Split(tokenStr, ".")
 	if len(arr) != 3 {
-		err = errors.New("token无效")
+		err = global.ErrTokenMalformed
 		log.Err(err).Caller().Send()
 		return
 	}
+	// 只接受与实例一致的算法和claims加密方式
+	enc := ""
+	if receiver.EncryptClaims {
+		enc = "SM4-GCM"
+	}
+	if err = checkHeader(arr[0], "SM4", enc); err != nil {
+		return
+	}
 	claimsBytes, err = base64.RawURLEncoding.DecodeString(arr[1])
 	if err != nil {
 		log.Err(err).Caller().Send()
+		err = global.ErrTokenMalformed
 		return
 	}
 	signBytes, err = base64.RawURLEncoding.DecodeString(arr[2])
 	if err != nil {
+		err = global.ErrTokenMalformed
 		return
 	}
 	// 加密前的明文[base64(header).base64(claims)]
 	msg := arr[0] + "." + arr[1]
 	// 使用key解密签名部分
-	plainTextBytes, err = sm4Decrypt(global.StrToBytes(key), global.StrToBytes(iv), signBytes)
+	plainTextBytes, err = sm4Decrypt(global.StrToBytes(receiver.Key), global.StrToBytes(receiver.IV), signBytes)
 	if err != nil {
-		err = errors.New("签名无效")
+		err = global.ErrSignatureInvalid
 		return
 	}
 	// 比较解密后的明文是否等于[header.claims]
 	if global.BytesToStr(plainTextBytes) != msg {
-		err = errors.New("签名无效")
+		err = global.ErrSignatureInvalid
 		return
 	}
+	// 解密并认证claims，以实例的配置为准，不接受未加密的claims
+	if receiver.EncryptClaims {
+		if headerBytes, err = base64.RawURLEncoding.DecodeString(arr[0]); err != nil {
+			err = global.ErrTokenMalformed
+			return
+		}
+		if claimsBytes, err = sm4GCMDecrypt(global.StrToBytes(receiver.Key), claimsBytes, headerBytes); err != nil {
+			err = global.ErrSignatureInvalid
+			return
+		}
+	}
 	// 解析claims
 	if err = json.Unmarshal(claimsBytes, &claims); err != nil {
 		log.Err(err).Caller().Send()
+		err = global.ErrTokenMalformed
+		return
+	}
+	if claims.Custom, err = claimset.Extract(claimsBytes); err != nil {
+		log.Err(err).Caller().Send()
+		err = global.ErrTokenMalformed
 		return
 	}
 	return
@@ -167,6 +248,9 @@ func sm4Decrypt(key, iv, cipherText []byte) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	if len(cipherText) == 0 || len(cipherText)%block.BlockSize() != 0 {
+		return nil, errors.New("密文长度无效")
+	}
 	blockMode := cipher.NewCBCDecrypter(block, iv)
 	origData := make([]byte, len(cipherText))
 	blockMode.CryptBlocks(origData, cipherText)
@@ -174,6 +258,39 @@ func sm4Decrypt(key, iv, cipherText []byte) ([]byte, error) {
 	return origData, nil
 }
 
+// SM4-GCM加密，返回nonce+密文
+func sm4GCMEncrypt(key, plainText, additionalData []byte) ([]byte, error) {
+	block, err := sm4.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plainText)+aead.Overhead())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plainText, additionalData), nil
+}
+
+// SM4-GCM解密，传入nonce+密文
+func sm4GCMDecrypt(key, cipherText, additionalData []byte) ([]byte, error) {
+	block, err := sm4.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(cipherText) < aead.NonceSize() {
+		return nil, errors.New("密文长度无效")
+	}
+	return aead.Open(nil, cipherText[:aead.NonceSize()], cipherText[aead.NonceSize():], additionalData)
+}
+
 // pkcs5填充
 func pkcs5Padding(src []byte, blockSize int) []byte {
 	padding := blockSize - len(src)%blockSize
@@ -187,5 +304,47 @@ func pkcs5UnPadding(src []byte) []byte {
 		return nil
 	}
 	unpadding := int(src[length-1])
+	// 填充无效时返回nil，防止篡改的数据导致越界
+	if unpadding == 0 || unpadding > length {
+		return nil
+	}
 	return src[:(length - unpadding)]
 }
+
+// 生成header部份
+func buildHeader(alg, enc, keyID string) (string, error) {
+	headerBytes, err := json.Marshal(&_Header{Alg: alg, Enc: enc, Typ: "JWT", KeyID: keyID})
+	if err != nil {
+		return "", err
+	}
+	return global.BytesToStr(headerBytes), nil
+}
+
+// 生成密钥配置，key和iv都是16个字符
+func GenerateKey() (string, error) {
+	key, err := keyutil.RandomBase64(12)
+	if err != nil {
+		return "", err
+	}
+	iv, err := keyutil.RandomBase64(12)
+	if err != nil {
+		return "", err
+	}
+	return keyutil.EncodeConfig(map[string]string{"key": key, "iv": iv})
+}
+
+// 校验header中的算法和claims的加密方式
+func checkHeader(segment, alg, enc string) error {
+	var header _Header
+	headerBytes, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return global.ErrTokenMalformed
+	}
+	if err = json.Unmarshal(headerBytes, &header); err != nil {
+		return global.ErrTokenMalformed
+	}
+	if header.Alg != alg || header.Enc != enc {
+		return global.ErrAlgorithmMismatch
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package jwt_sm4
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"testing"
+
+	"local/global"
+)
+
+const (
+	testKey   = "1234567890abcdef"
+	testIV    = "fedcba0987654321"
+	testOther = "abcdef1234567890"
+)
+
+func TestSM4GCM(t *testing.T) {
+	plainText := []byte(`{"sub":"user1"}`)
+	aad := []byte(`{"alg":"SM4","enc":"SM4-GCM","typ":"JWT"}`)
+	cipherText, err := sm4GCMEncrypt([]byte(testKey), plainText, aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 每次加密使用不同的nonce
+	other, err := sm4GCMEncrypt([]byte(testKey), plainText, aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(cipherText, other) {
+		t.Fatal("两次加密的结果相同")
+	}
+	result, err := sm4GCMDecrypt([]byte(testKey), cipherText, aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(result, plainText) {
+		t.Fatalf("解密结果不一致：%s", result)
+	}
+
+	cases := []struct {
+		name       string
+		key        []byte
+		cipherText []byte
+		aad        []byte
+	}{
+		{"篡改的nonce", []byte(testKey), flip(cipherText, 0), aad},
+		{"篡改的密文", []byte(testKey), flip(cipherText, 12), aad},
+		{"篡改的认证标签", []byte(testKey), flip(cipherText, len(cipherText)-1), aad},
+		{"错误的附加认证数据", []byte(testKey), cipherText, []byte(`{"alg":"SM4","typ":"JWT"}`)},
+		{"错误的密钥", []byte(testOther), cipherText, aad},
+		{"过短的密文", []byte(testKey), cipherText[:8], aad},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if _, err := sm4GCMDecrypt(c.key, c.cipherText, c.aad); err == nil {
+				t.Fatal("期望解密失败")
+			}
+		})
+	}
+}
+
+// 复制数据并修改指定位置的一个bit
+func flip(data []byte, index int) []byte {
+	result := make([]byte, len(data))
+	copy(result, data)
+	result[index] ^= 1
+	return result
+}
+
+func newInstance(t *testing.T, key string, encryptClaims bool) *Instance {
+	t.Helper()
+	instance, err := New(`{"key":"` + key + `","iv":"` + testIV + `","encrypt_claims":` + strconv.FormatBool(encryptClaims) + `}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return instance
+}
+
+func sign(t *testing.T, instance *Instance) string {
+	t.Helper()
+	tokenStr, err := instance.Sign(global.SignParams{Subject: "user1", Payload: "secret", Claims: map[string]interface{}{"role": "admin"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tokenStr
+}
+
+func TestEncryptClaims(t *testing.T) {
+	instance := newInstance(t, testKey, true)
+	tokenStr := sign(t, instance)
+	claimsBytes, err := base64.RawURLEncoding.DecodeString(strings.Split(tokenStr, ".")[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(claimsBytes, []byte("secret")) {
+		t.Fatal("claims未加密")
+	}
+	claims, err := instance.VeritySign(tokenStr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "user1" || claims.Payload != "secret" || claims.Claims["role"] != "admin" {
+		t.Fatalf("claims不一致：%+v", claims)
+	}
+}
+
+func TestWrongEnc(t *testing.T) {
+	plain := newInstance(t, testKey, false)
+	encrypted := newInstance(t, testKey, true)
+	// 加密claims的实例不接受未加密的claims，反之亦然
+	if _, err := encrypted.VeritySign(sign(t, plain)); err != global.ErrAlgorithmMismatch {
+		t.Fatalf("期望错误%v，实际为%v", global.ErrAlgorithmMismatch, err)
+	}
+	if _, err := plain.VeritySign(sign(t, encrypted)); err != global.ErrAlgorithmMismatch {
+		t.Fatalf("期望错误%v，实际为%v", global.ErrAlgorithmMismatch, err)
+	}
+}
+
+func TestTamper(t *testing.T) {
+	for _, encrypt := range []bool{false, true} {
+		instance := newInstance(t, testKey, encrypt)
+		arr := strings.Split(sign(t, instance), ".")
+		claimsBytes, err := base64.RawURLEncoding.DecodeString(arr[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		tampered := arr[0] + "." + base64.RawURLEncoding.EncodeToString(flip(claimsBytes, len(claimsBytes)-2)) + "." + arr[2]
+		if _, err = instance.VeritySign(tampered); err != global.ErrSignatureInvalid {
+			t.Fatalf("期望错误%v，实际为%v", global.ErrSignatureInvalid, err)
+		}
+		if _, err = newInstance(t, testOther, encrypt).VeritySign(strings.Join(arr, ".")); err != global.ErrSignatureInvalid {
+			t.Fatalf("期望错误%v，实际为%v", global.ErrSignatureInvalid, err)
+		}
+	}
+}
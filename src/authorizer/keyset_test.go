@@ -0,0 +1,144 @@
+package authorizer
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"local/global"
+	"local/keyutil"
+)
+
+// 测试用的授权器，返回固定的错误并记录调用顺序
+type fakeInstance struct {
+	id    string
+	err   error
+	calls *[]string
+}
+
+func (self *fakeInstance) Sign(global.SignParams) (string, error) {
+	return "", nil
+}
+
+func (self *fakeInstance) VeritySign(string) (global.AuthorizerClaims, error) {
+	*self.calls = append(*self.calls, self.id)
+	if self.err != nil {
+		return global.AuthorizerClaims{}, self.err
+	}
+	return global.AuthorizerClaims{Subject: self.id}, nil
+}
+
+func TestKeyOrder(t *testing.T) {
+	keys := []global.Key{
+		{ID: "c", Status: global.KeyStatusVerify, Created: 300},
+		{ID: "a", Status: global.KeyStatusVerify, Created: 100},
+		{ID: "active", Status: global.KeyStatusActive, Created: 400},
+		{ID: "b", Status: global.KeyStatusVerify, Created: 200},
+	}
+	if order := keyutil.KeyOrder(keys); !reflect.DeepEqual(order, []string{"active", "a", "b", "c"}) {
+		t.Fatalf("密钥顺序无效：%v", order)
+	}
+}
+
+func TestKeySetVerifyWithoutKeyID(t *testing.T) {
+	errOther := errors.New("其它错误")
+	cases := []struct {
+		name    string
+		errs    []error // 依次为按顺序排列的各密钥的错误
+		calls   []string
+		subject string
+		err     error
+	}{
+		{"第一个密钥验证通过", []error{nil, global.ErrSignatureInvalid, nil}, []string{"k0"}, "k0", nil},
+		{"后面的密钥验证通过", []error{global.ErrSignatureInvalid, global.ErrAlgorithmMismatch, nil}, []string{"k0", "k1", "k2"}, "k2", nil},
+		{"都无法验证签名", []error{global.ErrTokenMalformed, global.ErrSignatureInvalid, global.ErrAlgorithmMismatch}, []string{"k0", "k1", "k2"}, "", global.ErrSignatureInvalid},
+		{"签名通过但已过期", []error{global.ErrSignatureInvalid, global.ErrTokenExpired, nil}, []string{"k0", "k1"}, "", global.ErrTokenExpired},
+		{"签名通过但尚未生效", []error{global.ErrTokenNotYetValid, global.ErrSignatureInvalid, nil}, []string{"k0"}, "", global.ErrTokenNotYetValid},
+		{"其它错误", []error{errOther, nil, nil}, []string{"k0"}, "", errOther},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var calls []string
+			keySet := &KeySet{Keys: make(map[string]global.AuthorizerInstance)}
+			for k := range c.errs {
+				id := "k" + string(rune('0'+k))
+				keySet.Keys[id] = &fakeInstance{id: id, err: c.errs[k], calls: &calls}
+				keySet.Order = append(keySet.Order, id)
+			}
+			// 多次验证的结果一致
+			for i := 0; i < 10; i++ {
+				calls = nil
+				claims, err := keySet.VeritySign("token")
+				if err != c.err || claims.Subject != c.subject {
+					t.Fatalf("期望%v %q，实际%v %q", c.err, c.subject, err, claims.Subject)
+				}
+				if !reflect.DeepEqual(calls, c.calls) {
+					t.Fatalf("尝试密钥的顺序无效：%v", calls)
+				}
+			}
+		})
+	}
+}
+
+func TestKeySetVerify(t *testing.T) {
+	keys := []global.Key{
+		{ID: "old", Config: `{"secret":"111111"}`, Status: global.KeyStatusVerify, Created: 100, Retired: 200},
+		{ID: "new", Config: `{"secret":"222222"}`, Status: global.KeyStatusActive, Created: 200},
+	}
+	instance, err := BuildWithKeys("JWT_HS256", `{"expires":60}`, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 启用密钥集之前使用基础配置签发的没有kid的token
+	legacy, err := Build("JWT_HS256", `{"expires":60,"secret":"111111"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacyToken, err := legacy.Sign(global.SignParams{Subject: "legacy"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := Build("JWT_HS256", `{"expires":60,"secret":"333333"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherToken, err := other.Sign(global.SignParams{Subject: "other"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs384, err := Build("JWT_HS384", `{"expires":60,"secret":"111111"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	algToken, err := hs384.Sign(global.SignParams{Subject: "alg"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	activeToken, err := instance.Sign(global.SignParams{Subject: "active"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyutil.TokenKeyID(activeToken) != "new" {
+		t.Fatalf("未使用活动密钥签发：%s", keyutil.TokenKeyID(activeToken))
+	}
+
+	cases := []struct {
+		name    string
+		token   string
+		subject string
+		err     error
+	}{
+		{"活动密钥", activeToken, "active", nil},
+		{"没有kid的旧token", legacyToken, "legacy", nil},
+		{"其它密钥签发", otherToken, "", global.ErrSignatureInvalid},
+		{"算法不匹配", algToken, "", global.ErrSignatureInvalid},
+		{"格式无效", "abc", "", global.ErrSignatureInvalid},
+		{"kid不存在", `eyJhbGciOiJIUzI1NiIsImtpZCI6Im5vbmUifQ.e30.c2ln`, "", global.ErrSignatureInvalid},
+	}
+	for _, c := range cases {
+		claims, err := instance.VeritySign(c.token)
+		if err != c.err || claims.Subject != c.subject {
+			t.Errorf("%s：期望%v %q，实际%v %q", c.name, c.err, c.subject, err, claims.Subject)
+		}
+	}
+}
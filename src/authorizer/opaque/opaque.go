@@ -0,0 +1,151 @@
+package opaque
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"local/claimset"
+	"local/global"
+
+	"github.com/rs/zerolog/log"
+)
+
+// 不透明token，token本身是随机字符串，claims保存在存储器中，可随时吊销
+
+const tokenSize = 32
+
+// 授权过期后在存储器中保留数据的默认时长(秒)
+const defaultRetention = 30 * 24 * 3600
+
+type Instance struct {
+	Expires   int64 `json:"expires"`
+	Retention int64 `json:"retention,omitempty"` // 授权过期后在存储器中保留数据的时长(秒)，保留期间验证返回授权已过期并可以刷新，为0时使用默认值
+}
+
+type _Claims struct {
+	Expires   int64                  `json:"exp,omitempty"`
+	IssuedAt  int64                  `json:"iat,omitempty"`
+	NotBefore int64                  `json:"nbf,omitempty"`
+	Issuer    string                 `json:"iss,omitempty"`
+	Subject   string                 `json:"sub,omitempty"`
+	ID        string                 `json:"jti,omitempty"`
+	Aud       string                 `json:"aud,omitempty"`
+	Payload   string                 `json:"payload,omitempty"`
+	IP        string                 `json:"ip,omitempty"`
+	Scope     string                 `json:"scope,omitempty"`
+	Claims    map[string]interface{} `json:"claims,omitempty"` // 自定义claims
+}
+
+func New(config string) (*Instance, error) {
+	var instance Instance
+	if config != "" {
+		if err := json.Unmarshal(global.StrToBytes(config), &instance); err != nil {
+			return nil, err
+		}
+	}
+	if instance.Retention < 0 {
+		return nil, errors.New("retention不能小于0")
+	}
+	if instance.Retention == 0 {
+		instance.Retention = defaultRetention
+	}
+	return &instance, nil
+}
+
+func (receiver *Instance) Sign(params global.SignParams) (tokenStr string, err error) {
+	var (
+		claims      _Claims
+		claimsBytes []byte
+		tokenBytes  = make([]byte, tokenSize)
+	)
+	if global.StorageInstance == nil {
+		err = errors.New("存储器未初始化")
+		return
+	}
+	now := time.Now()
+	claims.IssuedAt = now.Unix()
+	claims.Expires = claimset.ExpiresAt(now, receiver.Expires, params.Expires)
+	claims.NotBefore = params.NotBefore
+	claims.Issuer = params.Issuer
+	claims.Subject = params.Subject
+	claims.ID = params.ID
+	claims.Claims = claimset.TokenClaims(params.Claims)
+	claims.Payload = params.Payload
+	claims.Aud = params.Aud
+	claims.IP = params.IP
+	claims.Scope = params.Scope
+	if claimsBytes, err = json.Marshal(&claims); err != nil {
+		log.Err(err).Caller().Send()
+		return
+	}
+	if _, err = rand.Read(tokenBytes); err != nil {
+		log.Err(err).Caller().Send()
+		return
+	}
+	tokenStr = base64.RawURLEncoding.EncodeToString(tokenBytes)
+	// 存储器中只保存token的hash，存储器的数据泄露也无法还原出token
+	// 过期后继续保留一段时间，用于区分已过期和不存在的token，以及刷新已过期的授权
+	var ttl int64
+	if claims.Expires > 0 {
+		ttl = claims.Expires - now.Unix() + receiver.Retention
+	}
+	if err = global.StorageInstance.SaveToken(Hash(tokenStr), claimsBytes, ttl); err != nil {
+		log.Err(err).Caller().Send()
+		return "", err
+	}
+	return
+}
+
+func (receiver *Instance) VeritySign(tokenStr string) (global.AuthorizerClaims, error) {
+	var (
+		claims      global.AuthorizerClaims
+		tokenClaims _Claims
+		claimsBytes []byte
+		err         error
+	)
+	hash := Hash(tokenStr)
+	// 优先从本地缓存中查找，不存在时从存储器中查找
+	if value, exists := global.Tokens.Load(hash); exists {
+		claimsBytes, _ = value.([]byte)
+	} else if global.StorageInstance != nil {
+		if claimsBytes, err = global.StorageInstance.LoadToken(hash); err != nil {
+			log.Err(err).Caller().Send()
+			return claims, err
+		}
+	}
+	// 存储器中不存在的token视为签名无效
+	if claimsBytes == nil {
+		return claims, global.ErrSignatureInvalid
+	}
+	if err = json.Unmarshal(claimsBytes, &tokenClaims); err != nil {
+		log.Err(err).Caller().Send()
+		return claims, global.ErrTokenMalformed
+	}
+	claims.Expires = tokenClaims.Expires
+	claims.IssuedAt = tokenClaims.IssuedAt
+	claims.NotBefore = tokenClaims.NotBefore
+	claims.Issuer = tokenClaims.Issuer
+	claims.Subject = tokenClaims.Subject
+	claims.ID = tokenClaims.ID
+	claims.Payload = tokenClaims.Payload
+	claims.Aud = tokenClaims.Aud
+	claims.IP = tokenClaims.IP
+	claims.Scope = tokenClaims.Scope
+	claims.Claims = tokenClaims.Claims
+	// 与JWT一样返回claims，刷新授权时使用
+	if claims.Expires != 0 && claims.Expires <= time.Now().Unix() {
+		return claims, global.ErrTokenExpired
+	}
+	return claims, nil
+}
+
+// 计算token的hash，做为存储器中的键名
+func Hash(tokenStr string) string {
+	sum := sha256.Sum256(global.StrToBytes(tokenStr))
+	return hex.EncodeToString(sum[:])
+}
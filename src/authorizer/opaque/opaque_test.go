@@ -0,0 +1,84 @@
+package opaque
+
+import (
+	"testing"
+	"time"
+
+	"local/global"
+)
+
+// 测试用的存储器，只实现不透明token需要的方法
+type tokenStorage struct {
+	global.Storage
+	data map[string][]byte
+	ttl  map[string]int64
+}
+
+func (self *tokenStorage) SaveToken(hash string, data []byte, ttl int64) error {
+	self.data[hash], self.ttl[hash] = data, ttl
+	return nil
+}
+
+func (self *tokenStorage) LoadToken(hash string) ([]byte, error) {
+	return self.data[hash], nil
+}
+
+func TestVeritySign(t *testing.T) {
+	storage := &tokenStorage{data: make(map[string][]byte), ttl: make(map[string]int64)}
+	global.StorageInstance = storage
+	defer func() { global.StorageInstance = nil }()
+
+	instance, err := New(`{"expires":60,"retention":100}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().Unix()
+	valid, err := instance.Sign(global.SignParams{Subject: "valid"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := instance.Sign(global.SignParams{Subject: "expired", Expires: now - 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 存储器中的数据在授权过期后继续保留retention秒
+	if ttl := storage.ttl[Hash(valid)]; ttl < 159 || ttl > 160 {
+		t.Fatalf("数据的生命周期无效：%d", ttl)
+	}
+
+	cases := []struct {
+		name    string
+		token   string
+		subject string
+		err     error
+	}{
+		{"有效", valid, "valid", nil},
+		{"已过期", expired, "expired", global.ErrTokenExpired},
+		{"不存在", "abc", "", global.ErrSignatureInvalid},
+	}
+	for _, c := range cases {
+		claims, err := instance.VeritySign(c.token)
+		if err != c.err || claims.Subject != c.subject {
+			t.Errorf("%s：期望%v %q，实际%v %q", c.name, c.err, c.subject, err, claims.Subject)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	cases := []struct {
+		config    string
+		retention int64
+		err       bool
+	}{
+		{``, defaultRetention, false},
+		{`{"expires":60}`, defaultRetention, false},
+		{`{"expires":60,"retention":10}`, 10, false},
+		{`{"expires":60,"retention":-1}`, 0, true},
+	}
+	for _, c := range cases {
+		instance, err := New(c.config)
+		if (err != nil) != c.err || (err == nil && instance.Retention != c.retention) {
+			t.Errorf("%s：期望%d %v，实际%v %v", c.config, c.retention, c.err, instance, err)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package paseto_v4_local
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"local/claimset"
+	"local/global"
+	"local/keyutil"
+	"local/paseto"
+
+	"github.com/rs/zerolog/log"
+)
+
+type Instance struct {
+	Expires int64  `json:"expires"`
+	KeyStr  string `json:"key"`
+	Key     []byte `json:"-"`
+	KeyID   string `json:"key_id,omitempty"`
+}
+
+type _Claims struct {
+	Expires   string `json:"exp,omitempty"`
+	IssuedAt  string `json:"iat,omitempty"`
+	NotBefore string `json:"nbf,omitempty"`
+	Issuer    string `json:"iss,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	ID        string `json:"jti,omitempty"`
+	Aud       string `json:"aud,omitempty"`
+	Payload   string `json:"payload,omitempty"`
+	IP        string `json:"ip,omitempty"`
+	Scope     string `json:"scope,omitempty"`
+}
+
+type _Footer struct {
+	KeyID string `json:"kid,omitempty"`
+}
+
+func New(config string) (*Instance, error) {
+	var instance Instance
+	err := json.Unmarshal(global.StrToBytes(config), &instance)
+	if err != nil {
+		return nil, err
+	}
+	if instance.KeyStr == "" {
+		return nil, errors.New("key不能为空")
+	}
+	instance.Key, err = base64.RawURLEncoding.DecodeString(instance.KeyStr)
+	if err != nil {
+		return nil, errors.New("无效的key Base64字符串：" + err.Error())
+	}
+	if len(instance.Key) != 32 {
+		return nil, errors.New("key的长度必须是32字节")
+	}
+	return &instance, nil
+}
+
+func (receiver *Instance) Sign(params global.SignParams) (tokenStr string, err error) {
+	var (
+		claims      _Claims
+		claimsBytes []byte
+		footerBytes []byte
+		now         = time.Now()
+	)
+	claims.IssuedAt = now.Format(time.RFC3339)
+	claims.Expires = paseto.FormatTime(claimset.ExpiresAt(now, receiver.Expires, params.Expires))
+	claims.NotBefore = paseto.FormatTime(params.NotBefore)
+	claims.Issuer = params.Issuer
+	claims.Subject = params.Subject
+	claims.ID = params.ID
+	claims.Payload = params.Payload
+	claims.Aud = params.Aud
+	claims.IP = params.IP
+	claims.Scope = params.Scope
+	claimsBytes, err = json.Marshal(&claims)
+	if err != nil {
+		log.Err(err).Caller().Send()
+		return
+	}
+	claimsBytes, err = claimset.Merge(claimsBytes, params.Claims)
+	if err != nil {
+		log.Err(err).Caller().Send()
+		return
+	}
+	// footer中携带密钥ID
+	if receiver.KeyID != "" {
+		footerBytes, err = json.Marshal(&_Footer{KeyID: receiver.KeyID})
+		if err != nil {
+			log.Err(err).Caller().Send()
+			return
+		}
+	}
+	tokenStr, err = paseto.V4Encrypt(receiver.Key, claimsBytes, footerBytes, nil)
+	if err != nil {
+		log.Err(err).Caller().Send()
+		return
+	}
+	return
+}
+
+func (receiver *Instance) VeritySign(tokenStr string) (global.AuthorizerClaims, error) {
+	var (
+		claims      global.AuthorizerClaims
+		tokenClaims _Claims
+	)
+	// 解密得到claims
+	claimsBytes, _, err := paseto.V4Decrypt(receiver.Key, tokenStr, nil)
+	if err != nil {
+		log.Debug().Err(err).Caller().Send()
+		return claims, err
+	}
+	if err = json.Unmarshal(claimsBytes, &tokenClaims); err != nil {
+		log.Debug().Err(err).Caller().Send()
+		return claims, global.ErrTokenMalformed
+	}
+	if claims.Expires, err = paseto.ParseTime(tokenClaims.Expires); err != nil {
+		log.Debug().Err(err).Caller().Send()
+		return claims, global.ErrTokenMalformed
+	}
+	if claims.IssuedAt, err = paseto.ParseTime(tokenClaims.IssuedAt); err != nil {
+		log.Debug().Err(err).Caller().Send()
+		return claims, global.ErrTokenMalformed
+	}
+	if claims.NotBefore, err = paseto.ParseTime(tokenClaims.NotBefore); err != nil {
+		log.Debug().Err(err).Caller().Send()
+		return claims, global.ErrTokenMalformed
+	}
+	if claims.Claims, err = claimset.Extract(claimsBytes); err != nil {
+		log.Debug().Err(err).Caller().Send()
+		return claims, global.ErrTokenMalformed
+	}
+	claims.Issuer = tokenClaims.Issuer
+	claims.Subject = tokenClaims.Subject
+	claims.ID = tokenClaims.ID
+	claims.Payload = tokenClaims.Payload
+	claims.Aud = tokenClaims.Aud
+	claims.IP = tokenClaims.IP
+	claims.Scope = tokenClaims.Scope
+	return claims, nil
+}
+
+// 生成密钥配置
+func GenerateKey() (string, error) {
+	key, err := keyutil.RandomBase64(32)
+	if err != nil {
+		return "", err
+	}
+	return keyutil.EncodeConfig(map[string]string{"key": key})
+}
@@ -0,0 +1,226 @@
+package claimset
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"local/global"
+
+	"github.com/pascaldekloe/jwt"
+)
+
+// 保留的claims名称，自定义claims不能使用
+// 值为false的由授权器自身维护，值为true的由服务在签发授权时设置并做为claims写入token
+var reserved = map[string]bool{
+	"exp":        false,
+	"expires":    false,
+	"iat":        false,
+	"nbf":        false,
+	"iss":        false,
+	"sub":        false,
+	"jti":        false,
+	"aud":        false,
+	"payload":    false,
+	"ip":         false,
+	"scope":      false,
+	"token_hash": false,
+	"token_use":  true,
+	"client_id":  true,
+}
+
+// 判断是否为保留的claims名称
+func IsReserved(name string) bool {
+	_, exists := reserved[name]
+	return exists
+}
+
+// 解析JSON对象格式的自定义claims，不能使用保留的名称，为空时返回nil
+func Parse(value string) (map[string]interface{}, error) {
+	var custom map[string]interface{}
+	if value == "" {
+		return nil, nil
+	}
+	if err := json.Unmarshal(global.StrToBytes(value), &custom); err != nil {
+		return nil, errors.New("claims必须是JSON对象")
+	}
+	for k := range custom {
+		if IsReserved(k) {
+			return nil, errors.New("claims不能使用保留的名称：" + k)
+		}
+	}
+	return custom, nil
+}
+
+// 排除保留的claims，返回自定义claims，没有时返回nil
+func Custom(set map[string]interface{}) map[string]interface{} {
+	var custom map[string]interface{}
+	for k, v := range set {
+		if IsReserved(k) {
+			continue
+		}
+		if custom == nil {
+			custom = make(map[string]interface{}, len(set))
+		}
+		custom[k] = v
+	}
+	return custom
+}
+
+// 排除授权器维护的claims，返回写入token的claims，包括自定义claims和服务设置的保留claims，没有时返回nil
+func TokenClaims(set map[string]interface{}) map[string]interface{} {
+	var result map[string]interface{}
+	for k, v := range set {
+		if service, exists := reserved[k]; exists && !service {
+			continue
+		}
+		if result == nil {
+			result = make(map[string]interface{}, len(set))
+		}
+		result[k] = v
+	}
+	return result
+}
+
+// 合并多组自定义claims，后面的同名claims覆盖前面的，忽略保留的claims
+func Combine(sets ...map[string]interface{}) map[string]interface{} {
+	var result map[string]interface{}
+	for k := range sets {
+		for name, value := range Custom(sets[k]) {
+			if result == nil {
+				result = make(map[string]interface{})
+			}
+			result[name] = value
+		}
+	}
+	return result
+}
+
+// 将自定义claims和服务设置的保留claims写入已序列化的claims
+func Merge(claimsBytes []byte, custom map[string]interface{}) ([]byte, error) {
+	custom = TokenClaims(custom)
+	if len(custom) == 0 {
+		return claimsBytes, nil
+	}
+	set := make(map[string]interface{}, len(custom)+8)
+	if err := json.Unmarshal(claimsBytes, &set); err != nil {
+		return nil, err
+	}
+	for k, v := range custom {
+		set[k] = v
+	}
+	return json.Marshal(&set)
+}
+
+// 从已序列化的claims中提取自定义claims和服务设置的保留claims
+func Extract(claimsBytes []byte) (map[string]interface{}, error) {
+	var set map[string]interface{}
+	if err := json.Unmarshal(claimsBytes, &set); err != nil {
+		return nil, err
+	}
+	return TokenClaims(set), nil
+}
+
+// 将签发参数写入JWT的claims，expires为有效期(秒)，为0且签发参数未限制过期时间时不设置exp
+func SetJWT(claims *jwt.Claims, params global.SignParams, expires int64) {
+	now := time.Now()
+	claims.Issued = jwt.NewNumericTime(now)
+	if exp := ExpiresAt(now, expires, params.Expires); exp > 0 {
+		claims.Expires = jwt.NewNumericTime(time.Unix(exp, 0))
+	}
+	if params.NotBefore > 0 {
+		claims.NotBefore = jwt.NewNumericTime(time.Unix(params.NotBefore, 0))
+	}
+	claims.Issuer = params.Issuer
+	claims.Subject = params.Subject
+	claims.ID = params.ID
+	claims.Set = make(map[string]interface{}, len(params.Claims)+4)
+	for k, v := range TokenClaims(params.Claims) {
+		claims.Set[k] = v
+	}
+	if params.Payload != "" {
+		claims.Set["payload"] = params.Payload
+	}
+	if params.Aud != "" {
+		claims.Set["aud"] = params.Aud
+	}
+	if params.IP != "" {
+		claims.Set["ip"] = params.IP
+	}
+	if params.Scope != "" {
+		claims.Set["scope"] = params.Scope
+	}
+}
+
+// 计算授权的过期时间(Unix时间戳)，expires为有效期(秒)，limit为最迟的过期时间，都为0时返回0表示永不过期
+func ExpiresAt(now time.Time, expires, limit int64) int64 {
+	var exp int64
+	if expires > 0 {
+		exp = now.Add(time.Duration(expires) * time.Second).Unix()
+	}
+	if limit > 0 && (exp == 0 || exp > limit) {
+		exp = limit
+	}
+	return exp
+}
+
+// 从JWT的claims得到授权的claims
+func FromJWT(jwtClaims *jwt.Claims) (claims global.AuthorizerClaims) {
+	claims.Expires = Unix(jwtClaims.Expires)
+	claims.IssuedAt = Unix(jwtClaims.Issued)
+	claims.NotBefore = Unix(jwtClaims.NotBefore)
+	claims.Issuer = jwtClaims.Issuer
+	claims.Subject = jwtClaims.Subject
+	claims.ID = jwtClaims.ID
+	claims.Payload, _ = jwtClaims.String("payload")
+	claims.Aud, _ = jwtClaims.String("aud")
+	claims.IP, _ = jwtClaims.String("ip")
+	claims.Scope, _ = jwtClaims.String("scope")
+	claims.Claims = TokenClaims(jwtClaims.Set)
+	return
+}
+
+// 时间转为Unix时间戳，未设置时返回0
+func Unix(t *jwt.NumericTime) int64 {
+	if t == nil {
+		return 0
+	}
+	return t.Time().Unix()
+}
+
+// 将JWT验证的错误转为授权验证的错误
+func CheckError(err error) error {
+	var algErr jwt.AlgError
+	switch {
+	case err == jwt.ErrSigMiss:
+		return global.ErrSignatureInvalid
+	case errors.As(err, &algErr):
+		return global.ErrAlgorithmMismatch
+	}
+	return global.ErrTokenMalformed
+}
+
+// 校验JWT的header中的alg，同一密钥可用于多种算法，只接受与实例算法一致的token
+func CheckAlg(token []byte, alg string) error {
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	end := bytes.IndexByte(token, '.')
+	if end < 0 {
+		return global.ErrTokenMalformed
+	}
+	headerBytes := make([]byte, base64.RawURLEncoding.DecodedLen(end))
+	n, err := base64.RawURLEncoding.Decode(headerBytes, token[:end])
+	if err != nil {
+		return global.ErrTokenMalformed
+	}
+	if err = json.Unmarshal(headerBytes[:n], &header); err != nil {
+		return global.ErrTokenMalformed
+	}
+	if header.Alg != alg {
+		return global.ErrAlgorithmMismatch
+	}
+	return nil
+}
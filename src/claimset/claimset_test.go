@@ -0,0 +1,65 @@
+package claimset
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		name  string
+		value string
+		want  map[string]interface{}
+		err   bool
+	}{
+		{"空字符串", "", nil, false},
+		{"自定义claims", `{"role":"admin","level":1}`, map[string]interface{}{"role": "admin", "level": float64(1)}, false},
+		{"不是JSON对象", `[1]`, nil, true},
+		{"授权器维护的claims", `{"sub":"bob"}`, nil, true},
+		{"token_use", `{"token_use":"client"}`, nil, true},
+		{"ID Token的token_use", `{"token_use":"id"}`, nil, true},
+		{"client_id", `{"client_id":"app"}`, nil, true},
+	}
+	for _, c := range cases {
+		got, err := Parse(c.value)
+		if (err != nil) != c.err || !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s：期望%v %v，实际%v %v", c.name, c.want, c.err, got, err)
+		}
+	}
+}
+
+func TestCustomAndTokenClaims(t *testing.T) {
+	set := map[string]interface{}{
+		"exp":       float64(1),
+		"sub":       "bob",
+		"role":      "admin",
+		"token_use": "client",
+		"client_id": "app",
+	}
+	if got := Custom(set); !reflect.DeepEqual(got, map[string]interface{}{"role": "admin"}) {
+		t.Errorf("Custom应排除所有保留的claims：%v", got)
+	}
+	want := map[string]interface{}{"role": "admin", "token_use": "client", "client_id": "app"}
+	if got := TokenClaims(set); !reflect.DeepEqual(got, want) {
+		t.Errorf("TokenClaims应保留服务设置的claims：%v", got)
+	}
+	// 合并传入的claims时忽略保留的claims
+	got := Combine(map[string]interface{}{"role": "user", "token_use": "client"}, map[string]interface{}{"role": "admin", "client_id": "app"})
+	if !reflect.DeepEqual(got, map[string]interface{}{"role": "admin"}) {
+		t.Errorf("Combine应忽略保留的claims：%v", got)
+	}
+}
+
+func TestMergeExtract(t *testing.T) {
+	claimsBytes, err := Merge([]byte(`{"sub":"bob"}`), map[string]interface{}{"role": "admin", "token_use": "id", "exp": float64(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	custom, err := Extract(claimsBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(custom, map[string]interface{}{"role": "admin", "token_use": "id"}) {
+		t.Fatalf("token中的claims无效：%s %v", claimsBytes, custom)
+	}
+}
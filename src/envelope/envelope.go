@@ -0,0 +1,275 @@
+package envelope
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"local/global"
+
+	"github.com/tjfoc/gmsm/sm4"
+)
+
+// 使用主密钥对规则中的密钥配置进行信封加密，密文格式为 enc:v1:算法:主密钥ID:base64(nonce+密文)
+
+const (
+	AlgAESGCM = "aes-gcm"
+	AlgSM4GCM = "sm4-gcm"
+
+	prefix = "enc:v1:"
+)
+
+// 主密钥
+type MasterKey struct {
+	ID  string
+	Key []byte
+}
+
+var (
+	mutex      sync.RWMutex
+	algorithm  string      // 加密新数据使用的算法
+	masterKeys []MasterKey // 主密钥，第一个用于加密，其它仅用于解密轮换前的数据
+	source     [3]string   // 加载主密钥的参数(算法、文件、环境变量)，用于重新加载
+)
+
+// 加载主密钥，优先从文件读取，其次从环境变量读取，都未配置时不加密
+// 每行一个主密钥，格式为 id=base64密钥，没有id时使用default做为id
+func Load(alg, keyFile, keyEnv string) error {
+	var content string
+	mutex.Lock()
+	source = [3]string{alg, keyFile, keyEnv}
+	mutex.Unlock()
+	switch {
+	case keyFile != "":
+		contentBytes, err := ioutil.ReadFile(filepath.Clean(keyFile))
+		if err != nil {
+			return err
+		}
+		content = string(contentBytes)
+	case keyEnv != "":
+		content = os.Getenv(keyEnv)
+		if content == "" {
+			return errors.New("环境变量" + keyEnv + "的值为空")
+		}
+	default:
+		return nil
+	}
+	if alg == "" {
+		alg = AlgAESGCM
+	}
+	if alg != AlgAESGCM && alg != AlgSM4GCM {
+		return errors.New("不支持的加密算法：" + alg)
+	}
+	keys := make([]MasterKey, 0, 1)
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key := MasterKey{ID: "default"}
+		if pos := strings.IndexByte(line, '='); pos > 0 {
+			key.ID = strings.TrimSpace(line[:pos])
+			line = strings.TrimSpace(line[pos+1:])
+		}
+		if strings.ContainsRune(key.ID, ':') {
+			return errors.New("主密钥ID不能包含冒号：" + key.ID)
+		}
+		keyBytes, err := base64.RawURLEncoding.DecodeString(line)
+		if err != nil {
+			return errors.New("无效的主密钥Base64字符串：" + key.ID)
+		}
+		key.Key = keyBytes
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return errors.New("没有可用的主密钥")
+	}
+	// 校验活动主密钥的长度是否符合算法要求
+	if _, err := newAEAD(alg, keys[0].Key); err != nil {
+		return errors.New("主密钥" + keys[0].ID + "无效：" + err.Error())
+	}
+	mutex.Lock()
+	algorithm = alg
+	masterKeys = keys
+	mutex.Unlock()
+	return nil
+}
+
+// 使用启动时的参数重新加载主密钥，用于在不重启服务的情况下轮换主密钥
+func Reload() error {
+	mutex.RLock()
+	alg, keyFile, keyEnv := source[0], source[1], source[2]
+	mutex.RUnlock()
+	return Load(alg, keyFile, keyEnv)
+}
+
+// 是否启用了加密
+func Enabled() bool {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	return len(masterKeys) > 0
+}
+
+// 获得加密新数据使用的算法和主密钥
+func activeKey() (string, MasterKey, bool) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	if len(masterKeys) == 0 {
+		return "", MasterKey{}, false
+	}
+	return algorithm, masterKeys[0], true
+}
+
+// 根据ID查找主密钥
+func findKey(id string) (MasterKey, bool) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	for k := range masterKeys {
+		if masterKeys[k].ID == id {
+			return masterKeys[k], true
+		}
+	}
+	return MasterKey{}, false
+}
+
+// 加密，未启用加密时原样返回，aad用于将密文与所属数据绑定
+func Encrypt(plainText, aad string) (string, error) {
+	alg, key, enabled := activeKey()
+	if !enabled || plainText == "" {
+		return plainText, nil
+	}
+	aead, err := newAEAD(alg, key.Key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plainText)+aead.Overhead())
+	if _, err = rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, global.StrToBytes(plainText), global.StrToBytes(aad))
+	var value strings.Builder
+	value.WriteString(prefix)
+	value.WriteString(alg)
+	value.WriteString(":")
+	value.WriteString(key.ID)
+	value.WriteString(":")
+	value.WriteString(base64.RawURLEncoding.EncodeToString(sealed))
+	return value.String(), nil
+}
+
+// 解密，非密文(加密启用前保存的数据)原样返回
+func Decrypt(value, aad string) (string, error) {
+	if !strings.HasPrefix(value, prefix) {
+		return value, nil
+	}
+	arr := strings.SplitN(strings.TrimPrefix(value, prefix), ":", 3)
+	if len(arr) != 3 {
+		return "", errors.New("无效的密文格式")
+	}
+	key, exists := findKey(arr[1])
+	if !exists {
+		// 其它节点轮换了主密钥时，重新加载主密钥后再查找
+		if err := Reload(); err != nil {
+			return "", errors.New("找不到主密钥：" + arr[1] + "，重新加载主密钥失败：" + err.Error())
+		}
+		if key, exists = findKey(arr[1]); !exists {
+			return "", errors.New("找不到主密钥：" + arr[1])
+		}
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(arr[2])
+	if err != nil {
+		return "", err
+	}
+	aead, err := newAEAD(arr[0], key.Key)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", errors.New("密文长度无效")
+	}
+	plainBytes, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], global.StrToBytes(aad))
+	if err != nil {
+		return "", errors.New("解密失败，主密钥" + key.ID + "不正确或数据已被篡改")
+	}
+	return string(plainBytes), nil
+}
+
+// 加密规则中的所有密钥配置，rule.Keys会被替换成新的切片，不影响原规则
+func EncryptRule(rule *global.Rule) (err error) {
+	if !Enabled() {
+		return nil
+	}
+	if rule.Authorizer.Config, err = Encrypt(rule.Authorizer.Config, rule.Name); err != nil {
+		return
+	}
+	if rule.Authorizer.Keys, err = encryptKeys(rule.Authorizer.Keys, rule.Name); err != nil {
+		return
+	}
+	if rule.Updater.Config, err = Encrypt(rule.Updater.Config, rule.Name); err != nil {
+		return
+	}
+	rule.Updater.Keys, err = encryptKeys(rule.Updater.Keys, rule.Name)
+	return
+}
+
+// 解密规则中的所有密钥配置
+func DecryptRule(rule *global.Rule) (err error) {
+	if rule.Authorizer.Config, err = Decrypt(rule.Authorizer.Config, rule.Name); err != nil {
+		return
+	}
+	for k := range rule.Authorizer.Keys {
+		if rule.Authorizer.Keys[k].Config, err = Decrypt(rule.Authorizer.Keys[k].Config, rule.Name); err != nil {
+			return
+		}
+	}
+	if rule.Updater.Config, err = Decrypt(rule.Updater.Config, rule.Name); err != nil {
+		return
+	}
+	for k := range rule.Updater.Keys {
+		if rule.Updater.Keys[k].Config, err = Decrypt(rule.Updater.Keys[k].Config, rule.Name); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func encryptKeys(keys []global.Key, aad string) ([]global.Key, error) {
+	if len(keys) == 0 {
+		return keys, nil
+	}
+	var err error
+	encrypted := make([]global.Key, len(keys))
+	copy(encrypted, keys)
+	for k := range encrypted {
+		if encrypted[k].Config, err = Encrypt(encrypted[k].Config, aad); err != nil {
+			return nil, err
+		}
+	}
+	return encrypted, nil
+}
+
+func newAEAD(alg string, key []byte) (cipher.AEAD, error) {
+	var (
+		block cipher.Block
+		err   error
+	)
+	switch alg {
+	case AlgAESGCM:
+		block, err = aes.NewCipher(key)
+	case AlgSM4GCM:
+		block, err = sm4.NewCipher(key)
+	default:
+		return nil, errors.New("不支持的加密算法：" + alg)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,222 @@
+package envelope
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"local/global"
+)
+
+// 生成测试用的主密钥
+func testKey(seed byte, size int) string {
+	return base64.RawURLEncoding.EncodeToString(bytes.Repeat([]byte{seed}, size))
+}
+
+// 写入主密钥文件并加载
+func loadFile(t *testing.T, alg, keyFile, content string) {
+	t.Helper()
+	if err := ioutil.WriteFile(keyFile, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := Load(alg, keyFile, ""); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 清除加载的主密钥，避免影响其它测试
+func reset() {
+	mutex.Lock()
+	algorithm, masterKeys, source = "", nil, [3]string{}
+	mutex.Unlock()
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	defer reset()
+	cases := []struct {
+		alg     string
+		keySize int
+	}{
+		{AlgAESGCM, 32},
+		{AlgSM4GCM, 16},
+	}
+	for _, c := range cases {
+		t.Run(c.alg, func(t *testing.T) {
+			loadFile(t, c.alg, filepath.Join(t.TempDir(), "keys"), "# 注释\nk1="+testKey(1, c.keySize)+"\n")
+			value, err := Encrypt(`{"secret":"123456"}`, "rule1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			// 密文格式为 enc:v1:算法:主密钥ID:base64(nonce+密文)
+			arr := strings.Split(value, ":")
+			if len(arr) != 5 || arr[0] != "enc" || arr[1] != "v1" || arr[2] != c.alg || arr[3] != "k1" {
+				t.Fatalf("无效的密文格式：%s", value)
+			}
+			if strings.Contains(value, "123456") {
+				t.Fatal("数据未加密")
+			}
+			plainText, err := Decrypt(value, "rule1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if plainText != `{"secret":"123456"}` {
+				t.Fatalf("解密结果不一致：%s", plainText)
+			}
+
+			sealed, err := base64.RawURLEncoding.DecodeString(arr[4])
+			if err != nil {
+				t.Fatal(err)
+			}
+			sealed[len(sealed)-1] ^= 1
+			invalid := []struct {
+				name  string
+				value string
+				aad   string
+			}{
+				{"错误的附加认证数据", value, "rule2"},
+				{"篡改的密文", strings.Join(append(arr[:4:4], base64.RawURLEncoding.EncodeToString(sealed)), ":"), "rule1"},
+				{"缺少部份", "enc:v1:" + c.alg + ":k1", "rule1"},
+				{"不支持的算法", strings.Replace(value, c.alg, "des-cbc", 1), "rule1"},
+				{"找不到主密钥", strings.Replace(value, ":k1:", ":k9:", 1), "rule1"},
+				{"过短的密文", "enc:v1:" + c.alg + ":k1:AAAA", "rule1"},
+				{"无效的编码", "enc:v1:" + c.alg + ":k1:!!!!", "rule1"},
+			}
+			for _, v := range invalid {
+				if _, err = Decrypt(v.value, v.aad); err == nil {
+					t.Fatalf("%s：期望解密失败", v.name)
+				}
+			}
+		})
+	}
+}
+
+func TestPlainText(t *testing.T) {
+	reset()
+	// 未启用加密时原样保存
+	value, err := Encrypt(`{"secret":"123456"}`, "rule1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != `{"secret":"123456"}` {
+		t.Fatalf("未启用加密时不应加密：%s", value)
+	}
+	// 加密启用前保存的数据原样返回
+	defer reset()
+	loadFile(t, AlgAESGCM, filepath.Join(t.TempDir(), "keys"), testKey(1, 32))
+	if value, err = Decrypt(`{"secret":"123456"}`, "rule1"); err != nil || value != `{"secret":"123456"}` {
+		t.Fatalf("明文应原样返回：%s %v", value, err)
+	}
+	if value, err = Encrypt("", "rule1"); err != nil || value != "" {
+		t.Fatalf("空字符串应原样返回：%s %v", value, err)
+	}
+}
+
+func TestRotation(t *testing.T) {
+	defer reset()
+	keyFile := filepath.Join(t.TempDir(), "keys")
+	oldKey, newKey := "old="+testKey(1, 32), "new="+testKey(2, 32)
+	loadFile(t, AlgAESGCM, keyFile, oldKey)
+	oldValue, err := Encrypt("data", "rule1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 在文件首行添加新的主密钥，重新加载后使用新的主密钥加密，仍能解密旧的数据
+	if err = ioutil.WriteFile(keyFile, []byte(newKey+"\n"+oldKey), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = Reload(); err != nil {
+		t.Fatal(err)
+	}
+	newValue, err := Encrypt("data", "rule1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(newValue, prefix+AlgAESGCM+":new:") {
+		t.Fatalf("未使用新的主密钥加密：%s", newValue)
+	}
+	for _, value := range []string{oldValue, newValue} {
+		if plainText, err := Decrypt(value, "rule1"); err != nil || plainText != "data" {
+			t.Fatalf("解密失败：%s %v", plainText, err)
+		}
+	}
+
+	// 其它节点使用新的主密钥加密的数据，在本节点遇到未知的主密钥时自动重新加载
+	loadFile(t, AlgAESGCM, keyFile, oldKey)
+	if err = ioutil.WriteFile(keyFile, []byte(newKey+"\n"+oldKey), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if plainText, err := Decrypt(newValue, "rule1"); err != nil || plainText != "data" {
+		t.Fatalf("解密失败：%s %v", plainText, err)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	defer reset()
+	keyFile := filepath.Join(t.TempDir(), "keys")
+	cases := []struct {
+		name    string
+		alg     string
+		content string
+	}{
+		{"不支持的算法", "des-cbc", testKey(1, 32)},
+		{"ID包含冒号", AlgAESGCM, "a:b=" + testKey(1, 32)},
+		{"无效的Base64", AlgAESGCM, "k1=!!!!"},
+		{"密钥长度无效", AlgSM4GCM, testKey(1, 32)},
+		{"没有主密钥", AlgAESGCM, "# 注释\n\n"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if err := ioutil.WriteFile(keyFile, []byte(c.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if err := Load(c.alg, keyFile, ""); err == nil {
+				t.Fatal("期望加载失败")
+			}
+		})
+	}
+	const env = "ENVELOPE_TEST_KEYS"
+	if err := os.Unsetenv(env); err != nil {
+		t.Fatal(err)
+	}
+	if err := Load(AlgAESGCM, "", env); err == nil {
+		t.Fatal("环境变量为空时期望加载失败")
+	}
+}
+
+func TestRule(t *testing.T) {
+	defer reset()
+	loadFile(t, AlgAESGCM, filepath.Join(t.TempDir(), "keys"), testKey(1, 32))
+	rule := global.Rule{Name: "rule1"}
+	rule.Authorizer.Config = `{"secret":"1"}`
+	rule.Authorizer.Keys = []global.Key{{ID: "a", Config: `{"secret":"2"}`}}
+	rule.Updater.Config = `{"secret":"3"}`
+	keys := rule.Authorizer.Keys
+	if err := EncryptRule(&rule); err != nil {
+		t.Fatal(err)
+	}
+	if keys[0].Config != `{"secret":"2"}` {
+		t.Fatal("不应修改原规则的密钥")
+	}
+	for _, value := range []string{rule.Authorizer.Config, rule.Authorizer.Keys[0].Config, rule.Updater.Config} {
+		if !strings.HasPrefix(value, prefix) {
+			t.Fatalf("密钥配置未加密：%s", value)
+		}
+	}
+	// 密文与规则名称绑定，不能用于其它规则
+	other := rule
+	other.Name = "rule2"
+	if err := DecryptRule(&other); err == nil {
+		t.Fatal("期望解密其它规则的密文失败")
+	}
+	if err := DecryptRule(&rule); err != nil {
+		t.Fatal(err)
+	}
+	if rule.Authorizer.Config != `{"secret":"1"}` || rule.Authorizer.Keys[0].Config != `{"secret":"2"}` || rule.Updater.Config != `{"secret":"3"}` {
+		t.Fatalf("解密结果不一致：%+v", rule)
+	}
+}
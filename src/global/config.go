@@ -33,6 +33,8 @@ var Config struct {
 		EventMethodNotAllowed bool   `json:"event_method_not_allowed" toml:"event_method_not_allowed"`
 		Recover               bool   `json:"-" toml:"-"`
 		EventShortPath        bool   `json:"event_short_path" toml:"event_short_path"`
+		IPHeader              string `json:"ip_header" toml:"ip_header"`
+		PublicURL             string `json:"public_url" toml:"public_url"`
 	} `json:"service" toml:"service"`
 
 	// 日志配置
@@ -45,6 +47,13 @@ var Config struct {
 		NoColor    bool        `json:"no_color" toml:"no_color"`
 	} `json:"logger" toml:"logger"`
 
+	// 规则密钥配置的加密参数
+	Encryption struct {
+		Algorithm string `json:"algorithm" toml:"algorithm"`
+		KeyFile   string `json:"-" toml:"key_file"`
+		KeyEnv    string `json:"-" toml:"key_env"`
+	} `json:"encryption" toml:"encryption"`
+
 	// 存储配置
 	Storage struct {
 		Name   string `json:"-" toml:"name"`
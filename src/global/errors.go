@@ -0,0 +1,25 @@
+package global
+
+import "errors"
+
+// 验证授权的错误，服务层根据错误返回对应的错误代码
+var (
+	ErrTokenMalformed    = errors.New("token格式无效")
+	ErrAlgorithmMismatch = errors.New("token的算法不匹配")
+	ErrSignatureInvalid  = errors.New("签名无效")
+	ErrTokenExpired      = errors.New("授权已过期")
+	ErrTokenNotYetValid  = errors.New("授权尚未生效")
+	ErrTokenRevoked      = errors.New("授权已被吊销")
+	ErrTokenUseMismatch  = errors.New("token的用途不匹配")
+	ErrIssuerMismatch    = errors.New("授权的签发者不匹配")
+	ErrAudienceMismatch  = errors.New("授权的受众不匹配")
+	ErrIPMismatch        = errors.New("授权绑定的IP不匹配")
+	ErrScopeInsufficient = errors.New("授权的scope不足")
+	ErrPermissionDenied  = errors.New("授权的主体没有权限")
+	ErrPolicyDenied      = errors.New("授权不满足规则的策略")
+)
+
+// 判断验证授权的错误是否发生在签名验证通过之前，此时无法确定token是否由该密钥签发
+func SignatureUnverified(err error) bool {
+	return err == ErrTokenMalformed || err == ErrAlgorithmMismatch || err == ErrSignatureInvalid
+}
@@ -67,3 +67,57 @@ func DeleteRule(key string) error {
 	Rules.Delete(name)
 	return nil
 }
+
+// 删除不透明token数据
+func DeleteToken(key string) {
+	Tokens.Delete(path.Base(key))
+}
+
+// 删除吊销的授权数据
+func DeleteRevoked(key string) {
+	Revoked.Delete(path.Base(key))
+}
+
+// 判断授权是否已被吊销
+func IsRevoked(id string) bool {
+	_, exists := Revoked.Load(id)
+	return exists
+}
+
+// 删除权限数据
+func DeletePermission(key string) error {
+	name, err := DecodeKey(path.Base(key))
+	if err != nil {
+		log.Err(err).Caller().Send()
+		return err
+	}
+	Permissions.Delete(name)
+	return nil
+}
+
+// 删除角色数据
+func DeleteRole(key string) error {
+	name, err := DecodeKey(path.Base(key))
+	if err != nil {
+		log.Err(err).Caller().Send()
+		return err
+	}
+	Roles.Delete(name)
+	return nil
+}
+
+// 删除主体的角色数据，key为Subject.Key()
+func DeleteSubject(key string) {
+	Subjects.Delete(key)
+}
+
+// 删除OAuth客户端数据
+func DeleteClient(key string) error {
+	id, err := DecodeKey(path.Base(key))
+	if err != nil {
+		log.Err(err).Caller().Send()
+		return err
+	}
+	Clients.Delete(id)
+	return nil
+}
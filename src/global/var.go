@@ -1,48 +1,176 @@
 package global
 
 import (
+	"crypto"
 	"sync"
 )
 
 var (
 	StorageInstance Storage // 存储器实例
 
-	Rules sync.Map // 规则集，key=名称, value=Rule{}
+	Rules   sync.Map // 规则集，key=名称, value=Rule{}
+	Tokens  sync.Map // 不透明token集，key=token的hash, value=[]byte(token的claims)
+	Revoked sync.Map // 吊销的授权，key=授权的jti或token的hash, value=int64(授权的过期时间，0为永不过期)
+
+	Permissions sync.Map // 权限集，key=名称, value=Permission{}
+	Roles       sync.Map // 角色集，key=名称, value=Role{}
+	Subjects    sync.Map // 主体的角色集，key=Subject.Key(), value=Subject{}
+	Clients     sync.Map // OAuth客户端集，key=client_id, value=Client{}
 )
 
 // 规则
 type Rule struct {
-	Name       string `json:"name"`
-	Authorizer struct {
+	Name        string                 `json:"name"`
+	Issuer      string                 `json:"issuer,omitempty"`       // 签发者，设置后签发的授权带有iss并在验证时校验
+	Claims      map[string]interface{} `json:"claims,omitempty"`       // 默认的自定义claims，签发时可被参数中的同名claims覆盖
+	Session     *Session               `json:"session,omitempty"`      // 会话策略
+	RefreshHook string                 `json:"refresh_hook,omitempty"` // 刷新授权时获取最新claims的URL
+	LoginHook   string                 `json:"login_hook,omitempty"`   // OAuth授权码模式中验证用户登录状态的URL
+	Scopes      []string               `json:"scopes,omitempty"`       // 允许签发的scope
+	Policies    []Policy               `json:"policies,omitempty"`     // 验证授权时求值的策略
+	Authorizer  struct {
 		Type     string             `json:"type"`
 		Config   string             `json:"config"`
+		Keys     []Key              `json:"keys,omitempty"`
+		Rotation *Rotation          `json:"rotation,omitempty"`
 		Instance AuthorizerInstance `json:"-"`
 	} `json:"authorizer"`
 	Updater struct {
 		Type     string          `json:"type"`
 		Config   string          `json:"config"`
+		Keys     []Key           `json:"keys,omitempty"`
+		Rotation *Rotation       `json:"rotation,omitempty"`
 		Instance UpdaterInstance `json:"-"`
 	} `json:"updater"`
 }
 
+// 密钥状态
+const (
+	KeyStatusActive = "active" // 用于签发和验证
+	KeyStatusVerify = "verify" // 仅用于验证
+)
+
+// 密钥，config中的字段会覆盖授权器或更新器的config中的同名字段
+type Key struct {
+	ID      string `json:"kid"`
+	Config  string `json:"config"`
+	Status  string `json:"status"`
+	Created int64  `json:"created"`
+	Retired int64  `json:"retired,omitempty"` // 转为仅验证的时间
+}
+
+// 密钥轮换策略
+type Rotation struct {
+	Interval int64 `json:"interval"`        // 轮换间隔(秒)
+	Grace    int64 `json:"grace,omitempty"` // 旧密钥转为仅验证后的保留时长(秒)，为0时保留授权生命周期的2倍
+}
+
+// 会话策略，会话从签发授权时开始，刷新授权不能延长会话
+type Session struct {
+	Lifetime   int64 `json:"lifetime,omitempty"`    // 会话的绝对生命周期(秒)，为0时不限制
+	MaxRefresh int   `json:"max_refresh,omitempty"` // 最多刷新次数，为0时不限制
+}
+
+// 策略，验证授权时对授权的claims和请求求值，所有策略的结果都为true时才通过验证
+type Policy struct {
+	Name       string        `json:"name,omitempty"`
+	Expression string        `json:"expression"`
+	Program    PolicyProgram `json:"-"` // 加载规则时编译的程序
+}
+
+// 编译后的策略程序
+type PolicyProgram interface {
+	Eval(map[string]interface{}) (bool, error) // 对变量求值
+}
+
+// 权限
+type Permission struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+// 角色，拥有一组权限
+type Role struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Permissions []string `json:"permissions"`
+}
+
+// 主体，即授权的sub，拥有一组角色
+type Subject struct {
+	Rule   string   `json:"rule"`             // 签发授权的规则
+	Name   string   `json:"name"`             // 授权的sub
+	Client bool     `json:"client,omitempty"` // 是否为OAuth客户端的身份(客户端凭证模式签发的授权)
+	Roles  []string `json:"roles"`
+}
+
+// 主体的键名，按规则和身份类型区分同名的sub
+func (self Subject) Key() string {
+	kind := "users"
+	if self.Client {
+		kind = "clients"
+	}
+	return EncodeKey(self.Rule) + "/" + kind + "/" + EncodeKey(self.Name)
+}
+
+// OAuth客户端
+type Client struct {
+	ID           string   `json:"client_id"`
+	Name         string   `json:"name,omitempty"`
+	SecretHash   string   `json:"secret_hash,omitempty"`   // client_secret的bcrypt hash，公开客户端没有client_secret
+	Public       bool     `json:"public,omitempty"`        // 公开客户端(SPA、移动应用)，只能使用授权码模式
+	Rules        []string `json:"rules"`                   // 允许使用的规则
+	Scopes       []string `json:"scopes,omitempty"`        // 允许申请的scope
+	RedirectURIs []string `json:"redirect_uris,omitempty"` // 授权码模式允许的重定向地址
+	Created      int64    `json:"created"`
+}
+
 // 签名参数
 type SignParams struct {
-	Expires int64
-	Payload string
-	Aud     string
-	IP      string
+	Expires   int64 // 最迟的过期时间(Unix时间戳)，不为0时授权的过期时间不会晚于此时间
+	Payload   string
+	Aud       string
+	IP        string
+	Issuer    string
+	Subject   string
+	NotBefore int64
+	ID        string
+	Scope     string                 // 以空格分隔的scope
+	Claims    map[string]interface{} // 自定义claims
 }
 
 type AuthorizerClaims struct {
-	Expires int64
-	Payload string
-	Aud     string
-	IP      string
+	Expires   int64
+	Payload   string
+	Aud       string
+	IP        string
+	Issuer    string
+	Subject   string
+	IssuedAt  int64
+	NotBefore int64
+	ID        string
+	Scope     string                 // 以空格分隔的scope
+	Claims    map[string]interface{} // 自定义claims
 }
 
 type AuthorizerInstance interface {
-	Sign(SignParams) (string, error)            // 签发授权
-	VeritySign(string) (AuthorizerClaims, bool) // 验证签名
+	Sign(SignParams) (string, error)             // 签发授权
+	VeritySign(string) (AuthorizerClaims, error) // 验证签名
+}
+
+// 公钥实例，使用非对称算法的授权器和更新器实现此接口，用于公开公钥
+type PublicKeyInstance interface {
+	ExportPublicKey() crypto.PublicKey // 导出公钥
+}
+
+// 带有kid的实例，单密钥的非对称JWT授权器实现此接口
+type KeyIDInstance interface {
+	ExportKeyID() string // 导出签发授权时header中的kid
+}
+
+// 公钥集实例，多密钥的授权器和更新器实现此接口
+type PublicKeySetInstance interface {
+	ExportPublicKeys() map[string]crypto.PublicKey // 导出所有密钥的公钥，key为kid
 }
 
 // 更新器
@@ -54,8 +182,8 @@ type UpdaterClaims struct {
 }
 
 type UpdaterInstance interface {
-	Sign(string) (string, error)             // 签发授权
-	VeritySign(string) (UpdaterClaims, bool) // 验证签名
+	Sign(string) (string, error)              // 签发授权
+	VeritySign(string) (UpdaterClaims, error) // 验证签名
 }
 
 // 存储器
@@ -67,7 +195,35 @@ type Storage interface {
 	LoadRule([]byte) error   // 从存储器加载单个规则数据
 	SaveAllRule() error      // 将本地所有规则数据保存到存储器
 	SaveRule(Rule) error     // 将本地单个规则数据保存到存储器
-	DeleteRule(string) error // 删除存储器中单个规则数据
+	DeleteRule(string) error // 删除存储器中单个规则数据及其主体
+
+	SaveToken(string, []byte, int64) error // 保存不透明token的数据，参数依次为token的hash、数据、生命周期(秒)
+	LoadToken(string) ([]byte, error)      // 读取不透明token的数据，不存在时返回nil
+	DeleteToken(string) error              // 删除不透明token的数据
+
+	SaveRefreshToken(string, []byte, int64) error  // 保存刷新授权的数据，参数依次为刷新授权的hash、数据、生命周期(秒)
+	LoadRefreshToken(string) ([]byte, bool, error) // 读取刷新授权的数据但不标记为已使用，返回数据及是否已被使用，不存在时返回nil
+	UseRefreshToken(string) ([]byte, bool, error)  // 将刷新授权标记为已使用，返回数据及是否为首次使用，不存在时返回nil
+
+	SaveAuthCode(string, []byte, int64) error // 保存OAuth授权码的数据，参数依次为授权码的hash、数据、生命周期(秒)
+	UseAuthCode(string) ([]byte, bool, error) // 将OAuth授权码标记为已使用，返回数据及是否为首次使用，不存在时返回nil
+
+	SaveRevoked(string, int64) error // 将授权加入吊销列表，参数依次为授权的标识、授权的过期时间(Unix时间戳)，过期后自动删除
+	LoadAllRevoked() error           // 从存储器加载吊销列表到本地
+
+	LoadAllRBAC() error              // 从存储器加载所有权限、角色和主体到本地
+	SavePermission(Permission) error // 将权限保存到存储器
+	DeletePermission(string) error   // 删除存储器中的权限
+	SaveRole(Role) error             // 将角色保存到存储器
+	DeleteRole(string) error         // 删除存储器中的角色
+	SaveSubject(Subject) error       // 将主体的角色保存到存储器
+	DeleteSubject(Subject) error     // 删除存储器中主体的角色
+
+	LoadAllClient() error      // 从存储器加载所有OAuth客户端到本地
+	SaveClient(Client) error   // 将OAuth客户端保存到存储器
+	DeleteClient(string) error // 删除存储器中的OAuth客户端
+
+	Lock(string, int64) (bool, error) // 获取分布式锁，参数依次为锁名称、持有时长(秒)，到期后自动释放
 
 	Watch() error // 监听存储器的数据变更
 }
@@ -0,0 +1,282 @@
+package keyutil
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+
+	"local/global"
+
+	"github.com/tjfoc/gmsm/sm2"
+)
+
+// 获得ECDSA签名算法对应的曲线
+func ECDSACurve(alg string) (elliptic.Curve, error) {
+	switch alg {
+	case "ES256":
+		return elliptic.P256(), nil
+	case "ES384":
+		return elliptic.P384(), nil
+	case "ES512":
+		return elliptic.P521(), nil
+	}
+	return nil, errors.New("不支持的ECDSA算法：" + alg)
+}
+
+// 公钥转为Base64，PKIX格式
+func PublicKeyToBase64(publicKey crypto.PublicKey) (string, error) {
+	var (
+		keyBytes []byte
+		err      error
+	)
+	switch key := publicKey.(type) {
+	case *sm2.PublicKey:
+		keyBytes, err = sm2.MarshalSm2PublicKey(key)
+	default:
+		keyBytes, err = x509.MarshalPKIXPublicKey(key)
+	}
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(keyBytes), nil
+}
+
+// 与私钥对应的配置字段，密钥配置包含私钥时不使用基础配置中的这些字段
+var keyBoundFields = []string{"public_key", "certificate", "x5c", "x5t"}
+
+// 合并配置，用密钥配置中的字段覆盖基础配置中的同名字段，并写入key_id
+func MergeConfig(base, override, keyID string) (string, error) {
+	config := make(map[string]json.RawMessage)
+	if base != "" {
+		if err := json.Unmarshal([]byte(base), &config); err != nil {
+			return "", err
+		}
+	}
+	if override != "" {
+		overrideConfig := make(map[string]json.RawMessage)
+		if err := json.Unmarshal([]byte(override), &overrideConfig); err != nil {
+			return "", err
+		}
+		// 基础配置中的公钥和证书属于基础配置的私钥
+		if _, exists := overrideConfig["private_key"]; exists {
+			for k := range keyBoundFields {
+				delete(config, keyBoundFields[k])
+			}
+		}
+		for k, v := range overrideConfig {
+			config[k] = v
+		}
+	}
+	keyIDBytes, err := json.Marshal(keyID)
+	if err != nil {
+		return "", err
+	}
+	config["key_id"] = keyIDBytes
+	configBytes, err := json.Marshal(&config)
+	if err != nil {
+		return "", err
+	}
+	return string(configBytes), nil
+}
+
+// 获取token中的kid，支持JWT/JWE的header和PASETO的footer，不存在时返回空字符串
+func TokenKeyID(tokenStr string) string {
+	var (
+		segment string
+		header  struct {
+			KeyID string `json:"kid"`
+		}
+	)
+	if strings.HasPrefix(tokenStr, "v4.") {
+		arr := strings.Split(tokenStr, ".")
+		if len(arr) != 4 {
+			return ""
+		}
+		segment = arr[3]
+	} else {
+		pos := strings.IndexByte(tokenStr, '.')
+		if pos < 0 {
+			return ""
+		}
+		segment = tokenStr[:pos]
+	}
+	segmentBytes, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return ""
+	}
+	if err = json.Unmarshal(segmentBytes, &header); err != nil {
+		return ""
+	}
+	return header.KeyID
+}
+
+// 获取token中未经验证的iss，仅用于查找token所属的规则，支持未加密的JWT和PASETO v4.public，无法获取时返回空字符串
+func TokenIssuer(tokenStr string) string {
+	var (
+		segmentBytes []byte
+		err          error
+		claims       struct {
+			Issuer string `json:"iss"`
+		}
+	)
+	arr := strings.Split(tokenStr, ".")
+	switch {
+	case strings.HasPrefix(tokenStr, "v4.public.") && len(arr) >= 3:
+		if segmentBytes, err = base64.RawURLEncoding.DecodeString(arr[2]); err != nil || len(segmentBytes) < ed25519.SignatureSize {
+			return ""
+		}
+		segmentBytes = segmentBytes[:len(segmentBytes)-ed25519.SignatureSize]
+	case len(arr) == 3:
+		if segmentBytes, err = base64.RawURLEncoding.DecodeString(arr[1]); err != nil {
+			return ""
+		}
+	default:
+		return ""
+	}
+	if err = json.Unmarshal(segmentBytes, &claims); err != nil {
+		return ""
+	}
+	return claims.Issuer
+}
+
+// 校验密钥集，kid不能为空且不能重复，必须有且只有一个活动密钥
+func CheckKeys(keys []global.Key) error {
+	var active int
+	ids := make(map[string]struct{}, len(keys))
+	for k := range keys {
+		if keys[k].ID == "" {
+			return errors.New("密钥的kid不能为空")
+		}
+		if _, exists := ids[keys[k].ID]; exists {
+			return errors.New("密钥的kid重复：" + keys[k].ID)
+		}
+		ids[keys[k].ID] = struct{}{}
+		switch keys[k].Status {
+		case global.KeyStatusActive:
+			active++
+		case global.KeyStatusVerify:
+		default:
+			return errors.New("无效的密钥状态：" + keys[k].Status)
+		}
+	}
+	if active != 1 {
+		return errors.New("必须有且只有一个活动密钥")
+	}
+	return nil
+}
+
+// 获得验证没有kid的token时尝试密钥的顺序，活动密钥在前，其它密钥按创建时间排列
+func KeyOrder(keys []global.Key) []string {
+	sorted := append(make([]global.Key, 0, len(keys)), keys...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		iActive, jActive := sorted[i].Status == global.KeyStatusActive, sorted[j].Status == global.KeyStatusActive
+		if iActive != jActive {
+			return iActive
+		}
+		return sorted[i].Created < sorted[j].Created
+	})
+	ids := make([]string, len(sorted))
+	for k := range sorted {
+		ids[k] = sorted[k].ID
+	}
+	return ids
+}
+
+// 生成随机的kid
+func NewKeyID() (string, error) {
+	idBytes := make([]byte, 8)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(idBytes), nil
+}
+
+// 生成指定字节长度的随机数，返回Base64字符串
+func RandomBase64(size int) (string, error) {
+	randomBytes := make([]byte, size)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(randomBytes), nil
+}
+
+// 生成RSA密钥对，返回PKCS1格式的私钥和公钥的Base64字符串
+func GenerateRSAKey(bits int) (privateKeyStr, publicKeyStr string, err error) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		return
+	}
+	privateKeyStr = base64.RawURLEncoding.EncodeToString(x509.MarshalPKCS1PrivateKey(privateKey))
+	publicKeyStr = base64.RawURLEncoding.EncodeToString(x509.MarshalPKCS1PublicKey(&privateKey.PublicKey))
+	return
+}
+
+// 生成ECDSA密钥，私钥为SEC1格式，公钥为PKIX格式的Base64字符串
+func GenerateECDSAKey(curve elliptic.Curve) (privateKeyStr, publicKeyStr string, err error) {
+	privateKey, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		return
+	}
+	keyBytes, err := x509.MarshalECPrivateKey(privateKey)
+	if err != nil {
+		return
+	}
+	privateKeyStr = base64.RawURLEncoding.EncodeToString(keyBytes)
+	publicKeyStr, err = PublicKeyToBase64(&privateKey.PublicKey)
+	return
+}
+
+// 生成Ed25519密钥，私钥为32字节种子，公钥为PKIX格式的Base64字符串
+func GenerateEd25519Key() (privateKeyStr, publicKeyStr string, err error) {
+	seed := make([]byte, ed25519.SeedSize)
+	if _, err = rand.Read(seed); err != nil {
+		return
+	}
+	privateKeyStr = base64.RawURLEncoding.EncodeToString(seed)
+	publicKeyStr, err = PublicKeyToBase64(ed25519.NewKeyFromSeed(seed).Public())
+	return
+}
+
+// 生成SM2密钥，私钥为PKCS8格式，公钥为PKIX格式的Base64字符串
+func GenerateSM2Key() (privateKeyStr, publicKeyStr string, err error) {
+	privateKey, err := sm2.GenerateKey()
+	if err != nil {
+		return
+	}
+	keyBytes, err := sm2.MarshalSm2PrivateKey(privateKey, nil)
+	if err != nil {
+		return
+	}
+	privateKeyStr = base64.RawURLEncoding.EncodeToString(keyBytes)
+	publicKeyStr, err = PublicKeyToBase64(&privateKey.PublicKey)
+	return
+}
+
+// 将密钥字段编码为JSON格式的配置
+func EncodeConfig(fields map[string]string) (string, error) {
+	configBytes, err := json.Marshal(&fields)
+	if err != nil {
+		return "", err
+	}
+	return string(configBytes), nil
+}
+
+// 校验自动生成的RSA密钥的位数，为0时使用2048位
+func RSAKeySize(bits int) (int, error) {
+	switch bits {
+	case 0:
+		return 2048, nil
+	case 2048, 3072, 4096:
+		return bits, nil
+	}
+	return 0, errors.New("RSA密钥位数必须是2048、3072或4096")
+}
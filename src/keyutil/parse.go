@@ -0,0 +1,323 @@
+package keyutil
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha1" // nolint:gosec x5t规定使用SHA-1指纹
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"strings"
+
+	"github.com/tjfoc/gmsm/sm2"
+)
+
+// 解码后的密钥数据
+type derBlock struct {
+	Type  string // PEM的类型，Base64编码时为空
+	Bytes []byte
+}
+
+// 解码密钥字符串，自动识别PEM和Base64(URL或标准编码，有无填充均可)编码的DER
+func decode(str string) ([]derBlock, error) {
+	str = strings.TrimSpace(str)
+	if str == "" {
+		return nil, errors.New("密钥为空")
+	}
+	if strings.Contains(str, "-----BEGIN") {
+		var (
+			blocks []derBlock
+			block  *pem.Block
+			rest   = []byte(str)
+		)
+		for {
+			block, rest = pem.Decode(rest)
+			if block == nil {
+				break
+			}
+			if x509.IsEncryptedPEMBlock(block) { // nolint:staticcheck
+				return nil, errors.New("不支持加密的PEM")
+			}
+			blocks = append(blocks, derBlock{Type: block.Type, Bytes: block.Bytes})
+		}
+		if len(blocks) == 0 {
+			return nil, errors.New("无效的PEM")
+		}
+		return blocks, nil
+	}
+	for _, encoding := range []*base64.Encoding{base64.RawURLEncoding, base64.URLEncoding, base64.RawStdEncoding, base64.StdEncoding} {
+		if der, err := encoding.DecodeString(str); err == nil {
+			return []derBlock{{Bytes: der}}, nil
+		}
+	}
+	return nil, errors.New("无效的Base64字符串")
+}
+
+// 解析DER格式的私钥，支持PKCS1、PKCS8、SEC1以及SM2的PKCS8和SEC1
+func parsePrivateKeyDER(der []byte) (crypto.PrivateKey, error) {
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := sm2.ParsePKCS8UnecryptedPrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := sm2.ParseSm2PrivateKey(der); err == nil {
+		return key, nil
+	}
+	return nil, errors.New("无法识别的私钥格式")
+}
+
+// 解析DER格式的公钥，支持PKIX、PKCS1以及X.509证书(包括SM2证书)
+func parsePublicKeyDER(der []byte) (crypto.PublicKey, error) {
+	if key, err := x509.ParsePKIXPublicKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PublicKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := sm2.ParsePKIXPublicKey(der); err == nil {
+		return convertSM2PublicKey(key), nil
+	}
+	if key, err := parseCertificatePublicKey(der); err == nil {
+		return key, nil
+	}
+	return nil, errors.New("无法识别的公钥格式")
+}
+
+// 解析X.509证书中的公钥
+func parseCertificatePublicKey(der []byte) (crypto.PublicKey, error) {
+	if cert, err := x509.ParseCertificate(der); err == nil {
+		return cert.PublicKey, nil
+	}
+	cert, err := sm2.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	if cert.PublicKey == nil {
+		return nil, errors.New("不支持的证书公钥算法")
+	}
+	return convertSM2PublicKey(cert.PublicKey), nil
+}
+
+// gmsm将SM2公钥解析为使用SM2曲线的ECDSA公钥，需要转换成SM2公钥
+func convertSM2PublicKey(key interface{}) crypto.PublicKey {
+	if ecdsaKey, ok := key.(*ecdsa.PublicKey); ok && ecdsaKey.Curve == sm2.P256Sm2() {
+		return &sm2.PublicKey{Curve: ecdsaKey.Curve, X: ecdsaKey.X, Y: ecdsaKey.Y}
+	}
+	return key
+}
+
+// 解析私钥，自动识别编码和格式
+func ParsePrivateKey(str string) (crypto.PrivateKey, error) {
+	blocks, err := decode(str)
+	if err != nil {
+		return nil, err
+	}
+	for k := range blocks {
+		if blocks[k].Type == "CERTIFICATE" {
+			continue
+		}
+		return parsePrivateKeyDER(blocks[k].Bytes)
+	}
+	return nil, errors.New("没有找到私钥")
+}
+
+// 解析公钥，自动识别编码和格式，传入证书时使用证书中的公钥
+// PEM中有多个块时使用第一个公钥或证书，忽略私钥和EC参数等其它块
+func ParsePublicKey(str string) (crypto.PublicKey, error) {
+	blocks, err := decode(str)
+	if err != nil {
+		return nil, err
+	}
+	for k := range blocks {
+		if blocks[k].Type != "" && blocks[k].Type != "CERTIFICATE" && !strings.HasSuffix(blocks[k].Type, "PUBLIC KEY") {
+			continue
+		}
+		return parsePublicKeyDER(blocks[k].Bytes)
+	}
+	return nil, errors.New("没有找到公钥")
+}
+
+// 解析RSA私钥
+func ParseRSAPrivateKey(str string) (*rsa.PrivateKey, error) {
+	key, err := ParsePrivateKey(str)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("不是RSA私钥")
+	}
+	return privateKey, nil
+}
+
+// 解析RSA公钥
+func ParseRSAPublicKey(str string) (*rsa.PublicKey, error) {
+	key, err := ParsePublicKey(str)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("不是RSA公钥")
+	}
+	return publicKey, nil
+}
+
+// 解析ECDSA私钥
+func ParseECDSAPrivateKey(str string) (*ecdsa.PrivateKey, error) {
+	key, err := ParsePrivateKey(str)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("不是ECDSA私钥")
+	}
+	return privateKey, nil
+}
+
+// 解析ECDSA公钥
+func ParseECDSAPublicKey(str string) (*ecdsa.PublicKey, error) {
+	key, err := ParsePublicKey(str)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, ok := key.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, errors.New("不是ECDSA公钥")
+	}
+	return publicKey, nil
+}
+
+// 解析Ed25519私钥，还支持32字节的原始种子
+func ParseEd25519PrivateKey(str string) (ed25519.PrivateKey, error) {
+	blocks, err := decode(str)
+	if err != nil {
+		return nil, err
+	}
+	if blocks[0].Type == "" && len(blocks[0].Bytes) == ed25519.SeedSize {
+		return ed25519.NewKeyFromSeed(blocks[0].Bytes), nil
+	}
+	key, err := ParsePrivateKey(str)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errors.New("不是Ed25519私钥")
+	}
+	return privateKey, nil
+}
+
+// 解析SM2私钥
+func ParseSM2PrivateKey(str string) (*sm2.PrivateKey, error) {
+	key, err := ParsePrivateKey(str)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, ok := key.(*sm2.PrivateKey)
+	if !ok {
+		return nil, errors.New("不是SM2私钥")
+	}
+	return privateKey, nil
+}
+
+// 解析证书链，返回DER格式的证书，第一个是签发token的私钥对应的证书
+func ParseCertificates(str string) ([][]byte, error) {
+	var certs [][]byte
+	blocks, err := decode(str)
+	if err != nil {
+		return nil, err
+	}
+	for k := range blocks {
+		if blocks[k].Type != "" && blocks[k].Type != "CERTIFICATE" {
+			continue
+		}
+		if _, err = parseCertificatePublicKey(blocks[k].Bytes); err != nil {
+			return nil, errors.New("无效的X.509证书：" + err.Error())
+		}
+		certs = append(certs, blocks[k].Bytes)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("没有找到证书")
+	}
+	return certs, nil
+}
+
+// 生成JWT header中的x5c(证书链)和x5t(证书SHA-1指纹)字段，并校验证书与公钥是否匹配
+// certificate为空时从私钥的PEM中查找证书
+func CertHeaders(certificate, privateKey string, publicKey crypto.PublicKey, x5c, x5t bool) ([]json.RawMessage, error) {
+	var header struct {
+		X5C []string `json:"x5c,omitempty"`
+		X5T string   `json:"x5t,omitempty"`
+	}
+	if !x5c && !x5t {
+		return nil, nil
+	}
+	if certificate == "" {
+		certificate = privateKey
+	}
+	certs, err := ParseCertificates(certificate)
+	if err != nil {
+		return nil, err
+	}
+	certPublicKey, err := parseCertificatePublicKey(certs[0])
+	if err != nil {
+		return nil, err
+	}
+	if !PublicKeyEqual(certPublicKey, publicKey) {
+		return nil, errors.New("证书与私钥不匹配")
+	}
+	if x5c {
+		for k := range certs {
+			header.X5C = append(header.X5C, base64.StdEncoding.EncodeToString(certs[k]))
+		}
+	}
+	if x5t {
+		sum := sha1.Sum(certs[0]) // nolint:gosec
+		header.X5T = base64.RawURLEncoding.EncodeToString(sum[:])
+	}
+	headerBytes, err := json.Marshal(&header)
+	if err != nil {
+		return nil, err
+	}
+	return []json.RawMessage{headerBytes}, nil
+}
+
+// 校验配置中的公钥与私钥是否匹配，未配置公钥时不校验
+func CheckPublicKey(publicKeyStr string, publicKey crypto.PublicKey) error {
+	if publicKeyStr == "" {
+		return nil
+	}
+	key, err := ParsePublicKey(publicKeyStr)
+	if err != nil {
+		return errors.New("无效的公钥：" + err.Error())
+	}
+	if !PublicKeyEqual(key, publicKey) {
+		return errors.New("公钥与私钥不匹配")
+	}
+	return nil
+}
+
+// 比较两个公钥是否相同
+func PublicKeyEqual(a, b crypto.PublicKey) bool {
+	switch key := a.(type) {
+	case *sm2.PublicKey:
+		other, ok := b.(*sm2.PublicKey)
+		return ok && key.X.Cmp(other.X) == 0 && key.Y.Cmp(other.Y) == 0
+	case interface{ Equal(crypto.PublicKey) bool }:
+		return key.Equal(b)
+	}
+	return false
+}
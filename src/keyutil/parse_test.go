@@ -0,0 +1,347 @@
+package keyutil
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1" // nolint:gosec
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/tjfoc/gmsm/sm2"
+)
+
+// 测试用的密钥，生成RSA密钥较慢，所有测试共用
+var (
+	testRSAKey   *rsa.PrivateKey
+	testECDSAKey *ecdsa.PrivateKey
+	testEd25519  ed25519.PrivateKey
+	testSM2Key   *sm2.PrivateKey
+)
+
+func init() {
+	var err error
+	if testRSAKey, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+		panic(err)
+	}
+	if testECDSAKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+		panic(err)
+	}
+	if _, testEd25519, err = ed25519.GenerateKey(rand.Reader); err != nil {
+		panic(err)
+	}
+	if testSM2Key, err = sm2.GenerateKey(); err != nil {
+		panic(err)
+	}
+}
+
+// DER的各种编码方式
+var encodings = []struct {
+	name   string
+	encode func(pemType string, der []byte) string
+}{
+	{"PEM", func(pemType string, der []byte) string {
+		return string(pem.EncodeToMemory(&pem.Block{Type: pemType, Bytes: der}))
+	}},
+	{"Base64URL", func(_ string, der []byte) string { return base64.RawURLEncoding.EncodeToString(der) }},
+	{"Base64URL有填充", func(_ string, der []byte) string { return base64.URLEncoding.EncodeToString(der) }},
+	{"Base64", func(_ string, der []byte) string { return base64.RawStdEncoding.EncodeToString(der) }},
+	{"Base64有填充", func(_ string, der []byte) string { return base64.StdEncoding.EncodeToString(der) }},
+}
+
+// 生成测试数据时出错直接panic
+func mustDER(der []byte, err error) []byte {
+	if err != nil {
+		panic(err)
+	}
+	return der
+}
+
+// SM2私钥的SEC1格式，gmsm没有提供编码方法
+func marshalSM2SEC1(t *testing.T, key *sm2.PrivateKey) []byte {
+	t.Helper()
+	return mustDER(asn1.Marshal(struct {
+		Version       int
+		PrivateKey    []byte
+		NamedCurveOID asn1.ObjectIdentifier `asn1:"optional,explicit,tag:0"`
+		PublicKey     asn1.BitString        `asn1:"optional,explicit,tag:1"`
+	}{
+		Version:       1,
+		PrivateKey:    key.D.FillBytes(make([]byte, 32)),
+		NamedCurveOID: asn1.ObjectIdentifier{1, 2, 156, 10197, 1, 301},
+		PublicKey:     asn1.BitString{Bytes: elliptic.Marshal(key.Curve, key.X, key.Y), BitLength: 520},
+	}))
+}
+
+// 生成自签名证书，parent为空时自签名
+func createCertificate(t *testing.T, name string, public crypto.PublicKey, parent *x509.Certificate, signer crypto.Signer) []byte {
+	t.Helper()
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IsCA:         parent == nil,
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+
+		BasicConstraintsValid: true,
+	}
+	if parent == nil {
+		parent = template
+	}
+	return mustDER(x509.CreateCertificate(rand.Reader, template, parent, public, signer))
+}
+
+// 生成SM2自签名证书
+func createSM2Certificate(t *testing.T, key *sm2.PrivateKey) []byte {
+	t.Helper()
+	template := &sm2.Certificate{
+		SerialNumber:       big.NewInt(time.Now().UnixNano()),
+		Subject:            pkix.Name{CommonName: "sm2"},
+		NotBefore:          time.Now().Add(-time.Hour),
+		NotAfter:           time.Now().Add(time.Hour),
+		SignatureAlgorithm: sm2.SM2WithSM3,
+	}
+	return mustDER(sm2.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key))
+}
+
+func TestParsePrivateKey(t *testing.T) {
+	cases := []struct {
+		name    string
+		pemType string
+		der     []byte
+		public  crypto.PublicKey
+	}{
+		{"RSA PKCS1", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(testRSAKey), &testRSAKey.PublicKey},
+		{"RSA PKCS8", "PRIVATE KEY", mustDER(x509.MarshalPKCS8PrivateKey(testRSAKey)), &testRSAKey.PublicKey},
+		{"ECDSA SEC1", "EC PRIVATE KEY", mustDER(x509.MarshalECPrivateKey(testECDSAKey)), &testECDSAKey.PublicKey},
+		{"ECDSA PKCS8", "PRIVATE KEY", mustDER(x509.MarshalPKCS8PrivateKey(testECDSAKey)), &testECDSAKey.PublicKey},
+		{"Ed25519 PKCS8", "PRIVATE KEY", mustDER(x509.MarshalPKCS8PrivateKey(testEd25519)), testEd25519.Public()},
+		{"SM2 PKCS8", "PRIVATE KEY", mustDER(sm2.MarshalSm2UnecryptedPrivateKey(testSM2Key)), &testSM2Key.PublicKey},
+		{"SM2 SEC1", "EC PRIVATE KEY", marshalSM2SEC1(t, testSM2Key), &testSM2Key.PublicKey},
+	}
+	for _, c := range cases {
+		for _, e := range encodings {
+			key, err := ParsePrivateKey(e.encode(c.pemType, c.der))
+			if err != nil {
+				t.Errorf("%s %s：%v", c.name, e.name, err)
+				continue
+			}
+			signer, ok := key.(crypto.Signer)
+			if !ok || !PublicKeyEqual(c.public, signer.Public()) {
+				t.Errorf("%s %s：解析的私钥与原私钥不一致", c.name, e.name)
+			}
+		}
+	}
+}
+
+// 各算法的私钥解析函数只接受对应类型的私钥
+func TestParseTypedPrivateKey(t *testing.T) {
+	rsaPEM := encodings[0].encode("RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(testRSAKey))
+	ecdsaPEM := encodings[0].encode("EC PRIVATE KEY", mustDER(x509.MarshalECPrivateKey(testECDSAKey)))
+	sm2PEM := encodings[0].encode("PRIVATE KEY", mustDER(sm2.MarshalSm2UnecryptedPrivateKey(testSM2Key)))
+	seed := base64.RawURLEncoding.EncodeToString(testEd25519.Seed())
+
+	if _, err := ParseRSAPrivateKey(rsaPEM); err != nil {
+		t.Error(err)
+	}
+	if _, err := ParseRSAPrivateKey(ecdsaPEM); err == nil {
+		t.Error("ECDSA私钥不应解析为RSA私钥")
+	}
+	if _, err := ParseECDSAPrivateKey(ecdsaPEM); err != nil {
+		t.Error(err)
+	}
+	if _, err := ParseECDSAPrivateKey(sm2PEM); err == nil {
+		t.Error("SM2私钥不应解析为ECDSA私钥")
+	}
+	if _, err := ParseSM2PrivateKey(sm2PEM); err != nil {
+		t.Error(err)
+	}
+	if _, err := ParseSM2PrivateKey(ecdsaPEM); err == nil {
+		t.Error("ECDSA私钥不应解析为SM2私钥")
+	}
+	// Ed25519私钥可以是32字节的原始种子
+	if key, err := ParseEd25519PrivateKey(seed); err != nil || !key.Equal(testEd25519) {
+		t.Errorf("解析Ed25519种子失败：%v", err)
+	}
+	if _, err := ParseEd25519PrivateKey(rsaPEM); err == nil {
+		t.Error("RSA私钥不应解析为Ed25519私钥")
+	}
+}
+
+func TestParsePublicKey(t *testing.T) {
+	ecdsaCert := createCertificate(t, "ecdsa", &testECDSAKey.PublicKey, nil, testECDSAKey)
+	cases := []struct {
+		name    string
+		pemType string
+		der     []byte
+		public  crypto.PublicKey
+	}{
+		{"RSA PKIX", "PUBLIC KEY", mustDER(x509.MarshalPKIXPublicKey(&testRSAKey.PublicKey)), &testRSAKey.PublicKey},
+		{"RSA PKCS1", "RSA PUBLIC KEY", x509.MarshalPKCS1PublicKey(&testRSAKey.PublicKey), &testRSAKey.PublicKey},
+		{"ECDSA PKIX", "PUBLIC KEY", mustDER(x509.MarshalPKIXPublicKey(&testECDSAKey.PublicKey)), &testECDSAKey.PublicKey},
+		{"Ed25519 PKIX", "PUBLIC KEY", mustDER(x509.MarshalPKIXPublicKey(testEd25519.Public())), testEd25519.Public()},
+		{"SM2 PKIX", "PUBLIC KEY", mustDER(sm2.MarshalPKIXPublicKey(&testSM2Key.PublicKey)), &testSM2Key.PublicKey},
+		{"ECDSA证书", "CERTIFICATE", ecdsaCert, &testECDSAKey.PublicKey},
+		{"SM2证书", "CERTIFICATE", createSM2Certificate(t, testSM2Key), &testSM2Key.PublicKey},
+	}
+	for _, c := range cases {
+		for _, e := range encodings {
+			key, err := ParsePublicKey(e.encode(c.pemType, c.der))
+			if err != nil {
+				t.Errorf("%s %s：%v", c.name, e.name, err)
+				continue
+			}
+			if !PublicKeyEqual(c.public, key) {
+				t.Errorf("%s %s：解析的公钥与原公钥不一致", c.name, e.name)
+			}
+		}
+	}
+
+	// PEM中有多个块时使用第一个公钥或证书
+	ecdsaPrivatePEM := encodings[0].encode("EC PRIVATE KEY", mustDER(x509.MarshalECPrivateKey(testECDSAKey)))
+	rsaPublicPEM := encodings[0].encode("PUBLIC KEY", mustDER(x509.MarshalPKIXPublicKey(&testRSAKey.PublicKey)))
+	blockCases := []struct {
+		name   string
+		pem    string
+		public crypto.PublicKey
+	}{
+		{"EC参数在前", encodings[0].encode("EC PARAMETERS", []byte{0x06, 0x08, 0x2a, 0x86, 0x48, 0xce, 0x3d, 0x03, 0x01, 0x07}) + encodings[0].encode("PUBLIC KEY", cases[2].der), &testECDSAKey.PublicKey},
+		{"私钥在前", ecdsaPrivatePEM + rsaPublicPEM, &testRSAKey.PublicKey},
+		{"私钥和证书", ecdsaPrivatePEM + encodings[0].encode("CERTIFICATE", ecdsaCert), &testECDSAKey.PublicKey},
+		{"多个公钥", rsaPublicPEM + encodings[0].encode("PUBLIC KEY", cases[2].der), &testRSAKey.PublicKey},
+	}
+	for _, c := range blockCases {
+		key, err := ParsePublicKey(c.pem)
+		if err != nil || !PublicKeyEqual(c.public, key) {
+			t.Errorf("%s：没有使用第一个公钥或证书：%v", c.name, err)
+		}
+	}
+	if _, err := ParsePublicKey(ecdsaPrivatePEM); err == nil {
+		t.Error("只有私钥时应解析失败")
+	}
+}
+
+func TestDecodeError(t *testing.T) {
+	cases := []struct {
+		name string
+		str  string
+	}{
+		{"空字符串", "  "},
+		{"无效的Base64", "abc$"},
+		{"无效的PEM", "-----BEGIN PUBLIC KEY-----\nabc"},
+		{"无效的DER", base64.RawURLEncoding.EncodeToString([]byte("abc"))},
+	}
+	for _, c := range cases {
+		if _, err := ParsePrivateKey(c.str); err == nil {
+			t.Errorf("%s：解析私钥应失败", c.name)
+		}
+		if _, err := ParsePublicKey(c.str); err == nil {
+			t.Errorf("%s：解析公钥应失败", c.name)
+		}
+	}
+}
+
+func TestParseCertificates(t *testing.T) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caDER := createCertificate(t, "ca", &caKey.PublicKey, nil, caKey)
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leafDER := createCertificate(t, "leaf", &testECDSAKey.PublicKey, ca, caKey)
+	privatePEM := encodings[0].encode("EC PRIVATE KEY", mustDER(x509.MarshalECPrivateKey(testECDSAKey)))
+	chainPEM := encodings[0].encode("CERTIFICATE", leafDER) + encodings[0].encode("CERTIFICATE", caDER)
+
+	cases := []struct {
+		name  string
+		str   string
+		count int
+	}{
+		{"证书链", chainPEM, 2},
+		{"私钥和证书链", privatePEM + chainPEM, 2},
+		{"Base64编码的证书", base64.StdEncoding.EncodeToString(leafDER), 1},
+		{"SM2证书", encodings[0].encode("CERTIFICATE", createSM2Certificate(t, testSM2Key)), 1},
+		{"没有证书", privatePEM, 0},
+		{"无效的证书", encodings[0].encode("CERTIFICATE", []byte("abc")), 0},
+	}
+	for _, c := range cases {
+		certs, err := ParseCertificates(c.str)
+		if (err == nil) != (c.count > 0) || len(certs) != c.count {
+			t.Errorf("%s：期望%d个证书，实际%d个 %v", c.name, c.count, len(certs), err)
+		}
+	}
+	certs, _ := ParseCertificates(privatePEM + chainPEM)
+	if string(certs[0]) != string(leafDER) || string(certs[1]) != string(caDER) {
+		t.Error("证书的顺序无效")
+	}
+}
+
+func TestCertHeaders(t *testing.T) {
+	certDER := createCertificate(t, "ecdsa", &testECDSAKey.PublicKey, nil, testECDSAKey)
+	certPEM := encodings[0].encode("CERTIFICATE", certDER)
+	privatePEM := encodings[0].encode("EC PRIVATE KEY", mustDER(x509.MarshalECPrivateKey(testECDSAKey)))
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha1.Sum(certDER) // nolint:gosec
+	x5c := base64.StdEncoding.EncodeToString(certDER)
+	x5t := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	cases := []struct {
+		name        string
+		certificate string
+		privateKey  string
+		public      crypto.PublicKey
+		x5c, x5t    bool
+		err         string
+	}{
+		{"x5c和x5t", certPEM, "", &testECDSAKey.PublicKey, true, true, ""},
+		{"只有x5t", certPEM, "", &testECDSAKey.PublicKey, false, true, ""},
+		{"从私钥的PEM中查找证书", "", privatePEM + certPEM, &testECDSAKey.PublicKey, true, false, ""},
+		{"证书与私钥不匹配", certPEM, "", &otherKey.PublicKey, true, true, "证书与私钥不匹配"},
+		{"证书与其它算法的私钥不匹配", certPEM, "", &testRSAKey.PublicKey, true, false, "证书与私钥不匹配"},
+		{"没有证书", "", privatePEM, &testECDSAKey.PublicKey, true, false, "没有找到证书"},
+	}
+	for _, c := range cases {
+		headers, err := CertHeaders(c.certificate, c.privateKey, c.public, c.x5c, c.x5t)
+		if c.err != "" {
+			if err == nil || err.Error() != c.err {
+				t.Errorf("%s：期望%q，实际%v", c.name, c.err, err)
+			}
+			continue
+		}
+		if err != nil || len(headers) != 1 {
+			t.Errorf("%s：%v", c.name, err)
+			continue
+		}
+		var header struct {
+			X5C []string `json:"x5c"`
+			X5T string   `json:"x5t"`
+		}
+		if err = json.Unmarshal(headers[0], &header); err != nil {
+			t.Fatal(err)
+		}
+		if c.x5c != (len(header.X5C) == 1 && header.X5C[0] == x5c) || c.x5t != (header.X5T == x5t) {
+			t.Errorf("%s：header无效：%s", c.name, headers[0])
+		}
+	}
+	// 不需要x5c和x5t时不解析证书
+	if headers, err := CertHeaders("abc", "", &otherKey.PublicKey, false, false); err != nil || headers != nil {
+		t.Errorf("不需要证书时应返回nil：%v", err)
+	}
+}
@@ -0,0 +1,231 @@
+package paseto
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"time"
+
+	"local/global"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/chacha20"
+)
+
+// PASETO v4协议的实现，local使用XChaCha20+BLAKE2b，public使用Ed25519
+
+const (
+	V4LocalHeader  = "v4.local."
+	V4PublicHeader = "v4.public."
+	nonceSize      = 32
+	macSize        = 32
+)
+
+var (
+	ErrInvalidToken     = global.ErrTokenMalformed
+	ErrInvalidSignature = global.ErrSignatureInvalid
+	ErrHeaderMismatch   = global.ErrAlgorithmMismatch // token的版本或用途与密钥不匹配
+)
+
+// 预认证编码(Pre-Authentication Encoding)
+func pae(pieces ...[]byte) []byte {
+	size := 8
+	for k := range pieces {
+		size += 8 + len(pieces[k])
+	}
+	buf := make([]byte, 8, size)
+	binary.LittleEndian.PutUint64(buf, uint64(len(pieces))&^(1<<63))
+	for k := range pieces {
+		var length [8]byte
+		binary.LittleEndian.PutUint64(length[:], uint64(len(pieces[k]))&^(1<<63))
+		buf = append(buf, length[:]...)
+		buf = append(buf, pieces[k]...)
+	}
+	return buf
+}
+
+// 拆分token，返回body和footer
+func split(header, token string) (body, footer []byte, err error) {
+	if !strings.HasPrefix(token, header) {
+		if strings.HasPrefix(token, "v") && strings.Count(token, ".") >= 2 {
+			return nil, nil, ErrHeaderMismatch
+		}
+		return nil, nil, ErrInvalidToken
+	}
+	parts := strings.Split(token[len(header):], ".")
+	if len(parts) > 2 {
+		return nil, nil, ErrInvalidToken
+	}
+	if body, err = base64.RawURLEncoding.DecodeString(parts[0]); err != nil {
+		return nil, nil, ErrInvalidToken
+	}
+	if len(parts) == 2 {
+		if footer, err = base64.RawURLEncoding.DecodeString(parts[1]); err != nil {
+			return nil, nil, ErrInvalidToken
+		}
+	}
+	return body, footer, nil
+}
+
+// 拼接token
+func join(header string, body, footer []byte) string {
+	var token strings.Builder
+	token.WriteString(header)
+	token.WriteString(base64.RawURLEncoding.EncodeToString(body))
+	if len(footer) > 0 {
+		token.WriteString(".")
+		token.WriteString(base64.RawURLEncoding.EncodeToString(footer))
+	}
+	return token.String()
+}
+
+// 从加密的key和nonce派生出加密密钥、计数器nonce和认证密钥
+func deriveKeys(key, nonce []byte) (encKey, counterNonce, authKey []byte, err error) {
+	h, err := blake2b.New(56, key)
+	if err != nil {
+		return
+	}
+	h.Write([]byte("paseto-encryption-key"))
+	h.Write(nonce)
+	tmp := h.Sum(nil)
+	encKey, counterNonce = tmp[:32], tmp[32:]
+
+	h, err = blake2b.New(32, key)
+	if err != nil {
+		return
+	}
+	h.Write([]byte("paseto-auth-key-for-aead"))
+	h.Write(nonce)
+	authKey = h.Sum(nil)
+	return
+}
+
+// 计算认证标签
+func mac(authKey []byte, pieces ...[]byte) ([]byte, error) {
+	h, err := blake2b.New(macSize, authKey)
+	if err != nil {
+		return nil, err
+	}
+	h.Write(pae(pieces...))
+	return h.Sum(nil), nil
+}
+
+// v4.local加密
+func V4Encrypt(key, message, footer, implicit []byte) (string, error) {
+	if len(key) != 32 {
+		return "", errors.New("v4.local的密钥长度必须是32字节")
+	}
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return v4Encrypt(key, nonce, message, footer, implicit)
+}
+
+// 使用指定的nonce进行v4.local加密
+func v4Encrypt(key, nonce, message, footer, implicit []byte) (string, error) {
+	encKey, counterNonce, authKey, err := deriveKeys(key, nonce)
+	if err != nil {
+		return "", err
+	}
+	cipher, err := chacha20.NewUnauthenticatedCipher(encKey, counterNonce)
+	if err != nil {
+		return "", err
+	}
+	cipherText := make([]byte, len(message))
+	cipher.XORKeyStream(cipherText, message)
+	tag, err := mac(authKey, []byte(V4LocalHeader), nonce, cipherText, footer, implicit)
+	if err != nil {
+		return "", err
+	}
+	body := make([]byte, 0, nonceSize+len(cipherText)+macSize)
+	body = append(body, nonce...)
+	body = append(body, cipherText...)
+	body = append(body, tag...)
+	return join(V4LocalHeader, body, footer), nil
+}
+
+// v4.local解密，返回明文和footer
+func V4Decrypt(key []byte, token string, implicit []byte) (message, footer []byte, err error) {
+	if len(key) != 32 {
+		return nil, nil, errors.New("v4.local的密钥长度必须是32字节")
+	}
+	body, footer, err := split(V4LocalHeader, token)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(body) < nonceSize+macSize {
+		return nil, nil, ErrInvalidToken
+	}
+	nonce := body[:nonceSize]
+	cipherText := body[nonceSize : len(body)-macSize]
+	tag := body[len(body)-macSize:]
+	encKey, counterNonce, authKey, err := deriveKeys(key, nonce)
+	if err != nil {
+		return nil, nil, err
+	}
+	expected, err := mac(authKey, []byte(V4LocalHeader), nonce, cipherText, footer, implicit)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !hmac.Equal(tag, expected) {
+		return nil, nil, ErrInvalidSignature
+	}
+	cipher, err := chacha20.NewUnauthenticatedCipher(encKey, counterNonce)
+	if err != nil {
+		return nil, nil, err
+	}
+	message = make([]byte, len(cipherText))
+	cipher.XORKeyStream(message, cipherText)
+	return message, footer, nil
+}
+
+// v4.public签名
+func V4Sign(privateKey ed25519.PrivateKey, message, footer, implicit []byte) string {
+	sig := ed25519.Sign(privateKey, pae([]byte(V4PublicHeader), message, footer, implicit))
+	body := make([]byte, 0, len(message)+ed25519.SignatureSize)
+	body = append(body, message...)
+	body = append(body, sig...)
+	return join(V4PublicHeader, body, footer)
+}
+
+// v4.public验签，返回消息和footer
+func V4Verify(publicKey ed25519.PublicKey, token string, implicit []byte) (message, footer []byte, err error) {
+	body, footer, err := split(V4PublicHeader, token)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(body) < ed25519.SignatureSize {
+		return nil, nil, ErrInvalidToken
+	}
+	message = body[:len(body)-ed25519.SignatureSize]
+	sig := body[len(body)-ed25519.SignatureSize:]
+	if !ed25519.Verify(publicKey, pae([]byte(V4PublicHeader), message, footer, implicit), sig) {
+		return nil, nil, ErrInvalidSignature
+	}
+	return message, footer, nil
+}
+
+// Unix时间戳转为claims中使用的RFC3339时间字符串，为0时返回空字符串
+func FormatTime(unix int64) string {
+	if unix == 0 {
+		return ""
+	}
+	return time.Unix(unix, 0).Format(time.RFC3339)
+}
+
+// claims中的RFC3339时间字符串转为Unix时间戳，为空时返回0
+func ParseTime(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return 0, err
+	}
+	return t.Unix(), nil
+}
@@ -0,0 +1,247 @@
+package paseto
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"testing"
+)
+
+// PASETO官方测试向量(https://github.com/paseto-standard/test-vectors/blob/master/v4.json)
+
+const (
+	vectorKey          = "707172737475767778797a7b7c7d7e7f808182838485868788898a8b8c8d8e8f"
+	vectorNonce        = "df654812bac492663825520ba2f6e67cf5ca5bdc13d4e7507a98cc4c2fcc3ad8"
+	vectorSecretKey    = "b4cbfb43df4ce210727d953e4a713307fa19bb7d9f85041438d9e11b942a37741eb9dbbbbc047c03fd70604e0071f0987e16b28b757225c11f00415d0e20b1a2"
+	vectorPublicKey    = "1eb9dbbbbc047c03fd70604e0071f0987e16b28b757225c11f00415d0e20b1a2"
+	vectorSecretData   = `{"data":"this is a secret message","exp":"2022-01-01T00:00:00+00:00"}`
+	vectorHiddenData   = `{"data":"this is a hidden message","exp":"2022-01-01T00:00:00+00:00"}`
+	vectorSignedData   = `{"data":"this is a signed message","exp":"2022-01-01T00:00:00+00:00"}`
+	vectorFooter       = `{"kid":"zVhMiPBP9fRf2snEcT7gFTioeA9COcNy9DfgL1W60haN"}`
+	vectorStringFooter = "arbitrary-string-that-isn't-json"
+)
+
+type vector struct {
+	name     string
+	nonce    string
+	token    string
+	payload  string
+	footer   string
+	implicit string
+}
+
+var localVectors = []vector{
+	{
+		name:    "4-E-1",
+		nonce:   "0000000000000000000000000000000000000000000000000000000000000000",
+		token:   "v4.local.AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAQAr68PS4AXe7If_ZgesdkUMvSwscFlAl1pk5HC0e8kApeaqMfGo_7OpBnwJOAbY9V7WU6abu74MmcUE8YWAiaArVI8XJ5hOb_4v9RmDkneN0S92dx0OW4pgy7omxgf3S8c3LlQg",
+		payload: vectorSecretData,
+	},
+	{
+		name:    "4-E-2",
+		nonce:   "0000000000000000000000000000000000000000000000000000000000000000",
+		token:   "v4.local.AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAQAr68PS4AXe7If_ZgesdkUMvS2csCgglvpk5HC0e8kApeaqMfGo_7OpBnwJOAbY9V7WU6abu74MmcUE8YWAiaArVI8XIemu9chy3WVKvRBfg6t8wwYHK0ArLxxfZP73W_vfwt5A",
+		payload: vectorHiddenData,
+	},
+	{
+		name:    "4-E-3",
+		nonce:   vectorNonce,
+		token:   "v4.local.32VIErrEkmY4JVILovbmfPXKW9wT1OdQepjMTC_MOtjA4kiqw7_tcaOM5GNEcnTxl60WkwMsYXw6FSNb_UdJPXjpzm0KW9ojM5f4O2mRvE2IcweP-PRdoHjd5-RHCiExR1IK6t6-tyebyWG6Ov7kKvBdkrrAJ837lKP3iDag2hzUPHuMKA",
+		payload: vectorSecretData,
+	},
+	{
+		name:    "4-E-4",
+		nonce:   vectorNonce,
+		token:   "v4.local.32VIErrEkmY4JVILovbmfPXKW9wT1OdQepjMTC_MOtjA4kiqw7_tcaOM5GNEcnTxl60WiA8rd3wgFSNb_UdJPXjpzm0KW9ojM5f4O2mRvE2IcweP-PRdoHjd5-RHCiExR1IK6t4gt6TiLm55vIH8c_lGxxZpE3AWlH4WTR0v45nsWoU3gQ",
+		payload: vectorHiddenData,
+	},
+	{
+		name:    "4-E-5",
+		nonce:   vectorNonce,
+		token:   "v4.local.32VIErrEkmY4JVILovbmfPXKW9wT1OdQepjMTC_MOtjA4kiqw7_tcaOM5GNEcnTxl60WkwMsYXw6FSNb_UdJPXjpzm0KW9ojM5f4O2mRvE2IcweP-PRdoHjd5-RHCiExR1IK6t4x-RMNXtQNbz7FvFZ_G-lFpk5RG3EOrwDL6CgDqcerSQ.eyJraWQiOiJ6VmhNaVBCUDlmUmYyc25FY1Q3Z0ZUaW9lQTlDT2NOeTlEZmdMMVc2MGhhTiJ9",
+		payload: vectorSecretData,
+		footer:  vectorFooter,
+	},
+	{
+		name:    "4-E-6",
+		nonce:   vectorNonce,
+		token:   "v4.local.32VIErrEkmY4JVILovbmfPXKW9wT1OdQepjMTC_MOtjA4kiqw7_tcaOM5GNEcnTxl60WiA8rd3wgFSNb_UdJPXjpzm0KW9ojM5f4O2mRvE2IcweP-PRdoHjd5-RHCiExR1IK6t6pWSA5HX2wjb3P-xLQg5K5feUCX4P2fpVK3ZLWFbMSxQ.eyJraWQiOiJ6VmhNaVBCUDlmUmYyc25FY1Q3Z0ZUaW9lQTlDT2NOeTlEZmdMMVc2MGhhTiJ9",
+		payload: vectorHiddenData,
+		footer:  vectorFooter,
+	},
+	{
+		name:     "4-E-7",
+		nonce:    vectorNonce,
+		token:    "v4.local.32VIErrEkmY4JVILovbmfPXKW9wT1OdQepjMTC_MOtjA4kiqw7_tcaOM5GNEcnTxl60WkwMsYXw6FSNb_UdJPXjpzm0KW9ojM5f4O2mRvE2IcweP-PRdoHjd5-RHCiExR1IK6t40KCCWLA7GYL9KFHzKlwY9_RnIfRrMQpueydLEAZGGcA.eyJraWQiOiJ6VmhNaVBCUDlmUmYyc25FY1Q3Z0ZUaW9lQTlDT2NOeTlEZmdMMVc2MGhhTiJ9",
+		payload:  vectorSecretData,
+		footer:   vectorFooter,
+		implicit: `{"test-vector":"4-E-7"}`,
+	},
+	{
+		name:     "4-E-8",
+		nonce:    vectorNonce,
+		token:    "v4.local.32VIErrEkmY4JVILovbmfPXKW9wT1OdQepjMTC_MOtjA4kiqw7_tcaOM5GNEcnTxl60WiA8rd3wgFSNb_UdJPXjpzm0KW9ojM5f4O2mRvE2IcweP-PRdoHjd5-RHCiExR1IK6t5uvqQbMGlLLNYBc7A6_x7oqnpUK5WLvj24eE4DVPDZjw.eyJraWQiOiJ6VmhNaVBCUDlmUmYyc25FY1Q3Z0ZUaW9lQTlDT2NOeTlEZmdMMVc2MGhhTiJ9",
+		payload:  vectorHiddenData,
+		footer:   vectorFooter,
+		implicit: `{"test-vector":"4-E-8"}`,
+	},
+	{
+		name:     "4-E-9",
+		nonce:    vectorNonce,
+		token:    "v4.local.32VIErrEkmY4JVILovbmfPXKW9wT1OdQepjMTC_MOtjA4kiqw7_tcaOM5GNEcnTxl60WiA8rd3wgFSNb_UdJPXjpzm0KW9ojM5f4O2mRvE2IcweP-PRdoHjd5-RHCiExR1IK6t6tybdlmnMwcDMw0YxA_gFSE_IUWl78aMtOepFYSWYfQA.YXJiaXRyYXJ5LXN0cmluZy10aGF0LWlzbid0LWpzb24",
+		payload:  vectorHiddenData,
+		footer:   vectorStringFooter,
+		implicit: `{"test-vector":"4-E-9"}`,
+	},
+}
+
+var publicVectors = []vector{
+	{
+		name:    "4-S-1",
+		token:   "v4.public.eyJkYXRhIjoidGhpcyBpcyBhIHNpZ25lZCBtZXNzYWdlIiwiZXhwIjoiMjAyMi0wMS0wMVQwMDowMDowMCswMDowMCJ9bg_XBBzds8lTZShVlwwKSgeKpLT3yukTw6JUz3W4h_ExsQV-P0V54zemZDcAxFaSeef1QlXEFtkqxT1ciiQEDA",
+		payload: vectorSignedData,
+	},
+	{
+		name:    "4-S-2",
+		token:   "v4.public.eyJkYXRhIjoidGhpcyBpcyBhIHNpZ25lZCBtZXNzYWdlIiwiZXhwIjoiMjAyMi0wMS0wMVQwMDowMDowMCswMDowMCJ9v3Jt8mx_TdM2ceTGoqwrh4yDFn0XsHvvV_D0DtwQxVrJEBMl0F2caAdgnpKlt4p7xBnx1HcO-SPo8FPp214HDw.eyJraWQiOiJ6VmhNaVBCUDlmUmYyc25FY1Q3Z0ZUaW9lQTlDT2NOeTlEZmdMMVc2MGhhTiJ9",
+		payload: vectorSignedData,
+		footer:  vectorFooter,
+	},
+	{
+		name:     "4-S-3",
+		token:    "v4.public.eyJkYXRhIjoidGhpcyBpcyBhIHNpZ25lZCBtZXNzYWdlIiwiZXhwIjoiMjAyMi0wMS0wMVQwMDowMDowMCswMDowMCJ9NPWciuD3d0o5eXJXG5pJy-DiVEoyPYWs1YSTwWHNJq6DZD3je5gf-0M4JR9ipdUSJbIovzmBECeaWmaqcaP0DQ.eyJraWQiOiJ6VmhNaVBCUDlmUmYyc25FY1Q3Z0ZUaW9lQTlDT2NOeTlEZmdMMVc2MGhhTiJ9",
+		payload:  vectorSignedData,
+		footer:   vectorFooter,
+		implicit: `{"test-vector":"4-S-3"}`,
+	},
+}
+
+func mustHex(t *testing.T, value string) []byte {
+	t.Helper()
+	data, err := hex.DecodeString(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// 修改token的body中指定位置的一个bit
+func tamper(t *testing.T, header, token string, offset int) string {
+	t.Helper()
+	body, footer, err := split(header, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body[offset] ^= 1
+	return join(header, body, footer)
+}
+
+func TestV4LocalVectors(t *testing.T) {
+	key := mustHex(t, vectorKey)
+	for _, v := range localVectors {
+		t.Run(v.name, func(t *testing.T) {
+			token, err := v4Encrypt(key, mustHex(t, v.nonce), []byte(v.payload), []byte(v.footer), []byte(v.implicit))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if token != v.token {
+				t.Fatalf("加密结果不一致：%s", token)
+			}
+			message, footer, err := V4Decrypt(key, v.token, []byte(v.implicit))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(message) != v.payload || string(footer) != v.footer {
+				t.Fatalf("解密结果不一致：%s %s", message, footer)
+			}
+		})
+	}
+}
+
+func TestV4PublicVectors(t *testing.T) {
+	privateKey := ed25519.PrivateKey(mustHex(t, vectorSecretKey))
+	publicKey := ed25519.PublicKey(mustHex(t, vectorPublicKey))
+	if !bytes.Equal(privateKey.Public().(ed25519.PublicKey), publicKey) {
+		t.Fatal("公钥与私钥不匹配")
+	}
+	for _, v := range publicVectors {
+		t.Run(v.name, func(t *testing.T) {
+			token := V4Sign(privateKey, []byte(v.payload), []byte(v.footer), []byte(v.implicit))
+			if token != v.token {
+				t.Fatalf("签名结果不一致：%s", token)
+			}
+			message, footer, err := V4Verify(publicKey, v.token, []byte(v.implicit))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(message) != v.payload || string(footer) != v.footer {
+				t.Fatalf("验签结果不一致：%s %s", message, footer)
+			}
+		})
+	}
+}
+
+func TestV4LocalInvalid(t *testing.T) {
+	key := mustHex(t, vectorKey)
+	v := localVectors[6]
+	tampered := tamper(t, V4LocalHeader, v.token, nonceSize)
+
+	otherKey := mustHex(t, vectorKey)
+	otherKey[0] ^= 1
+
+	cases := []struct {
+		name     string
+		key      []byte
+		token    string
+		implicit string
+		err      error
+	}{
+		{"篡改的密文", key, tampered, v.implicit, ErrInvalidSignature},
+		{"错误的implicit", key, v.token, `{"test-vector":"4-E-8"}`, ErrInvalidSignature},
+		{"缺少implicit", key, v.token, "", ErrInvalidSignature},
+		{"错误的密钥", otherKey, v.token, v.implicit, ErrInvalidSignature},
+		{"public的token", key, publicVectors[0].token, "", ErrHeaderMismatch},
+		{"其他版本的token", key, "v3.local.AAAA", "", ErrHeaderMismatch},
+		{"过短的token", key, "v4.local.AAAA", "", ErrInvalidToken},
+		{"无效的编码", key, "v4.local.!!!!", "", ErrInvalidToken},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if _, _, err := V4Decrypt(c.key, c.token, []byte(c.implicit)); err != c.err {
+				t.Fatalf("期望错误%v，实际为%v", c.err, err)
+			}
+		})
+	}
+}
+
+func TestV4PublicInvalid(t *testing.T) {
+	publicKey := ed25519.PublicKey(mustHex(t, vectorPublicKey))
+	v := publicVectors[2]
+	tampered := tamper(t, V4PublicHeader, v.token, 0)
+
+	otherKey, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name     string
+		key      ed25519.PublicKey
+		token    string
+		implicit string
+		err      error
+	}{
+		{"篡改的消息", publicKey, tampered, v.implicit, ErrInvalidSignature},
+		{"错误的implicit", publicKey, v.token, `{"test-vector":"4-S-2"}`, ErrInvalidSignature},
+		{"错误的公钥", otherKey, v.token, v.implicit, ErrInvalidSignature},
+		{"local的token", publicKey, localVectors[0].token, "", ErrHeaderMismatch},
+		{"过短的token", publicKey, "v4.public.AAAA", "", ErrInvalidToken},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if _, _, err := V4Verify(c.key, c.token, []byte(c.implicit)); err != c.err {
+				t.Fatalf("期望错误%v，实际为%v", c.err, err)
+			}
+		})
+	}
+}
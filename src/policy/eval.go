@@ -0,0 +1,332 @@
+package policy
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// 语法树的节点
+type node interface {
+	eval(vars map[string]interface{}) (interface{}, error)
+}
+
+// 常量
+type literalNode struct {
+	value interface{}
+}
+
+func (n *literalNode) eval(map[string]interface{}) (interface{}, error) {
+	return n.value, nil
+}
+
+// 变量
+type variableNode struct {
+	name string
+}
+
+func (n *variableNode) eval(vars map[string]interface{}) (interface{}, error) {
+	return vars[n.name], nil
+}
+
+// 列表
+type listNode struct {
+	items []node
+}
+
+func (n *listNode) eval(vars map[string]interface{}) (interface{}, error) {
+	list := make([]interface{}, len(n.items))
+	for k := range n.items {
+		value, err := n.items[k].eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		list[k] = value
+	}
+	return list, nil
+}
+
+// 字段或下标访问，map中不存在的字段和null的字段都为null
+type indexNode struct {
+	target node
+	index  node
+}
+
+func (n *indexNode) eval(vars map[string]interface{}) (interface{}, error) {
+	target, err := n.target.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	index, err := n.index.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	switch t := target.(type) {
+	case nil:
+		return nil, nil
+	case map[string]interface{}:
+		key, ok := index.(string)
+		if !ok {
+			return nil, errors.New("字段名必须是字符串")
+		}
+		return t[key], nil
+	case []interface{}:
+		i, ok := index.(float64)
+		if !ok || i != float64(int(i)) {
+			return nil, errors.New("下标必须是整数")
+		}
+		if i < 0 || int(i) >= len(t) {
+			return nil, nil
+		}
+		return t[int(i)], nil
+	}
+	return nil, errors.New("只有对象和列表可以访问字段或下标")
+}
+
+// 逻辑运算，短路求值
+type logicalNode struct {
+	op          string
+	left, right node
+}
+
+func (n *logicalNode) eval(vars map[string]interface{}) (interface{}, error) {
+	left, err := evalBool(n.left, vars)
+	if err != nil {
+		return nil, err
+	}
+	if (n.op == "||" && left) || (n.op == "&&" && !left) {
+		return left, nil
+	}
+	return evalBool(n.right, vars)
+}
+
+// 逻辑非
+type notNode struct {
+	operand node
+}
+
+func (n *notNode) eval(vars map[string]interface{}) (interface{}, error) {
+	value, err := evalBool(n.operand, vars)
+	if err != nil {
+		return nil, err
+	}
+	return !value, nil
+}
+
+// 取负数
+type negateNode struct {
+	operand node
+}
+
+func (n *negateNode) eval(vars map[string]interface{}) (interface{}, error) {
+	value, err := n.operand.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	number, ok := value.(float64)
+	if !ok {
+		return nil, errors.New("只有数字可以取负数")
+	}
+	return -number, nil
+}
+
+// 比较运算
+type compareNode struct {
+	op          string
+	left, right node
+}
+
+func (n *compareNode) eval(vars map[string]interface{}) (interface{}, error) {
+	left, err := n.left.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	right, err := n.right.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "==":
+		return equal(left, right), nil
+	case "!=":
+		return !equal(left, right), nil
+	case "in":
+		return contains(right, left)
+	}
+	return order(n.op, left, right)
+}
+
+// 函数调用
+type callNode struct {
+	name string
+	fn   func(args []interface{}) (interface{}, error)
+	args []node
+}
+
+func (n *callNode) eval(vars map[string]interface{}) (interface{}, error) {
+	args := make([]interface{}, len(n.args))
+	for k := range n.args {
+		value, err := n.args[k].eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		args[k] = value
+	}
+	return n.fn(args)
+}
+
+// 求值并要求结果为布尔值
+func evalBool(n node, vars map[string]interface{}) (bool, error) {
+	value, err := n.eval(vars)
+	if err != nil {
+		return false, err
+	}
+	result, ok := value.(bool)
+	if !ok {
+		return false, errors.New("逻辑运算的值必须是布尔值")
+	}
+	return result, nil
+}
+
+// 判断两个值是否相等，类型不同时不相等
+func equal(left, right interface{}) bool {
+	return reflect.DeepEqual(left, right)
+}
+
+// 判断集合中是否包含值，集合可以是列表、对象(判断字段名)或CIDR
+func contains(set, value interface{}) (bool, error) {
+	switch s := set.(type) {
+	case nil:
+		return false, nil
+	case []interface{}:
+		for k := range s {
+			if equal(s[k], value) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case map[string]interface{}:
+		key, ok := value.(string)
+		if !ok {
+			return false, nil
+		}
+		_, exists := s[key]
+		return exists, nil
+	case *net.IPNet:
+		str, ok := value.(string)
+		if !ok {
+			return false, nil
+		}
+		ip := net.ParseIP(str)
+		return ip != nil && s.Contains(ip), nil
+	}
+	return false, errors.New("in的右侧必须是列表、对象或CIDR")
+}
+
+// 比较数字或字符串的大小
+func order(op string, left, right interface{}) (bool, error) {
+	var result int
+	switch l := left.(type) {
+	case float64:
+		r, ok := right.(float64)
+		if !ok {
+			return false, errors.New("只能比较相同类型的值")
+		}
+		switch {
+		case l < r:
+			result = -1
+		case l > r:
+			result = 1
+		}
+	case string:
+		r, ok := right.(string)
+		if !ok {
+			return false, errors.New("只能比较相同类型的值")
+		}
+		result = strings.Compare(l, r)
+	default:
+		return false, errors.New("只能比较数字或字符串的大小")
+	}
+	switch op {
+	case "<":
+		return result < 0, nil
+	case "<=":
+		return result <= 0, nil
+	case ">":
+		return result > 0, nil
+	}
+	return result >= 0, nil
+}
+
+// 内置函数
+type function struct {
+	args int
+	call func(args []interface{}) (interface{}, error)
+}
+
+var functions = map[string]function{
+	// 解析CIDR，用于ip in cidr("10.0.0.0/8")
+	"cidr": {args: 1, call: func(args []interface{}) (interface{}, error) {
+		s, ok := args[0].(string)
+		if !ok {
+			return nil, errors.New("cidr的参数必须是字符串")
+		}
+		_, ipNet, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, errors.New("无效的CIDR：" + s)
+		}
+		return ipNet, nil
+	}},
+	// 字符串、列表或对象的长度
+	"size": {args: 1, call: func(args []interface{}) (interface{}, error) {
+		switch v := args[0].(type) {
+		case string:
+			return float64(len([]rune(v))), nil
+		case []interface{}:
+			return float64(len(v)), nil
+		case map[string]interface{}:
+			return float64(len(v)), nil
+		case nil:
+			return float64(0), nil
+		}
+		return nil, errors.New("size的参数必须是字符串、列表或对象")
+	}},
+	"startsWith": {args: 2, call: stringFunc(strings.HasPrefix)},
+	"endsWith":   {args: 2, call: stringFunc(strings.HasSuffix)},
+	"contains":   {args: 2, call: stringFunc(strings.Contains)},
+	// 正则匹配，正则为常量时在编译时解析
+	"matches": {args: 2, call: func(args []interface{}) (interface{}, error) {
+		s, ok := args[0].(string)
+		if !ok {
+			return false, nil
+		}
+		switch re := args[1].(type) {
+		case *regexp.Regexp:
+			return re.MatchString(s), nil
+		case string:
+			compiled, err := regexp.Compile(re)
+			if err != nil {
+				return nil, errors.New("无效的正则表达式：" + re)
+			}
+			return compiled.MatchString(s), nil
+		}
+		return nil, errors.New("matches的第2个参数必须是字符串")
+	}},
+}
+
+// 将两个字符串参数的函数转为内置函数，第1个参数不是字符串时返回false
+func stringFunc(fn func(s, sub string) bool) func(args []interface{}) (interface{}, error) {
+	return func(args []interface{}) (interface{}, error) {
+		s, ok := args[0].(string)
+		if !ok {
+			return false, nil
+		}
+		sub, ok := args[1].(string)
+		if !ok {
+			return nil, errors.New("第2个参数必须是字符串")
+		}
+		return fn(s, sub), nil
+	}
+}
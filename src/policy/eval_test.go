@@ -0,0 +1,151 @@
+package policy
+
+import (
+	"testing"
+	"time"
+
+	"local/global"
+)
+
+// 测试用的变量
+func testVars(ip string) map[string]interface{} {
+	return Vars(global.AuthorizerClaims{
+		Subject: "bob",
+		Aud:     "billing",
+		Claims: map[string]interface{}{
+			"admin":   true,
+			"level":   float64(3),
+			"roles":   []interface{}{"admin", "dev"},
+			"meta":    map[string]interface{}{"team": "a", "tags": nil},
+			"pattern": "[",
+			"network": "10.1.0.0/16",
+		},
+	}, Request{IP: ip, Method: "GET", Resource: "/orders/1", Time: time.Date(2024, 1, 1, 9, 30, 0, 0, time.Local)})
+}
+
+func TestEval(t *testing.T) {
+	cases := []struct {
+		name string
+		expr string
+		want bool
+	}{
+		// 优先级：! 高于比较运算，比较运算高于 &&，&& 高于 ||
+		{"&&优先于||", `true || false && false`, true},
+		{"括号", `(true || false) && false`, false},
+		{"!优先于&&", `!false && false`, false},
+		{"!优先于比较", `!claims.admin == false`, true},
+		{"双重否定", `!!claims.admin`, true},
+		{"负数", `-claims.level < -2`, true},
+		// 短路求值，右侧的类型错误不会被求值
+		{"||短路", `claims.admin || claims.sub < 1`, true},
+		{"&&短路", `!claims.admin && claims.sub < 1`, false},
+		// in
+		{"列表包含", `"dev" in claims.roles`, true},
+		{"列表不包含", `"ops" in claims.roles`, false},
+		{"常量列表", `claims.sub in ["alice", "bob"]`, true},
+		{"不同类型不相等", `"3" in [claims.level]`, false},
+		{"对象的字段名", `"team" in claims.meta`, true},
+		{"null集合", `"a" in claims.missing`, false},
+		{"CIDR包含", `request.ip in cidr("10.0.0.0/8")`, true},
+		{"CIDR不包含", `request.ip in cidr("192.168.0.0/16")`, false},
+		{"动态CIDR", `request.ip in cidr(claims.network)`, true},
+		// 比较
+		{"字符串比较", `claims.sub >= "b" && claims.sub < "c"`, true},
+		{"数字比较", `claims.level > 2 && claims.level <= 3`, true},
+		{"字段访问", `claims.meta.team == "a" && claims["meta"]["team"] == "a"`, true},
+		{"下标访问", `claims.roles[1] == "dev"`, true},
+		{"下标越界为null", `claims.roles[5] == null`, true},
+		{"请求的上下文", `request.method == "GET" && request.hour == 9 && request.minute == 30`, true},
+		{"标准claims", `claims.sub == "bob" && claims.aud == "billing" && claims.exp == 0`, true},
+		// 不存在和null的字段
+		{"不存在的字段为null", `claims.missing == null`, true},
+		{"null的字段", `claims.meta.tags == null`, true},
+		{"null的字段访问", `claims.missing.a.b == null`, true},
+		{"null不等于空字符串", `claims.missing != ""`, true},
+		{"null的长度", `size(claims.missing) == 0`, true},
+		// 函数
+		{"size", `size(claims.sub) == 3 && size(claims.roles) == 2 && size(claims.meta) == 2`, true},
+		{"startsWith", `startsWith(request.resource, "/orders/")`, true},
+		{"endsWith", `endsWith(claims.sub, "ob")`, true},
+		{"contains", `contains(claims.aud, "ill")`, true},
+		{"非字符串参数", `startsWith(claims.level, "3")`, false},
+		{"matches", `matches(request.resource, "^/orders/[0-9]+$")`, true},
+		{"matches不匹配", `matches(claims.sub, "^a")`, false},
+		{"matches非字符串", `matches(claims.missing, "^a")`, false},
+	}
+	vars := testVars("10.1.2.3")
+	for _, c := range cases {
+		program, err := Compile(c.expr)
+		if err != nil {
+			t.Errorf("%s：编译失败：%v", c.name, err)
+			continue
+		}
+		got, err := program.Eval(vars)
+		if err != nil || got != c.want {
+			t.Errorf("%s：期望%v，实际%v %v", c.name, c.want, got, err)
+		}
+	}
+}
+
+// 类型不匹配和运行时才能发现的错误
+func TestEvalError(t *testing.T) {
+	cases := []struct {
+		expr string
+		err  string
+	}{
+		{`claims.sub < 1`, "只能比较相同类型的值"},
+		{`claims.level >= "3"`, "只能比较相同类型的值"},
+		{`claims.admin < true`, "只能比较数字或字符串的大小"},
+		{`claims.missing > 1`, "只能比较数字或字符串的大小"},
+		{`claims.sub && true`, "逻辑运算的值必须是布尔值"},
+		{`false || claims.level`, "逻辑运算的值必须是布尔值"},
+		{`!claims.missing`, "逻辑运算的值必须是布尔值"},
+		{`claims.sub`, "逻辑运算的值必须是布尔值"},
+		{`-claims.sub == 1`, "只有数字可以取负数"},
+		{`"a" in claims.sub`, "in的右侧必须是列表、对象或CIDR"},
+		{`claims.sub.a == null`, "只有对象和列表可以访问字段或下标"},
+		{`claims.roles["a"] == null`, "下标必须是整数"},
+		{`claims.roles[0.5] == null`, "下标必须是整数"},
+		{`claims.meta[1] == null`, "字段名必须是字符串"},
+		{`size(claims.level) == 1`, "size的参数必须是字符串、列表或对象"},
+		{`startsWith(claims.sub, claims.level)`, "第2个参数必须是字符串"},
+		{`request.ip in cidr(claims.sub)`, "无效的CIDR：bob"},
+		{`request.ip in cidr(claims.level)`, "cidr的参数必须是字符串"},
+		{`matches(claims.sub, claims.pattern)`, "无效的正则表达式：["},
+		{`matches(claims.sub, claims.level)`, "matches的第2个参数必须是字符串"},
+	}
+	vars := testVars("10.1.2.3")
+	for _, c := range cases {
+		program, err := Compile(c.expr)
+		if err != nil {
+			t.Errorf("%s：编译失败：%v", c.expr, err)
+			continue
+		}
+		if _, err = program.Eval(vars); err == nil || err.Error() != c.err {
+			t.Errorf("%s：期望%q，实际%v", c.expr, c.err, err)
+		}
+	}
+}
+
+// 请求中没有ip时为空字符串，不属于任何CIDR
+func TestEvalWithoutIP(t *testing.T) {
+	cases := []struct {
+		expr string
+		want bool
+	}{
+		{`request.ip == ""`, true},
+		{`request.ip in cidr("0.0.0.0/0")`, false},
+		{`!(request.ip in cidr("10.0.0.0/8"))`, true},
+		{`request.ip in ["10.1.2.3"]`, false},
+	}
+	vars := testVars("")
+	for _, c := range cases {
+		program, err := Compile(c.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := program.Eval(vars); err != nil || got != c.want {
+			t.Errorf("%s：期望%v，实际%v %v", c.expr, c.want, got, err)
+		}
+	}
+}
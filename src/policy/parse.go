@@ -0,0 +1,392 @@
+package policy
+
+import (
+	"errors"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// 词法单元的类型
+const (
+	tokenEOF = iota
+	tokenIdent
+	tokenNumber
+	tokenString
+	tokenOperator
+)
+
+// 词法单元
+type token struct {
+	kind  int
+	value string
+	pos   int
+}
+
+// 可用的运算符，较长的运算符在前
+var operators = []string{"||", "&&", "==", "!=", "<=", ">=", "<", ">", "!", "-", "(", ")", "[", "]", ".", ","}
+
+// 将表达式拆分为词法单元
+func tokenize(expr string) ([]token, error) {
+	var tokens []token
+	for pos := 0; pos < len(expr); {
+		c := rune(expr[pos])
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			pos++
+		case isIdentStart(c):
+			start := pos
+			for pos < len(expr) && (isIdentStart(rune(expr[pos])) || isDigit(rune(expr[pos]))) {
+				pos++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, value: expr[start:pos], pos: start})
+		case isDigit(c):
+			start := pos
+			for pos < len(expr) && (isDigit(rune(expr[pos])) || expr[pos] == '.') {
+				pos++
+			}
+			tokens = append(tokens, token{kind: tokenNumber, value: expr[start:pos], pos: start})
+		case c == '"' || c == '\'':
+			value, end, err := readString(expr, pos)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{kind: tokenString, value: value, pos: pos})
+			pos = end
+		default:
+			matched := false
+			for _, op := range operators {
+				if strings.HasPrefix(expr[pos:], op) {
+					tokens = append(tokens, token{kind: tokenOperator, value: op, pos: pos})
+					pos += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, errors.New("无效的字符：" + string(c) + "，位置" + strconv.Itoa(pos))
+			}
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(expr)}), nil
+}
+
+// 标识符只能由ASCII字母、数字和下划线组成，不能以数字开头
+func isIdentStart(c rune) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c rune) bool {
+	return c >= '0' && c <= '9'
+}
+
+// 读取以单引号或双引号包围的字符串，支持反斜杠转义，返回字符串的值和结束位置
+func readString(expr string, start int) (string, int, error) {
+	var value strings.Builder
+	quote := expr[start]
+	for pos := start + 1; pos < len(expr); pos++ {
+		switch expr[pos] {
+		case quote:
+			return value.String(), pos + 1, nil
+		case '\\':
+			pos++
+			if pos >= len(expr) {
+				break
+			}
+			switch expr[pos] {
+			case 'n':
+				value.WriteByte('\n')
+			case 't':
+				value.WriteByte('\t')
+			default:
+				value.WriteByte(expr[pos])
+			}
+		default:
+			value.WriteByte(expr[pos])
+		}
+	}
+	return "", 0, errors.New("字符串没有结束，位置" + strconv.Itoa(start))
+}
+
+// 语法解析器
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+// 将表达式解析为语法树
+func parse(expr string) (node, error) {
+	tokens, err := tokenize(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := parser{tokens: tokens}
+	n, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tokenEOF {
+		return nil, p.unexpected()
+	}
+	return n, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+// 当前词法单元是指定的运算符时前进并返回true
+func (p *parser) accept(op string) bool {
+	if t := p.peek(); t.kind == tokenOperator && t.value == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(op string) error {
+	if !p.accept(op) {
+		return errors.New("缺少" + op + "，位置" + strconv.Itoa(p.peek().pos))
+	}
+	return nil
+}
+
+func (p *parser) unexpected() error {
+	return unexpected(p.peek())
+}
+
+func unexpected(t token) error {
+	if t.kind == tokenEOF {
+		return errors.New("表达式不完整")
+	}
+	return errors.New("无效的表达式：" + t.value + "，位置" + strconv.Itoa(t.pos))
+}
+
+// or := and ("||" and)*
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalNode{op: "||", left: left, right: right}
+	}
+	return left, nil
+}
+
+// and := compare ("&&" compare)*
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseCompare()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&") {
+		right, err := p.parseCompare()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalNode{op: "&&", left: left, right: right}
+	}
+	return left, nil
+}
+
+// compare := unary (("=="|"!="|"<"|"<="|">"|">="|"in") unary)?
+func (p *parser) parseCompare() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	t := p.peek()
+	switch {
+	case t.kind == tokenOperator && (t.value == "==" || t.value == "!=" || t.value == "<" || t.value == "<=" || t.value == ">" || t.value == ">="):
+	case t.kind == tokenIdent && t.value == "in":
+	default:
+		return left, nil
+	}
+	p.next()
+	right, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	return &compareNode{op: t.value, left: left, right: right}, nil
+}
+
+// unary := ("!"|"-") unary | postfix
+func (p *parser) parseUnary() (node, error) {
+	if p.accept("!") {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{operand: operand}, nil
+	}
+	if p.accept("-") {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &negateNode{operand: operand}, nil
+	}
+	return p.parsePostfix()
+}
+
+// postfix := primary ("." ident | "[" or "]")*
+func (p *parser) parsePostfix() (node, error) {
+	n, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case p.accept("."):
+			t := p.next()
+			if t.kind != tokenIdent {
+				return nil, errors.New("无效的字段名，位置" + strconv.Itoa(t.pos))
+			}
+			n = &indexNode{target: n, index: &literalNode{value: t.value}}
+		case p.accept("["):
+			index, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if err = p.expect("]"); err != nil {
+				return nil, err
+			}
+			n = &indexNode{target: n, index: index}
+		default:
+			return n, nil
+		}
+	}
+}
+
+// primary := number | string | true | false | null | 变量 | 函数调用 | "(" or ")" | "[" 列表 "]"
+func (p *parser) parsePrimary() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenNumber:
+		value, err := strconv.ParseFloat(t.value, 64)
+		if err != nil {
+			return nil, errors.New("无效的数字：" + t.value)
+		}
+		return &literalNode{value: value}, nil
+	case tokenString:
+		return &literalNode{value: t.value}, nil
+	case tokenIdent:
+		switch t.value {
+		case "true":
+			return &literalNode{value: true}, nil
+		case "false":
+			return &literalNode{value: false}, nil
+		case "null":
+			return &literalNode{value: nil}, nil
+		}
+		if p.accept("(") {
+			return p.parseCall(t)
+		}
+		if !isVariable(t.value) {
+			return nil, errors.New("未定义的变量：" + t.value)
+		}
+		return &variableNode{name: t.value}, nil
+	case tokenOperator:
+		switch t.value {
+		case "(":
+			n, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			return n, p.expect(")")
+		case "[":
+			list := &listNode{}
+			if p.accept("]") {
+				return list, nil
+			}
+			for {
+				item, err := p.parseOr()
+				if err != nil {
+					return nil, err
+				}
+				list.items = append(list.items, item)
+				if p.accept("]") {
+					return list, nil
+				}
+				if err = p.expect(","); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return nil, unexpected(t)
+}
+
+// 解析函数调用的参数，并检查函数名称和参数数量
+func (p *parser) parseCall(name token) (node, error) {
+	fn, exists := functions[name.value]
+	if !exists {
+		return nil, errors.New("未定义的函数：" + name.value)
+	}
+	call := &callNode{name: name.value, fn: fn.call}
+	if !p.accept(")") {
+		for {
+			arg, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			call.args = append(call.args, arg)
+			if p.accept(")") {
+				break
+			}
+			if err = p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(call.args) != fn.args {
+		return nil, errors.New("函数" + name.value + "需要" + strconv.Itoa(fn.args) + "个参数")
+	}
+	// 参数为常量时在编译时求值，例如cidr("10.0.0.0/8")和matches(x, "^a")中的正则
+	return precompute(call)
+}
+
+// 预先解析函数的常量参数
+func precompute(call *callNode) (node, error) {
+	switch call.name {
+	case "cidr":
+		if arg, ok := call.args[0].(*literalNode); ok {
+			s, ok := arg.value.(string)
+			if !ok {
+				return nil, errors.New("cidr的参数必须是字符串")
+			}
+			_, ipNet, err := net.ParseCIDR(s)
+			if err != nil {
+				return nil, errors.New("无效的CIDR：" + s)
+			}
+			return &literalNode{value: ipNet}, nil
+		}
+	case "matches":
+		if arg, ok := call.args[1].(*literalNode); ok {
+			s, ok := arg.value.(string)
+			if !ok {
+				return nil, errors.New("matches的第2个参数必须是字符串")
+			}
+			re, err := regexp.Compile(s)
+			if err != nil {
+				return nil, errors.New("无效的正则表达式：" + s)
+			}
+			call.args[1] = &literalNode{value: re}
+		}
+	}
+	return call, nil
+}
@@ -0,0 +1,63 @@
+package policy
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tokens, err := tokenize(`claims.level>=3 && 'a\'b' != "c\n"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []token{
+		{tokenIdent, "claims", 0},
+		{tokenOperator, ".", 6},
+		{tokenIdent, "level", 7},
+		{tokenOperator, ">=", 12},
+		{tokenNumber, "3", 14},
+		{tokenOperator, "&&", 16},
+		{tokenString, "a'b", 19},
+		{tokenOperator, "!=", 26},
+		{tokenString, "c\n", 29},
+		{tokenEOF, "", 34},
+	}
+	if !reflect.DeepEqual(tokens, expected) {
+		t.Fatalf("词法单元无效：%v", tokens)
+	}
+}
+
+// 解析错误包含出错的位置
+func TestParseError(t *testing.T) {
+	cases := []struct {
+		expr string
+		err  string
+	}{
+		{`claims.sub == "a" )`, "无效的表达式：)，位置18"},
+		{`claims.sub # 1`, "无效的字符：#，位置11"},
+		{`claims.sub == "abc`, "字符串没有结束，位置14"},
+		{`(true || false`, "缺少)，位置14"},
+		{`[1, 2`, "缺少,，位置5"},
+		{`claims.1 == 1`, "无效的字段名，位置7"},
+		{`claims["a" == 1`, "缺少]，位置15"},
+		{`claims.sub ==`, "表达式不完整"},
+		{`&& true`, "无效的表达式：&&，位置0"},
+		{`1.2.3 == 1`, "无效的数字：1.2.3"},
+		{`foo == 1`, "未定义的变量：foo"},
+		{`foo(1)`, "未定义的函数：foo"},
+		{`size(1, 2)`, "函数size需要1个参数"},
+		{`request.ip in cidr("10.0.0.0")`, "无效的CIDR：10.0.0.0"},
+		{`request.ip in cidr(1)`, "cidr的参数必须是字符串"},
+		{`matches(claims.sub, "[")`, "无效的正则表达式：["},
+		{`matches(claims.sub, 1)`, "matches的第2个参数必须是字符串"},
+	}
+	for _, c := range cases {
+		_, err := Compile(c.expr)
+		if err == nil || err.Error() != c.err {
+			t.Errorf("%s：期望%q，实际%v", c.expr, c.err, err)
+		}
+	}
+	if _, err := Compile(""); err == nil {
+		t.Error("空的表达式应编译失败")
+	}
+}
@@ -0,0 +1,127 @@
+package policy
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"local/global"
+)
+
+// 策略表达式，语法类似CEL的子集，例如：
+// claims.aud == "billing" && request.ip in cidr("10.0.0.0/8")
+// 支持的运算符：|| && ! == != < <= > >= in，以及字段访问(.和[])和列表([a, b])
+// 支持的函数：cidr、size、startsWith、endsWith、contains、matches
+// 可用的变量：claims(授权的claims)和request(验证请求的上下文)
+
+// 编译后的策略程序
+type Program struct {
+	root node
+}
+
+// 编译策略表达式
+func Compile(expr string) (*Program, error) {
+	if expr == "" {
+		return nil, errors.New("策略表达式不能为空")
+	}
+	root, err := parse(expr)
+	if err != nil {
+		return nil, err
+	}
+	return &Program{root: root}, nil
+}
+
+// 对变量求值，结果不是布尔值时返回错误
+func (receiver *Program) Eval(vars map[string]interface{}) (bool, error) {
+	return evalBool(receiver.root, vars)
+}
+
+// 编译规则的所有策略
+func Build(policies []global.Policy) (err error) {
+	for k := range policies {
+		if policies[k].Program, err = Compile(policies[k].Expression); err != nil {
+			return errors.New("策略" + policyName(policies[k], k) + "编译失败：" + err.Error())
+		}
+	}
+	return nil
+}
+
+// 策略的名称，未设置名称时使用序号
+func policyName(p global.Policy, index int) string {
+	if p.Name != "" {
+		return p.Name
+	}
+	return "#" + strconv.Itoa(index)
+}
+
+// 验证请求的上下文
+type Request struct {
+	IP       string    // 请求方的ip
+	Aud      string    // 请求的受众
+	Method   string    // 请求的方法
+	Resource string    // 请求的资源
+	Time     time.Time // 请求的时间
+}
+
+// 可以使用的变量
+func isVariable(name string) bool {
+	return name == "claims" || name == "request"
+}
+
+// 根据授权的claims和请求的上下文生成变量
+func Vars(claims global.AuthorizerClaims, req Request) map[string]interface{} {
+	return map[string]interface{}{
+		"claims":  ClaimsVar(claims),
+		"request": RequestVar(req),
+	}
+}
+
+// 将授权的claims转为变量，自定义claims和标准claims在同一层级，数字统一为float64
+func ClaimsVar(claims global.AuthorizerClaims) map[string]interface{} {
+	result := make(map[string]interface{}, len(claims.Claims)+10)
+	for k, v := range claims.Claims {
+		result[k] = v
+	}
+	result["iss"] = claims.Issuer
+	result["sub"] = claims.Subject
+	result["aud"] = claims.Aud
+	result["jti"] = claims.ID
+	result["payload"] = claims.Payload
+	result["ip"] = claims.IP
+	result["scope"] = claims.Scope
+	result["exp"] = float64(claims.Expires)
+	result["iat"] = float64(claims.IssuedAt)
+	result["nbf"] = float64(claims.NotBefore)
+	return result
+}
+
+// 将请求的上下文转为变量，时间使用服务端的时区
+func RequestVar(req Request) map[string]interface{} {
+	return map[string]interface{}{
+		"ip":       req.IP,
+		"aud":      req.Aud,
+		"method":   req.Method,
+		"resource": req.Resource,
+		"time":     float64(req.Time.Unix()),
+		"hour":     float64(req.Time.Hour()),
+		"minute":   float64(req.Time.Minute()),
+		"weekday":  float64(req.Time.Weekday()),
+	}
+}
+
+// 依次对所有策略求值，返回第一个不通过的策略的名称，求值出错时视为不通过
+func Check(policies []global.Policy, vars map[string]interface{}) (string, error) {
+	for k := range policies {
+		if policies[k].Program == nil {
+			return policyName(policies[k], k), errors.New("策略未编译")
+		}
+		passed, err := policies[k].Program.Eval(vars)
+		if err != nil {
+			return policyName(policies[k], k), err
+		}
+		if !passed {
+			return policyName(policies[k], k), nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,51 @@
+package policy
+
+import (
+	"testing"
+
+	"local/global"
+)
+
+func TestCheck(t *testing.T) {
+	policies := []global.Policy{
+		{Name: "admin", Expression: `claims.admin == true`},
+		{Expression: `request.ip in cidr("10.0.0.0/8")`},
+		{Name: "level", Expression: `claims.level > 1`},
+	}
+	if err := Build(policies); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name   string
+		ip     string
+		failed string
+		err    bool
+	}{
+		{"全部通过", "10.1.2.3", "", false},
+		{"未命名的策略不通过", "192.168.1.1", "#1", false},
+		{"没有ip", "", "#1", false},
+	}
+	for _, c := range cases {
+		failed, err := Check(policies, testVars(c.ip))
+		if failed != c.failed || (err != nil) != c.err {
+			t.Errorf("%s：期望%q %v，实际%q %v", c.name, c.failed, c.err, failed, err)
+		}
+	}
+
+	// 求值出错时视为不通过
+	policies = []global.Policy{{Name: "typed", Expression: `claims.sub > 1`}}
+	if err := Build(policies); err != nil {
+		t.Fatal(err)
+	}
+	if failed, err := Check(policies, testVars("")); failed != "typed" || err == nil {
+		t.Errorf("求值出错时应不通过：%q %v", failed, err)
+	}
+	// 未编译的策略不通过
+	if failed, err := Check([]global.Policy{{Name: "raw", Expression: `true`}}, testVars("")); failed != "raw" || err == nil {
+		t.Errorf("未编译的策略应不通过：%q %v", failed, err)
+	}
+	// 编译失败时返回策略的名称
+	if err := Build([]global.Policy{{Expression: `true`}, {Expression: `claims.sub ==`}}); err == nil || err.Error() != "策略#1编译失败：表达式不完整" {
+		t.Errorf("编译失败的错误无效：%v", err)
+	}
+}
@@ -4,12 +4,14 @@ import (
 	"errors"
 	"time"
 
+	"local/claimset"
 	"local/global"
-
-	"github.com/dxvgef/gommon/encrypt"
+	"local/keyutil"
+	"local/policy"
 
 	"github.com/dxvgef/filter/v2"
 	"github.com/dxvgef/tsing"
+	"github.com/rs/zerolog/log"
 )
 
 // 授权管理
@@ -18,15 +20,24 @@ type Auth struct{}
 // 签发授权
 func (self *Auth) Sign(ctx *tsing.Context) error {
 	var (
-		err                                  error
-		resp                                 = make(map[string]string)
-		name                                 string
-		payload                              string
-		tokenStr, refreshTokenStr, tokenHash string
+		err                       error
+		resp                      = make(map[string]string)
+		name                      string
+		bindIP                    bool
+		params                    global.SignParams
+		claimsStr, scopeStr       string
+		tokenStr, refreshTokenStr string
 	)
 	if err = filter.Batch(
 		filter.String(ctx.Post("name"), "name").Require().Set(&name),
-		filter.String(ctx.Post("payload"), "payload").Set(&payload),
+		filter.String(ctx.Post("payload"), "payload").Set(&params.Payload),
+		filter.String(ctx.Post("aud"), "aud").Set(&params.Aud),
+		filter.String(ctx.Post("ip"), "ip").IsIP().Set(&params.IP),
+		filter.String(ctx.Post("bind_ip"), "bind_ip").IsBool().Set(&bindIP),
+		filter.String(ctx.Post("sub"), "sub").Set(&params.Subject),
+		filter.String(ctx.Post("nbf"), "nbf").IsDigit().Set(&params.NotBefore),
+		filter.String(ctx.Post("claims"), "claims").IsJSON().Set(&claimsStr),
+		filter.String(ctx.Post("scope"), "scope").Set(&scopeStr),
 	); err != nil {
 		resp["error"] = err.Error()
 		return JSON(ctx, 400, &resp)
@@ -42,23 +53,41 @@ func (self *Auth) Sign(ctx *tsing.Context) error {
 		return errors.New("规则类型断言失败")
 	}
 
+	// 未明确传入ip时，绑定请求方的ip
+	if bindIP && params.IP == "" {
+		params.IP = clientIP(ctx)
+	}
+	// 合并规则的默认claims和传入的claims，传入的claims优先
+	customClaims, err := claimset.Parse(claimsStr)
+	if err != nil {
+		resp["error"] = err.Error()
+		return JSON(ctx, 400, &resp)
+	}
+	params.Claims = claimset.Combine(rule.Claims, customClaims)
+	// 只能签发规则允许的scope
+	if params.Scope, err = checkScopes(rule.Scopes, parseScopes(scopeStr)); err != nil {
+		resp["error"] = err.Error()
+		return JSON(ctx, 400, &resp)
+	}
+	params.Issuer = rule.Issuer
+	// 授权不能晚于会话结束时过期
+	params.Expires = sessionExpires(rule, time.Now().Unix())
+	if params.ID, err = newTokenID(); err != nil {
+		log.Err(err).Caller().Send()
+		return err
+	}
+
 	// 使用规则的授权器实例生成access token
-	tokenStr, err = rule.Authorizer.Instance.Sign(global.SignParams{})
+	tokenStr, err = rule.Authorizer.Instance.Sign(params)
 	if err != nil {
 		resp["error"] = "签发授权失败：" + err.Error()
 		return JSON(ctx, 400, &resp)
 	}
 	resp["token"] = tokenStr
 
-	// 使用规则的更新器实例生成refresh token
+	// 使用规则的更新器实例生成refresh token，并创建新的刷新授权族
 	if rule.Updater.Type != "" {
-		// 计算access token的hash
-		tokenHash, err = encrypt.MD5ByStr(tokenStr)
-		if err != nil {
-			resp["error"] = "Token Hash计算失败：" + err.Error()
-			return JSON(ctx, 400, &resp)
-		}
-		refreshTokenStr, err = rule.Updater.Instance.Sign(tokenHash)
+		refreshTokenStr, err = signRefreshToken(rule, tokenStr, refreshRecord{Token: newTokenData(params)})
 		if err != nil {
 			resp["error"] = "签发刷新授权失败：" + err.Error()
 			return JSON(ctx, 400, &resp)
@@ -71,16 +100,24 @@ func (self *Auth) Sign(ctx *tsing.Context) error {
 // 验证授权
 func (self *Auth) Verity(ctx *tsing.Context) error {
 	var (
-		err  error
-		resp = make(map[string]interface{})
-		name string
-		// scopes   []string
+		err      error
+		resp     = make(map[string]interface{})
+		name     string
+		scopeStr string
 		tokenStr string
+		aud      string
+		ip       string
+		method   string
+		resource string
 	)
 	if err = filter.Batch(
 		filter.String(ctx.Query("name"), "name").Require().Set(&name),
-		// filter.String(ctx.Query("scope"), "scope").SetSlice(&scopes, ","),
+		filter.String(ctx.Query("scope"), "scope").Set(&scopeStr),
 		filter.String(ctx.Query("token"), "token").Require().Set(&tokenStr),
+		filter.String(ctx.Query("aud"), "aud").Set(&aud),
+		filter.String(ctx.Query("ip"), "ip").IsIP().Set(&ip),
+		filter.String(ctx.Query("method"), "method").Set(&method),
+		filter.String(ctx.Query("resource"), "resource").Set(&resource),
 	); err != nil {
 		resp["error"] = err.Error()
 		return JSON(ctx, 400, &resp)
@@ -97,28 +134,49 @@ func (self *Auth) Verity(ctx *tsing.Context) error {
 	}
 
 	// 验证token
-	claims, valid := rule.Authorizer.Instance.VeritySign(tokenStr)
-	if !valid {
-		resp["error"] = "签名验证失败"
-		return JSON(ctx, 400, &resp)
+	claims, err := verifyAccessToken(rule, tokenStr)
+	if err != nil {
+		return TokenError(ctx, rule.Name, "", err)
 	}
-	if claims.Expires != 0 && claims.Expires <= time.Now().Unix() {
-		resp["error"] = "授权已过期"
-		return JSON(ctx, 400, &resp)
+	// 校验受众和绑定的ip
+	if err = checkBinding(ctx, claims, aud, ip); err != nil {
+		return TokenError(ctx, rule.Name, "", err)
+	}
+	// 授权必须包含所有要求的scope
+	if !hasScopes(claims.Scope, parseScopes(scopeStr)) {
+		return TokenError(ctx, rule.Name, "", global.ErrScopeInsufficient)
+	}
+	// 对规则的策略求值
+	if err = checkPolicies(ctx, rule, claims, policy.Request{IP: ip, Aud: aud, Method: method, Resource: resource}); err != nil {
+		return TokenError(ctx, rule.Name, "", err)
 	}
 
+	resp["expires"] = claims.Expires
+	resp["payload"] = claims.Payload
+	resp["aud"] = claims.Aud
+	resp["ip"] = claims.IP
+	resp["iss"] = claims.Issuer
+	resp["sub"] = claims.Subject
+	resp["iat"] = claims.IssuedAt
+	resp["nbf"] = claims.NotBefore
+	resp["jti"] = claims.ID
+	resp["scope"] = claims.Scope
+	if claims.Claims == nil {
+		claims.Claims = make(map[string]interface{})
+	}
+	resp["claims"] = claims.Claims
 	return JSON(ctx, 200, &resp)
 }
 
 // 刷新授权
 func (self *Auth) Refresh(ctx *tsing.Context) error {
 	var (
-		err                                                                   error
-		resp                                                                  = make(map[string]string)
-		name                                                                  string
-		tokenStr, refreshTokenStr, newTokenStr, newRefreshTokenStr, tokenHash string
-		valid                                                                 bool
-		refreshClaims                                                         global.UpdaterClaims
+		err                                                        error
+		resp                                                       = make(map[string]string)
+		name                                                       string
+		tokenStr, refreshTokenStr, newTokenStr, newRefreshTokenStr string
+		refreshClaims                                              global.UpdaterClaims
+		record                                                     refreshRecord
 	)
 	if err = filter.Batch(
 		filter.String(ctx.Post("name"), "name").Require().Set(&name),
@@ -138,47 +196,166 @@ func (self *Auth) Refresh(ctx *tsing.Context) error {
 	if !ok {
 		return errors.New("规则类型断言失败")
 	}
-
-	// 验证签名并获得claims
-	_, valid = rule.Authorizer.Instance.VeritySign(tokenStr)
-	if !valid {
-		resp["error"] = "授权签名无效"
+	if rule.Updater.Type == "" {
+		resp["error"] = "规则未配置更新器"
 		return JSON(ctx, 400, &resp)
 	}
+
+	// 授权通常在过期后才刷新，只校验签名，不校验有效期
+	claims, err := rule.Authorizer.Instance.VeritySign(tokenStr)
+	if err != nil && err != global.ErrTokenExpired {
+		return TokenError(ctx, rule.Name, "", err)
+	}
 	// 验证签名并获得刷新token的claims
-	refreshClaims, valid = rule.Updater.Instance.VeritySign(refreshTokenStr)
-	if !valid {
-		resp["error"] = "刷新授权签名无效"
+	if refreshClaims, err = verifyRefreshToken(rule, refreshTokenStr); err != nil {
+		return TokenError(ctx, rule.Name, "刷新授权：", err)
+	}
+	// 刷新授权必须绑定到传入的授权
+	if !refreshTokenBound(refreshClaims, tokenStr) {
+		resp["error"] = "刷新授权与授权不匹配"
 		return JSON(ctx, 400, &resp)
 	}
-	if refreshClaims.Expires != 0 && refreshClaims.Expires <= time.Now().Unix() {
-		resp["error"] = "刷新授权已过期"
+	// 先读取刷新授权的数据，所有校验通过后才使用，避免刷新失败时刷新授权失效
+	if record, err = loadRefreshToken(refreshTokenStr); err != nil {
+		if err == errRefreshTokenUnknown || err == errRefreshTokenReused {
+			resp["error"] = err.Error()
+			return JSON(ctx, 400, &resp)
+		}
+		resp["error"] = err.Error()
+		return JSON(ctx, 500, &resp)
+	}
+	if global.IsRevoked(record.Family) {
+		return TokenError(ctx, rule.Name, "刷新授权：", global.ErrTokenRevoked)
+	}
+	// 优先使用刷新授权中保存的授权数据
+	if record.Token != nil {
+		claims = tokenClaims(record.Token)
+	}
+	if global.IsRevoked(revocationID(tokenStr, claims.ID)) {
+		return TokenError(ctx, rule.Name, "", global.ErrTokenRevoked)
+	}
+	// 刷新授权不能延长会话
+	if err = checkSession(rule, record); err != nil {
+		resp["error"] = err.Error()
 		return JSON(ctx, 400, &resp)
 	}
 
-	// 签发新的token
-	newTokenStr, err = rule.Authorizer.Instance.Sign(global.SignParams{})
+	// 沿用原授权的claims签发新的token
+	params := global.SignParams{
+		Expires: sessionExpires(rule, record.Created),
+		Payload: claims.Payload,
+		Aud:     claims.Aud,
+		IP:      claims.IP,
+		Issuer:  rule.Issuer,
+		Subject: claims.Subject,
+		Scope:   allowedScopes(rule.Scopes, claims.Scope),
+		Claims:  claimset.Combine(rule.Claims, claims.Claims),
+	}
+	// 通过刷新钩子获取最新的claims
+	if rule.RefreshHook != "" {
+		if err = callRefreshHook(rule, &params); err != nil {
+			if err == errRefreshHookDenied {
+				resp["error"] = err.Error()
+				return JSON(ctx, 400, &resp)
+			}
+			resp["error"] = "调用刷新钩子失败：" + err.Error()
+			return JSON(ctx, 500, &resp)
+		}
+	}
+	// 刷新授权只能使用一次，并发刷新时只有第一个使用的请求能签发新的授权
+	if err = useRefreshToken(refreshTokenStr); err != nil {
+		resp["error"] = err.Error()
+		if err == errRefreshTokenConflict {
+			return JSON(ctx, 409, &resp)
+		}
+		if err == errRefreshTokenUnknown {
+			return JSON(ctx, 400, &resp)
+		}
+		return JSON(ctx, 500, &resp)
+	}
+	if params.ID, err = newTokenID(); err != nil {
+		log.Err(err).Caller().Send()
+		return err
+	}
+	newTokenStr, err = rule.Authorizer.Instance.Sign(params)
 	if err != nil {
 		resp["error"] = "签发授权失败：" + err.Error()
 		return JSON(ctx, 400, &resp)
 	}
 	resp["token"] = newTokenStr
 
-	// 签发新的refresh token
-	if rule.Updater.Type != "" {
-		// 计算access token的hash
-		tokenHash, err = encrypt.MD5ByStr(tokenStr)
-		if err != nil {
-			resp["error"] = "Token Hash计算失败：" + err.Error()
-			return JSON(ctx, 400, &resp)
+	// 签发绑定到新token的refresh token，属于同一个刷新授权族
+	record.Count++
+	record.Token = newTokenData(params)
+	newRefreshTokenStr, err = signRefreshToken(rule, newTokenStr, record)
+	if err != nil {
+		resp["error"] = "签发刷新授权失败：" + err.Error()
+		return JSON(ctx, 400, &resp)
+	}
+	resp["refresh_token"] = newRefreshTokenStr
+
+	return JSON(ctx, 200, &resp)
+}
+
+// 验证授权的签名、吊销状态、有效期和签发者
+func verifyAccessToken(rule global.Rule, tokenStr string) (global.AuthorizerClaims, error) {
+	claims, err := rule.Authorizer.Instance.VeritySign(tokenStr)
+	if err != nil {
+		return claims, err
+	}
+	// ID Token只用于向客户端证明用户的身份，不能做为授权使用
+	if isIDToken(claims) {
+		return claims, global.ErrTokenUseMismatch
+	}
+	if global.IsRevoked(revocationID(tokenStr, claims.ID)) {
+		return claims, global.ErrTokenRevoked
+	}
+	now := time.Now().Unix()
+	if claims.Expires != 0 && claims.Expires <= now {
+		return claims, global.ErrTokenExpired
+	}
+	if claims.NotBefore != 0 && claims.NotBefore > now {
+		return claims, global.ErrTokenNotYetValid
+	}
+	// 规则设置了签发者时校验签发者
+	if rule.Issuer != "" && claims.Issuer != rule.Issuer {
+		return claims, global.ErrIssuerMismatch
+	}
+	return claims, nil
+}
+
+// 校验授权的受众和绑定的ip，未传入ip时使用请求方的ip
+func checkBinding(ctx *tsing.Context, claims global.AuthorizerClaims, aud, ip string) error {
+	if claims.Aud != "" && claims.Aud != aud {
+		return global.ErrAudienceMismatch
+	}
+	if claims.IP != "" {
+		if ip == "" {
+			ip = clientIP(ctx)
 		}
-		newRefreshTokenStr, err = rule.Updater.Instance.Sign(tokenHash)
-		if err != nil {
-			resp["error"] = "签发刷新授权失败：" + err.Error()
-			return JSON(ctx, 400, &resp)
+		if !equalIP(claims.IP, ip) {
+			return global.ErrIPMismatch
 		}
-		resp["refresh_token"] = newRefreshTokenStr
 	}
+	return nil
+}
 
-	return JSON(ctx, 200, &resp)
+// 验证刷新授权的签名、有效期和吊销状态
+func verifyRefreshToken(rule global.Rule, refreshTokenStr string) (global.UpdaterClaims, error) {
+	refreshClaims, err := rule.Updater.Instance.VeritySign(refreshTokenStr)
+	if err != nil {
+		return refreshClaims, err
+	}
+	if refreshClaims.Expires != 0 && refreshClaims.Expires <= time.Now().Unix() {
+		return refreshClaims, global.ErrTokenExpired
+	}
+	if global.IsRevoked(revocationID(refreshTokenStr, "")) {
+		return refreshClaims, global.ErrTokenRevoked
+	}
+	return refreshClaims, nil
+}
+
+// 生成授权的唯一标识(jti)
+func newTokenID() (string, error) {
+	return keyutil.RandomBase64(16)
 }
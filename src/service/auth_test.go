@@ -0,0 +1,78 @@
+package service
+
+import (
+	"encoding/json"
+	"net/url"
+	"testing"
+	"time"
+
+	"local/authorizer/opaque"
+	"local/global"
+)
+
+// 修改不透明授权在存储器中的过期时间，使其过期
+func expireOpaqueToken(t *testing.T, storage *memStorage, tokenStr string) {
+	t.Helper()
+	hash := opaque.Hash(tokenStr)
+	claims := make(map[string]interface{})
+	if err := json.Unmarshal(storage.tokens[hash], &claims); err != nil {
+		t.Fatal(err)
+	}
+	claims["exp"] = time.Now().Unix() - 1
+	data, err := json.Marshal(&claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = storage.SaveToken(hash, data, 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 小写的类型名称与大写一致，已过期的不透明授权返回token_expired并且可以刷新和吊销
+func TestOpaqueToken(t *testing.T) {
+	storage := setupTest(t)
+	addRule(t, url.Values{
+		"name":       {"opaque"},
+		"authorizer": {`{"type":"opaque","config":"{\"expires\":60}"}`},
+		"updater":    {`{"type":"jwt_hs256","config":"{\"expires\":600,\"secret\":\"123456\"}"}`},
+	})
+	rule, _ := loadRule("opaque")
+	if rule.Authorizer.Type != "OPAQUE" || rule.Updater.Type != "JWT_HS256" {
+		t.Fatalf("类型名称没有转为大写：%s %s", rule.Authorizer.Type, rule.Updater.Type)
+	}
+	resp := signToken(t, url.Values{"name": {"opaque"}, "sub": {"bob"}})
+	tokenStr, refreshTokenStr := resp.String("token"), resp.String("refresh_token")
+
+	expireOpaqueToken(t, storage, tokenStr)
+	if resp = request(t, "GET", "/auth", url.Values{"name": {"opaque"}, "token": {tokenStr}}, nil); resp.Code != 401 || resp.String("code") != "token_expired" {
+		t.Fatalf("已过期的不透明授权应返回token_expired：%d %v", resp.Code, resp.Body)
+	}
+	// 绑定的授权不存在时不能刷新
+	if resp = request(t, "PUT", "/auth", url.Values{"name": {"opaque"}, "token": {"abc"}, "refresh_token": {refreshTokenStr}}, nil); resp.Code != 401 || resp.String("code") != "signature_invalid" {
+		t.Fatalf("不存在的授权不能刷新：%d %v", resp.Code, resp.Body)
+	}
+	resp = request(t, "PUT", "/auth", url.Values{"name": {"opaque"}, "token": {tokenStr}, "refresh_token": {refreshTokenStr}}, nil)
+	if resp.Code != 200 {
+		t.Fatalf("刷新已过期的不透明授权失败：%d %v", resp.Code, resp.Body)
+	}
+	newTokenStr := resp.String("token")
+	if resp = request(t, "GET", "/auth", url.Values{"name": {"opaque"}, "token": {newTokenStr}}, nil); resp.Code != 200 || resp.String("sub") != "bob" {
+		t.Fatalf("刷新得到的授权无效：%d %v", resp.Code, resp.Body)
+	}
+
+	// 吊销时从存储器中删除数据，已过期的授权也一样
+	for _, token := range []string{tokenStr, newTokenStr} {
+		if resp = request(t, "DELETE", "/auth", url.Values{"name": {"opaque"}, "token": {token}}, nil); resp.Code != 204 {
+			t.Fatalf("吊销不透明授权失败：%d %v", resp.Code, resp.Body)
+		}
+		if _, exists := storage.tokens[opaque.Hash(token)]; exists {
+			t.Fatal("吊销的不透明授权没有从存储器中删除")
+		}
+		if _, exists := global.Tokens.Load(opaque.Hash(token)); exists {
+			t.Fatal("吊销的不透明授权没有从本地删除")
+		}
+	}
+	if resp = request(t, "GET", "/auth", url.Values{"name": {"opaque"}, "token": {newTokenStr}}, nil); resp.Code != 401 {
+		t.Fatalf("吊销的不透明授权应验证失败：%d %v", resp.Code, resp.Body)
+	}
+}
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"local/global"
+	"local/policy"
+
+	"github.com/dxvgef/filter/v2"
+	"github.com/dxvgef/tsing"
+)
+
+// 验证授权并检查授权的主体是否拥有权限，未传入规则名称时根据token的kid或iss查找规则
+func (self *Auth) Authorize(ctx *tsing.Context) error {
+	var (
+		err        error
+		resp       = make(map[string]string)
+		name       string
+		tokenStr   string
+		permission string
+		aud        string
+		ip         string
+		method     string
+		resource   string
+		rule       global.Rule
+		claims     global.AuthorizerClaims
+	)
+	if err = filter.Batch(
+		filter.String(ctx.Query("name"), "name").Set(&name),
+		filter.String(ctx.Query("token"), "token").Require().Set(&tokenStr),
+		filter.String(ctx.Query("permission"), "permission").Require().Set(&permission),
+		filter.String(ctx.Query("aud"), "aud").Set(&aud),
+		filter.String(ctx.Query("ip"), "ip").IsIP().Set(&ip),
+		filter.String(ctx.Query("method"), "method").Set(&method),
+		filter.String(ctx.Query("resource"), "resource").Set(&resource),
+	); err != nil {
+		resp["error"] = err.Error()
+		return JSON(ctx, 400, &resp)
+	}
+	rules := introspectRules(name, tokenStr)
+	if len(rules) == 0 {
+		if name != "" {
+			resp["error"] = "规则不存在"
+		} else {
+			resp["error"] = "无法根据token查找规则，请传入规则名称"
+		}
+		return JSON(ctx, 400, &resp)
+	}
+
+	// 验证token
+	for k := range rules {
+		rule = rules[k]
+		if claims, err = verifyAccessToken(rule, tokenStr); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return TokenError(ctx, rule.Name, "", err)
+	}
+	if err = checkBinding(ctx, claims, aud, ip); err != nil {
+		return TokenError(ctx, rule.Name, "", err)
+	}
+	if err = checkPolicies(ctx, rule, claims, policy.Request{IP: ip, Aud: aud, Method: method, Resource: resource}); err != nil {
+		return TokenError(ctx, rule.Name, "", err)
+	}
+
+	// 根据主体在规则下的角色检查权限
+	subject, _ := tokenSubject(rule, claims)
+	role, granted := subjectGranted(subject, permission)
+	if claims.Subject == "" || !granted {
+		return TokenError(ctx, rule.Name, "", global.ErrPermissionDenied)
+	}
+	result := map[string]interface{}{
+		"rule":       rule.Name,
+		"sub":        claims.Subject,
+		"permission": permission,
+		"role":       role,
+		"roles":      subject.Roles,
+	}
+	return JSON(ctx, 200, &result)
+}
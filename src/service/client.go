@@ -0,0 +1,265 @@
+package service
+
+import (
+	"errors"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"local/global"
+	"local/keyutil"
+
+	"github.com/dxvgef/filter/v2"
+	"github.com/dxvgef/tsing"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// OAuth客户端管理
+type Client struct{}
+
+// 输出的客户端信息，不包含client_secret的hash
+type clientInfo struct {
+	ID           string   `json:"client_id"`
+	Name         string   `json:"name,omitempty"`
+	Public       bool     `json:"public,omitempty"`
+	Rules        []string `json:"rules"`
+	Scopes       []string `json:"scopes,omitempty"`
+	RedirectURIs []string `json:"redirect_uris,omitempty"`
+	Created      int64    `json:"created"`
+}
+
+// 列出所有客户端
+func (self *Client) List(ctx *tsing.Context) error {
+	list := make([]clientInfo, 0, global.SyncMapLen(&global.Clients))
+	global.Clients.Range(func(_, value interface{}) bool {
+		if client, ok := value.(global.Client); ok {
+			list = append(list, clientInfo{
+				ID:           client.ID,
+				Name:         client.Name,
+				Public:       client.Public,
+				Rules:        client.Rules,
+				Scopes:       client.Scopes,
+				RedirectURIs: client.RedirectURIs,
+				Created:      client.Created,
+			})
+		}
+		return true
+	})
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID < list[j].ID
+	})
+	return JSON(ctx, 200, &list)
+}
+
+// 添加客户端，未传入client_id时自动生成，client_secret只在此时返回一次，公开客户端没有client_secret
+func (self *Client) Add(ctx *tsing.Context) error {
+	var (
+		err          error
+		resp         = make(map[string]string)
+		client       global.Client
+		rules        string
+		scopes       string
+		redirectURIs string
+		clientSecret string
+	)
+	if err = filter.Batch(
+		filter.String(ctx.Post("client_id"), "client_id").Set(&client.ID),
+		filter.String(ctx.Post("name"), "name").Set(&client.Name),
+		filter.String(ctx.Post("public"), "public").IsBool().Set(&client.Public),
+		filter.String(ctx.Post("rules"), "rules").Require().Set(&rules),
+		filter.String(ctx.Post("scopes"), "scopes").Set(&scopes),
+		filter.String(ctx.Post("redirect_uris"), "redirect_uris").Set(&redirectURIs),
+	); err != nil {
+		resp["error"] = err.Error()
+		return JSON(ctx, 400, &resp)
+	}
+	if client.ID == "" {
+		if client.ID, err = keyutil.RandomBase64(16); err != nil {
+			log.Err(err).Caller().Send()
+			return err
+		}
+	} else if _, exists := global.Clients.Load(client.ID); exists {
+		resp["error"] = "客户端已存在"
+		return JSON(ctx, 400, &resp)
+	}
+	if err = setClientGrants(&client, rules, scopes, redirectURIs); err != nil {
+		resp["error"] = err.Error()
+		return JSON(ctx, 400, &resp)
+	}
+	if !client.Public {
+		if clientSecret, err = setClientSecret(&client); err != nil {
+			log.Err(err).Caller().Send()
+			return err
+		}
+	}
+	client.Created = time.Now().Unix()
+	if err = global.StorageInstance.SaveClient(client); err != nil {
+		log.Err(err).Caller().Send()
+		resp["error"] = err.Error()
+		return JSON(ctx, 500, &resp)
+	}
+	resp["client_id"] = client.ID
+	if clientSecret != "" {
+		resp["client_secret"] = clientSecret
+	}
+	return JSON(ctx, 200, &resp)
+}
+
+// 更新客户端的名称、允许使用的规则、scope和重定向地址，不改变client_secret
+func (self *Client) Put(ctx *tsing.Context) error {
+	var (
+		err          error
+		resp         = make(map[string]string)
+		id           string
+		name         string
+		rules        string
+		scopes       string
+		redirectURIs string
+	)
+	if err = filter.Batch(
+		filter.String(ctx.PathParams.Value("id"), "id").Require().Base64RawURLDecode().Set(&id),
+		filter.String(ctx.Post("name"), "name").Set(&name),
+		filter.String(ctx.Post("rules"), "rules").Require().Set(&rules),
+		filter.String(ctx.Post("scopes"), "scopes").Set(&scopes),
+		filter.String(ctx.Post("redirect_uris"), "redirect_uris").Set(&redirectURIs),
+	); err != nil {
+		resp["error"] = err.Error()
+		return JSON(ctx, 400, &resp)
+	}
+	client, exists := loadClient(id)
+	if !exists {
+		resp["error"] = "客户端不存在"
+		return JSON(ctx, 400, &resp)
+	}
+	client.Name = name
+	if err = setClientGrants(&client, rules, scopes, redirectURIs); err != nil {
+		resp["error"] = err.Error()
+		return JSON(ctx, 400, &resp)
+	}
+	if err = global.StorageInstance.SaveClient(client); err != nil {
+		log.Err(err).Caller().Send()
+		resp["error"] = err.Error()
+		return JSON(ctx, 500, &resp)
+	}
+	return Status(ctx, 204)
+}
+
+// 重新生成客户端的client_secret，旧的client_secret立即失效
+func (self *Client) ResetSecret(ctx *tsing.Context) error {
+	var (
+		err          error
+		resp         = make(map[string]string)
+		id           string
+		clientSecret string
+	)
+	id, err = filter.String(ctx.PathParams.Value("id"), "id").Require().Base64RawURLDecode().String()
+	if err != nil {
+		resp["error"] = err.Error()
+		return JSON(ctx, 400, &resp)
+	}
+	client, exists := loadClient(id)
+	if !exists {
+		resp["error"] = "客户端不存在"
+		return JSON(ctx, 400, &resp)
+	}
+	if client.Public {
+		resp["error"] = "公开客户端没有client_secret"
+		return JSON(ctx, 400, &resp)
+	}
+	if clientSecret, err = setClientSecret(&client); err != nil {
+		log.Err(err).Caller().Send()
+		return err
+	}
+	if err = global.StorageInstance.SaveClient(client); err != nil {
+		log.Err(err).Caller().Send()
+		resp["error"] = err.Error()
+		return JSON(ctx, 500, &resp)
+	}
+	resp["client_id"] = client.ID
+	resp["client_secret"] = clientSecret
+	return JSON(ctx, 200, &resp)
+}
+
+// 删除客户端，已签发的授权不受影响
+func (self *Client) Delete(ctx *tsing.Context) error {
+	var (
+		err  error
+		resp = make(map[string]string)
+		id   string
+	)
+	id, err = filter.String(ctx.PathParams.Value("id"), "id").Require().Base64RawURLDecode().String()
+	if err != nil {
+		resp["error"] = err.Error()
+		return JSON(ctx, 400, &resp)
+	}
+	if _, exists := global.Clients.Load(id); !exists {
+		return Status(ctx, 204)
+	}
+	if err = global.StorageInstance.DeleteClient(id); err != nil {
+		log.Err(err).Caller().Send()
+		resp["error"] = err.Error()
+		return JSON(ctx, 500, &resp)
+	}
+	return Status(ctx, 204)
+}
+
+// 从本地加载客户端
+func loadClient(id string) (global.Client, bool) {
+	value, exists := global.Clients.Load(id)
+	if !exists {
+		return global.Client{}, false
+	}
+	client, ok := value.(global.Client)
+	return client, ok
+}
+
+// 设置客户端允许使用的规则、scope和重定向地址，规则必须已存在，公开客户端必须设置重定向地址
+func setClientGrants(client *global.Client, rules, scopes, redirectURIs string) (err error) {
+	client.Rules = parseNames(rules)
+	if len(client.Rules) == 0 {
+		return errors.New("rules不能为空")
+	}
+	for k := range client.Rules {
+		if _, exists := global.Rules.Load(client.Rules[k]); !exists {
+			return errors.New("规则不存在：" + client.Rules[k])
+		}
+	}
+	if client.Scopes, err = parseRuleScopes(scopes); err != nil {
+		return
+	}
+	client.RedirectURIs = parseNames(redirectURIs)
+	if client.Public && len(client.RedirectURIs) == 0 {
+		return errors.New("公开客户端的redirect_uris不能为空")
+	}
+	for k := range client.RedirectURIs {
+		if !isRedirectURI(client.RedirectURIs[k]) {
+			return errors.New("无效的重定向地址：" + client.RedirectURIs[k])
+		}
+	}
+	return
+}
+
+// 重定向地址必须是不包含fragment的绝对地址，允许移动应用的自定义scheme
+func isRedirectURI(value string) bool {
+	u, err := url.Parse(value)
+	if err != nil {
+		return false
+	}
+	return u.Scheme != "" && u.Fragment == "" && !strings.Contains(value, "#")
+}
+
+// 生成新的client_secret，客户端只保存其hash
+func setClientSecret(client *global.Client) (string, error) {
+	secret, err := keyutil.RandomBase64(32)
+	if err != nil {
+		return "", err
+	}
+	hash, err := bcrypt.GenerateFromPassword(global.StrToBytes(secret), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	client.SecretHash = global.BytesToStr(hash)
+	return secret, nil
+}
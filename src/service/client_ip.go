@@ -0,0 +1,35 @@
+package service
+
+import (
+	"net"
+	"strings"
+
+	"local/global"
+
+	"github.com/dxvgef/tsing"
+)
+
+// 获得客户端的IP
+// 只有配置了ip_header时才信任代理传递的头信息，否则使用连接的来源地址
+func clientIP(ctx *tsing.Context) string {
+	if global.Config.Service.IPHeader != "" {
+		if value := ctx.Request.Header.Get(global.Config.Service.IPHeader); value != "" {
+			// X-Forwarded-For可能包含多个地址，第一个是客户端的地址
+			return strings.TrimSpace(strings.Split(value, ",")[0])
+		}
+	}
+	ip, _, err := net.SplitHostPort(ctx.Request.RemoteAddr)
+	if err != nil {
+		return ctx.Request.RemoteAddr
+	}
+	return ip
+}
+
+// 比较两个IP是否相同
+func equalIP(a, b string) bool {
+	ipA := net.ParseIP(a)
+	if ipA == nil {
+		return a == b
+	}
+	return ipA.Equal(net.ParseIP(b))
+}
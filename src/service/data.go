@@ -1,6 +1,7 @@
 package service
 
 import (
+	"local/envelope"
 	"local/global"
 
 	"github.com/dxvgef/tsing"
@@ -44,9 +45,34 @@ func (*Data) SaveAll(ctx *tsing.Context) error {
 	return Status(ctx, 204)
 }
 
+// 重新加载主密钥，然后使用新的活动主密钥重新加密存储器中的所有规则，用于轮换主密钥
+func (*Data) ReEncrypt(ctx *tsing.Context) error {
+	resp := make(map[string]string)
+	if err := envelope.Reload(); err != nil {
+		log.Err(err).Caller().Send()
+		resp["error"] = "重新加载主密钥失败：" + err.Error()
+		return JSON(ctx, 500, &resp)
+	}
+	if err := saveAll(); err != nil {
+		log.Err(err).Caller().Send()
+		resp["error"] = err.Error()
+		return JSON(ctx, 500, &resp)
+	}
+	return Status(ctx, 204)
+}
+
 // 加载所有数据
 func loadAll() (err error) {
-	return global.StorageInstance.LoadAllRule()
+	if err = global.StorageInstance.LoadAllRule(); err != nil {
+		return
+	}
+	if err = global.StorageInstance.LoadAllRevoked(); err != nil {
+		return
+	}
+	if err = global.StorageInstance.LoadAllRBAC(); err != nil {
+		return
+	}
+	return global.StorageInstance.LoadAllClient()
 }
 
 // 保存所有数据
@@ -10,6 +10,7 @@ import (
 	"strconv"
 	"time"
 
+	"local/envelope"
 	"local/global"
 
 	"github.com/dxvgef/tsing"
@@ -38,13 +39,19 @@ func ConfigService() {
 		config tsing.Config
 	)
 
+	// 加载规则密钥配置的主密钥
+	if err = envelope.Load(global.Config.Encryption.Algorithm, global.Config.Encryption.KeyFile, global.Config.Encryption.KeyEnv); err != nil {
+		log.Fatal().Err(err).Caller().Msg("加载主密钥失败")
+		return
+	}
+
 	// 构建存储器
 	if global.StorageInstance, err = storage.Build(global.Config.Storage.Name, global.Config.Storage.Config); err != nil {
 		log.Fatal().Err(err).Caller().Msg("构建存储器实例失败")
 		return
 	}
-	// 从存储器中加载所有规则
-	if err = global.StorageInstance.LoadAllRule(); err != nil {
+	// 从存储器中加载规则、吊销列表、RBAC数据和OAuth客户端
+	if err = loadAll(); err != nil {
 		log.Fatal().Err(err).Caller().Msg("从存储器加载数据失败")
 		return
 	}
@@ -138,6 +145,9 @@ func Start() {
 		}
 	}()
 
+	// 定时轮换密钥
+	go startRotation()
+
 	// 监听进程退出信号
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt)
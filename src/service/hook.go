@@ -0,0 +1,144 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"local/claimset"
+	"local/global"
+
+	"github.com/dxvgef/tsing"
+	"github.com/rs/zerolog/log"
+)
+
+var errRefreshHookDenied = errors.New("刷新钩子拒绝刷新授权")
+
+var hookClient = &http.Client{Timeout: 5 * time.Second}
+
+// 刷新钩子的请求和响应数据
+type refreshHookData struct {
+	Name    string                 `json:"name,omitempty"`
+	Subject string                 `json:"sub,omitempty"`
+	Aud     string                 `json:"aud,omitempty"`
+	Payload string                 `json:"payload,omitempty"`
+	Claims  map[string]interface{} `json:"claims,omitempty"`
+}
+
+// 调用规则的刷新钩子获取最新的claims
+// 钩子返回200时使用响应中的claims替换原有的claims(不包括绑定的ip)，返回204时保留原有的claims，返回其它状态码时拒绝刷新
+func callRefreshHook(rule global.Rule, params *global.SignParams) error {
+	data := refreshHookData{
+		Name:    rule.Name,
+		Subject: params.Subject,
+		Aud:     params.Aud,
+		Payload: params.Payload,
+		Claims:  params.Claims,
+	}
+	reqBytes, err := json.Marshal(&data)
+	if err != nil {
+		log.Err(err).Caller().Send()
+		return err
+	}
+	resp, err := hookClient.Post(rule.RefreshHook, "application/json; charset=UTF-8", bytes.NewReader(reqBytes))
+	if err != nil {
+		log.Err(err).Caller().Send()
+		return err
+	}
+	defer func() {
+		if err = resp.Body.Close(); err != nil {
+			log.Err(err).Caller().Send()
+		}
+	}()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNoContent:
+		return nil
+	default:
+		log.Debug().Str("rule", rule.Name).Str("status", strconv.Itoa(resp.StatusCode)).Msg(errRefreshHookDenied.Error())
+		return errRefreshHookDenied
+	}
+	data = refreshHookData{}
+	if err = json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		log.Err(err).Caller().Send()
+		return errors.New("刷新钩子的响应无效")
+	}
+	params.Subject = data.Subject
+	params.Aud = data.Aud
+	params.Payload = data.Payload
+	params.Claims = claimset.Custom(data.Claims)
+	return nil
+}
+
+var errLoginRequired = errors.New("用户未登录")
+
+// 登录钩子的请求数据，包含用户代理的Cookie和Authorization，由钩子判断用户的登录状态
+type loginHookRequest struct {
+	Name          string `json:"name"`
+	ClientID      string `json:"client_id"`
+	Scope         string `json:"scope,omitempty"`
+	RequestURI    string `json:"request_uri"`
+	IP            string `json:"ip,omitempty"`
+	Cookie        string `json:"cookie,omitempty"`
+	Authorization string `json:"authorization,omitempty"`
+}
+
+// 登录钩子的响应数据
+type loginHookResponse struct {
+	refreshHookData
+	Redirect string `json:"redirect,omitempty"`  // 用户未登录时重定向到的登录页面
+	AuthTime int64  `json:"auth_time,omitempty"` // 用户登录的时间(Unix时间戳)，未返回时为授权请求的时间
+}
+
+// 调用规则的登录钩子获取已登录用户的sub和claims
+// 钩子返回200时使用响应中的sub、aud、payload和claims，返回401时用户未登录(可以在响应中返回登录页面的redirect)，返回其它状态码时拒绝授权
+func callLoginHook(ctx *tsing.Context, rule global.Rule, clientID, scope string) (result loginHookResponse, err error) {
+	data := loginHookRequest{
+		Name:          rule.Name,
+		ClientID:      clientID,
+		Scope:         scope,
+		RequestURI:    ctx.Request.URL.RequestURI(),
+		IP:            clientIP(ctx),
+		Cookie:        ctx.Request.Header.Get("Cookie"),
+		Authorization: ctx.Request.Header.Get("Authorization"),
+	}
+	reqBytes, err := json.Marshal(&data)
+	if err != nil {
+		log.Err(err).Caller().Send()
+		return
+	}
+	resp, err := hookClient.Post(rule.LoginHook, "application/json; charset=UTF-8", bytes.NewReader(reqBytes))
+	if err != nil {
+		log.Err(err).Caller().Send()
+		return
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.Err(err).Caller().Send()
+		}
+	}()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized:
+		// 响应中可以包含登录页面的地址，没有响应数据时由授权端点返回错误
+		if json.NewDecoder(resp.Body).Decode(&result) != nil {
+			result = loginHookResponse{}
+		}
+		return result, errLoginRequired
+	default:
+		log.Debug().Str("rule", rule.Name).Str("status", strconv.Itoa(resp.StatusCode)).Msg("登录钩子拒绝授权")
+		return result, errAccessDenied
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		log.Err(err).Caller().Send()
+		return result, errors.New("登录钩子的响应无效")
+	}
+	if result.Subject == "" {
+		return result, errors.New("登录钩子未返回sub")
+	}
+	result.Claims = claimset.Custom(result.Claims)
+	return result, nil
+}
@@ -0,0 +1,160 @@
+package service
+
+import (
+	"local/global"
+	"local/keyutil"
+
+	"github.com/dxvgef/filter/v2"
+	"github.com/dxvgef/tsing"
+)
+
+// 授权自省(RFC 7662)，未传入规则名称时根据token的kid或iss查找规则
+func (self *Auth) Introspect(ctx *tsing.Context) error {
+	var (
+		err      error
+		name     string
+		tokenStr string
+		hint     string
+	)
+	if err = filter.Batch(
+		filter.String(ctx.Post("token"), "token").Require().Set(&tokenStr),
+		filter.String(ctx.Post("token_type_hint"), "token_type_hint").Set(&hint),
+		filter.String(ctx.Post("name"), "name").Set(&name),
+	); err != nil {
+		resp := map[string]string{"error": "invalid_request", "error_description": err.Error()}
+		return JSON(ctx, 400, &resp)
+	}
+	rules := introspectRules(name, tokenStr)
+	for k := range rules {
+		if result := introspectToken(rules[k], tokenStr, hint); result != nil {
+			return JSON(ctx, 200, &result)
+		}
+	}
+	// 无效的token只返回active
+	resp := map[string]bool{"active": false}
+	return JSON(ctx, 200, &resp)
+}
+
+// 获得用于自省token的规则
+func introspectRules(name, tokenStr string) (rules []global.Rule) {
+	if name != "" {
+		if rule, exists := loadRule(name); exists {
+			rules = append(rules, rule)
+		}
+		return
+	}
+	kid := keyutil.TokenKeyID(tokenStr)
+	iss := keyutil.TokenIssuer(tokenStr)
+	if kid == "" && iss == "" {
+		return
+	}
+	global.Rules.Range(func(_, value interface{}) bool {
+		rule, ok := value.(global.Rule)
+		if !ok {
+			return true
+		}
+		if (kid != "" && ruleHasKeyID(&rule, kid)) || (iss != "" && rule.Issuer == iss) {
+			rules = append(rules, rule)
+		}
+		return true
+	})
+	return
+}
+
+// 判断规则的授权器或更新器的基础配置或密钥集是否有指定kid的密钥
+func ruleHasKeyID(rule *global.Rule, kid string) bool {
+	if baseKeyID(rule.Authorizer.Instance, rule.Authorizer.Config) == kid || baseKeyID(rule.Updater.Instance, rule.Updater.Config) == kid {
+		return true
+	}
+	for _, target := range []string{"authorizer", "updater"} {
+		keys := ruleKeys(rule, target)
+		for k := range keys {
+			if keys[k].ID == kid {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 自省token，hint为refresh_token时优先按刷新授权处理，token无效时返回nil
+func introspectToken(rule global.Rule, tokenStr, hint string) map[string]interface{} {
+	if hint == "refresh_token" {
+		if result := introspectRefreshToken(rule, tokenStr); result != nil {
+			return result
+		}
+		return introspectAccessToken(rule, tokenStr)
+	}
+	if result := introspectAccessToken(rule, tokenStr); result != nil {
+		return result
+	}
+	return introspectRefreshToken(rule, tokenStr)
+}
+
+// 自省授权
+func introspectAccessToken(rule global.Rule, tokenStr string) map[string]interface{} {
+	claims, err := verifyAccessToken(rule, tokenStr)
+	if err != nil {
+		return nil
+	}
+	result := make(map[string]interface{}, len(claims.Claims)+12)
+	// 自定义claims做为扩展字段，不能覆盖标准字段，client_id单独映射为RFC 7662的字段
+	for k, v := range claims.Claims {
+		if k != "client_id" {
+			result[k] = v
+		}
+	}
+	result["active"] = true
+	result["token_type"] = "access_token"
+	result["rule"] = rule.Name
+	setIntrospectField(result, "iss", claims.Issuer)
+	setIntrospectField(result, "sub", claims.Subject)
+	setIntrospectField(result, "aud", claims.Aud)
+	setIntrospectField(result, "jti", claims.ID)
+	setIntrospectField(result, "payload", claims.Payload)
+	setIntrospectField(result, "ip", claims.IP)
+	setIntrospectField(result, "scope", claims.Scope)
+	clientID, _ := claims.Claims["client_id"].(string)
+	setIntrospectField(result, "client_id", clientID)
+	setIntrospectTime(result, "exp", claims.Expires)
+	setIntrospectTime(result, "iat", claims.IssuedAt)
+	setIntrospectTime(result, "nbf", claims.NotBefore)
+	return result
+}
+
+// 自省刷新授权
+func introspectRefreshToken(rule global.Rule, tokenStr string) map[string]interface{} {
+	if rule.Updater.Type == "" {
+		return nil
+	}
+	refreshClaims, err := verifyRefreshToken(rule, tokenStr)
+	if err != nil {
+		return nil
+	}
+	result := map[string]interface{}{
+		"active":     true,
+		"token_type": "refresh_token",
+		"rule":       rule.Name,
+	}
+	setIntrospectField(result, "aud", refreshClaims.Aud)
+	setIntrospectTime(result, "exp", refreshClaims.Expires)
+	return result
+}
+
+// 写入非空的字符串字段
+func setIntrospectField(result map[string]interface{}, name, value string) {
+	if value != "" {
+		result[name] = value
+	} else {
+		delete(result, name)
+	}
+}
+
+// 写入非0的时间字段
+func setIntrospectTime(result map[string]interface{}, name string, value int64) {
+	if value != 0 {
+		result[name] = value
+	} else {
+		delete(result, name)
+	}
+}
@@ -0,0 +1,90 @@
+package service
+
+import (
+	"net/url"
+	"testing"
+)
+
+// 自省有效的授权和刷新授权返回RFC 7662的字段，无效的只返回active
+func TestIntrospect(t *testing.T) {
+	setupTest(t)
+	addRule(t, url.Values{
+		"name":       {"app"},
+		"scopes":     {"read"},
+		"authorizer": {`{"type":"JWT_HS256","config":"{\"expires\":60,\"secret\":\"123456\",\"key_id\":\"k1\"}"}`},
+		"updater":    {`{"type":"JWT_HS256","config":"{\"expires\":600,\"secret\":\"654321\",\"key_id\":\"r1\"}"}`},
+	})
+	addRule(t, url.Values{
+		"name":       {"iss"},
+		"issuer":     {testIssuer},
+		"authorizer": {`{"type":"JWT_HS256","config":"{\"expires\":60,\"secret\":\"abcdef\"}"}`},
+	})
+	clientID, clientSecret := addClient(t, url.Values{"client_id": {"svc"}, "rules": {"app"}})
+
+	resp := signToken(t, url.Values{"name": {"app"}, "sub": {"bob"}, "scope": {"read"}, "claims": {`{"dept":"it"}`}})
+	tokenStr, refreshTokenStr := resp.String("token"), resp.String("refresh_token")
+	issToken := signToken(t, url.Values{"name": {"iss"}, "sub": {"alice"}}).String("token")
+	revokedToken := signToken(t, url.Values{"name": {"app"}, "sub": {"bob"}}).String("token")
+	if resp = request(t, "DELETE", "/auth", url.Values{"name": {"app"}, "token": {revokedToken}}, nil); resp.Code != 204 {
+		t.Fatalf("吊销失败：%d %v", resp.Code, resp.Body)
+	}
+	resp = request(t, "POST", "/oauth/token", url.Values{"grant_type": {"client_credentials"}, "client_id": {clientID}, "client_secret": {clientSecret}}, nil)
+	if resp.Code != 200 {
+		t.Fatalf("客户端凭证模式签发授权失败：%d %v", resp.Code, resp.Body)
+	}
+	clientToken := resp.String("access_token")
+
+	cases := []struct {
+		name   string
+		form   url.Values
+		fields map[string]string // 为nil时期望active为false
+	}{
+		{"授权", url.Values{"name": {"app"}, "token": {tokenStr}}, map[string]string{"token_type": "access_token", "rule": "app", "sub": "bob", "scope": "read", "dept": "it"}},
+		{"根据kid查找规则", url.Values{"token": {tokenStr}}, map[string]string{"token_type": "access_token", "rule": "app", "sub": "bob"}},
+		{"根据iss查找规则", url.Values{"token": {issToken}}, map[string]string{"token_type": "access_token", "rule": "iss", "sub": "alice", "iss": testIssuer}},
+		{"授权使用refresh_token提示", url.Values{"name": {"app"}, "token": {tokenStr}, "token_type_hint": {"refresh_token"}}, map[string]string{"token_type": "access_token"}},
+		{"刷新授权", url.Values{"name": {"app"}, "token": {refreshTokenStr}}, map[string]string{"token_type": "refresh_token", "rule": "app"}},
+		{"根据刷新授权的kid查找规则", url.Values{"token": {refreshTokenStr}}, map[string]string{"token_type": "refresh_token", "rule": "app"}},
+		{"刷新授权使用提示", url.Values{"name": {"app"}, "token": {refreshTokenStr}, "token_type_hint": {"refresh_token"}}, map[string]string{"token_type": "refresh_token"}},
+		{"客户端凭证模式的授权", url.Values{"name": {"app"}, "token": {clientToken}}, map[string]string{"token_type": "access_token", "sub": clientID, "client_id": clientID}},
+		{"已吊销", url.Values{"name": {"app"}, "token": {revokedToken}}, nil},
+		{"规则不匹配", url.Values{"name": {"iss"}, "token": {tokenStr}}, nil},
+		{"规则不存在", url.Values{"name": {"none"}, "token": {tokenStr}}, nil},
+		{"无法查找规则", url.Values{"token": {"abc"}}, nil},
+		{"无效的授权", url.Values{"name": {"app"}, "token": {"abc"}}, nil},
+	}
+	for _, c := range cases {
+		resp = request(t, "POST", "/introspect", c.form, nil)
+		if resp.Code != 200 {
+			t.Errorf("%s：期望200，实际%d %v", c.name, resp.Code, resp.Body)
+			continue
+		}
+		if c.fields == nil {
+			if resp.Body["active"] != false || len(resp.Body) != 1 {
+				t.Errorf("%s：应只返回active为false：%v", c.name, resp.Body)
+			}
+			continue
+		}
+		if resp.Body["active"] != true {
+			t.Errorf("%s：active应为true：%v", c.name, resp.Body)
+			continue
+		}
+		for k, v := range c.fields {
+			if resp.String(k) != v {
+				t.Errorf("%s：%s期望%s，实际%v", c.name, k, v, resp.Body[k])
+			}
+		}
+		if _, exists := resp.Body["exp"]; !exists {
+			t.Errorf("%s：缺少exp", c.name)
+		}
+		if c.fields["client_id"] == "" {
+			if _, exists := resp.Body["client_id"]; exists {
+				t.Errorf("%s：不应包含client_id", c.name)
+			}
+		}
+	}
+
+	if resp = request(t, "POST", "/introspect", url.Values{"name": {"app"}}, nil); resp.Code != 400 || resp.String("error") != "invalid_request" {
+		t.Errorf("缺少token时应返回invalid_request：%d %v", resp.Code, resp.Body)
+	}
+}
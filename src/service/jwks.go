@@ -0,0 +1,129 @@
+package service
+
+import (
+	"crypto"
+	"sort"
+	"strings"
+
+	"local/global"
+	"local/keyutil"
+
+	"github.com/dxvgef/filter/v2"
+	"github.com/dxvgef/tsing"
+	"github.com/rs/zerolog/log"
+)
+
+// 公开的JWKS，用于客户端在本地验证授权
+type JWKS struct{}
+
+type jwksResponse struct {
+	Keys []keyutil.JWK `json:"keys"`
+}
+
+// 输出所有规则的授权器公钥
+func (self *JWKS) All(ctx *tsing.Context) error {
+	var (
+		err  error
+		resp jwksResponse
+		keys []keyutil.JWK
+	)
+	resp.Keys = make([]keyutil.JWK, 0)
+	global.Rules.Range(func(_, value interface{}) bool {
+		rule, ok := value.(global.Rule)
+		if !ok {
+			return true
+		}
+		if keys, err = ruleJWKs(&rule); err != nil {
+			log.Err(err).Caller().Str("rule", rule.Name).Send()
+			return false
+		}
+		resp.Keys = append(resp.Keys, keys...)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	ctx.ResponseWriter.Header().Set("Cache-Control", "public, max-age=300")
+	return JSON(ctx, 200, &resp)
+}
+
+// 输出单个规则的授权器公钥
+func (self *JWKS) Rule(ctx *tsing.Context) error {
+	var (
+		err    error
+		resp   jwksResponse
+		errMsg = make(map[string]string)
+		name   string
+	)
+	name, err = filter.String(ctx.PathParams.Value("name"), "name").Require().Base64RawURLDecode().String()
+	if err != nil {
+		errMsg["error"] = err.Error()
+		return JSON(ctx, 400, &errMsg)
+	}
+	rule, exists := loadRule(name)
+	if !exists {
+		errMsg["error"] = "规则不存在"
+		return JSON(ctx, 404, &errMsg)
+	}
+	if resp.Keys, err = ruleJWKs(&rule); err != nil {
+		log.Err(err).Caller().Send()
+		return err
+	}
+	if len(resp.Keys) == 0 {
+		errMsg["error"] = "规则未使用非对称的JWT算法"
+		return JSON(ctx, 404, &errMsg)
+	}
+	ctx.ResponseWriter.Header().Set("Cache-Control", "public, max-age=300")
+	return JSON(ctx, 200, &resp)
+}
+
+// 获得规则中授权器的所有公钥(包括活动密钥和仅验证密钥)的JWK，只支持JWT算法
+func ruleJWKs(rule *global.Rule) ([]keyutil.JWK, error) {
+	var publicKeys map[string]crypto.PublicKey
+	alg := jwtAlg(rule.Authorizer.Type)
+	if alg == "" {
+		return nil, nil
+	}
+	switch instance := rule.Authorizer.Instance.(type) {
+	case global.PublicKeySetInstance:
+		publicKeys = instance.ExportPublicKeys()
+	case global.PublicKeyInstance:
+		var kid string
+		if keyIDInstance, ok := instance.(global.KeyIDInstance); ok {
+			kid = keyIDInstance.ExportKeyID()
+		}
+		publicKeys = map[string]crypto.PublicKey{kid: instance.ExportPublicKey()}
+	default:
+		return nil, nil
+	}
+	kids := make([]string, 0, len(publicKeys))
+	for kid := range publicKeys {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+	jwks := make([]keyutil.JWK, 0, len(kids))
+	for k := range kids {
+		jwk, err := keyutil.PublicKeyToJWK(publicKeys[kids[k]])
+		if err != nil {
+			return nil, err
+		}
+		jwk.Kid = kids[k]
+		jwk.Alg = alg
+		jwk.Use = "sig"
+		jwks = append(jwks, jwk)
+	}
+	return jwks, nil
+}
+
+// 获得规则类型对应的JWT签名算法，对称算法及非JWT类型返回空字符串
+func jwtAlg(typ string) string {
+	typ = strings.ToUpper(typ)
+	switch typ {
+	case "JWT_RS256", "JWT_RS384", "JWT_RS512", "JWT_PS256", "JWT_PS384", "JWT_PS512",
+		"JWT_ES256", "JWT_ES384", "JWT_ES512", "JWT_SM2":
+		return strings.TrimPrefix(typ, "JWT_")
+	case "JWT_EDDSA":
+		return "EdDSA"
+	}
+	return ""
+}
@@ -0,0 +1,312 @@
+package service
+
+import (
+	"errors"
+	"time"
+
+	"local/authorizer"
+	"local/global"
+	"local/keyutil"
+	"local/updater"
+
+	"github.com/dxvgef/filter/v2"
+	"github.com/dxvgef/tsing"
+	"github.com/rs/zerolog/log"
+)
+
+// 密钥管理
+type Key struct{}
+
+// 密钥信息，不包含密钥配置
+type keyInfo struct {
+	ID      string `json:"kid"`
+	Status  string `json:"status"`
+	Created int64  `json:"created"`
+	Retired int64  `json:"retired,omitempty"`
+}
+
+// 列出规则的密钥
+func (self *Key) List(ctx *tsing.Context) error {
+	var (
+		err    error
+		resp   = make(map[string]string)
+		name   string
+		target string
+	)
+	if err = filter.Batch(
+		filter.String(ctx.PathParams.Value("name"), "name").Require().Base64RawURLDecode().Set(&name),
+		filter.String(ctx.Query("target"), "target").EnumString([]string{"authorizer", "updater"}).Set(&target),
+	); err != nil {
+		resp["error"] = err.Error()
+		return JSON(ctx, 400, &resp)
+	}
+	rule, exists := loadRule(name)
+	if !exists {
+		resp["error"] = "规则不存在"
+		return JSON(ctx, 404, &resp)
+	}
+	keys := ruleKeys(&rule, target)
+	list := make([]keyInfo, len(keys))
+	for k := range keys {
+		list[k].ID = keys[k].ID
+		list[k].Status = keys[k].Status
+		list[k].Created = keys[k].Created
+		list[k].Retired = keys[k].Retired
+	}
+	return JSON(ctx, 200, &list)
+}
+
+// 添加密钥，首个密钥或者active=true时设为活动密钥，否则仅用于验证，添加首个密钥时基础配置转为仅验证密钥
+func (self *Key) Add(ctx *tsing.Context) error {
+	var (
+		err    error
+		resp   = make(map[string]string)
+		name   string
+		target string
+		active bool
+		key    global.Key
+	)
+	if err = filter.Batch(
+		filter.String(ctx.PathParams.Value("name"), "name").Require().Base64RawURLDecode().Set(&name),
+		filter.String(ctx.Post("target"), "target").EnumString([]string{"authorizer", "updater"}).Set(&target),
+		filter.String(ctx.Post("kid"), "kid").Set(&key.ID),
+		filter.String(ctx.Post("config"), "config").Require().IsJSON().Set(&key.Config),
+		filter.String(ctx.Post("active"), "active").IsBool().Set(&active),
+	); err != nil {
+		resp["error"] = err.Error()
+		return JSON(ctx, 400, &resp)
+	}
+	rule, exists := loadRule(name)
+	if !exists {
+		resp["error"] = "规则不存在"
+		return JSON(ctx, 404, &resp)
+	}
+	if key.ID == "" {
+		if key.ID, err = keyutil.NewKeyID(); err != nil {
+			log.Err(err).Caller().Send()
+			return err
+		}
+	}
+	key.Created = time.Now().Unix()
+	key.Status = global.KeyStatusVerify
+	keys := ruleKeys(&rule, target)
+	if len(keys) == 0 {
+		// 基础配置转为仅验证密钥，新密钥做为活动密钥
+		if keys, err = withLegacyKey(&rule, target, keys, key.Created); err != nil {
+			log.Err(err).Caller().Send()
+			return err
+		}
+		active = true
+	}
+	if active {
+		key.Status = global.KeyStatusActive
+		keys = retireActiveKey(keys, key.Created)
+	}
+	keys = append(keys, key)
+	if err = saveRuleKeys(rule, target, keys); err != nil {
+		resp["error"] = err.Error()
+		return JSON(ctx, 400, &resp)
+	}
+	resp["kid"] = key.ID
+	return JSON(ctx, 200, &resp)
+}
+
+// 将密钥设为活动密钥，原活动密钥转为仅验证
+func (self *Key) Promote(ctx *tsing.Context) error {
+	var (
+		err    error
+		resp   = make(map[string]string)
+		name   string
+		target string
+		kid    string
+		found  bool
+	)
+	if err = filter.Batch(
+		filter.String(ctx.PathParams.Value("name"), "name").Require().Base64RawURLDecode().Set(&name),
+		filter.String(ctx.PathParams.Value("kid"), "kid").Require().Set(&kid),
+		filter.String(ctx.Post("target"), "target").EnumString([]string{"authorizer", "updater"}).Set(&target),
+	); err != nil {
+		resp["error"] = err.Error()
+		return JSON(ctx, 400, &resp)
+	}
+	rule, exists := loadRule(name)
+	if !exists {
+		resp["error"] = "规则不存在"
+		return JSON(ctx, 404, &resp)
+	}
+	keys := retireActiveKey(ruleKeys(&rule, target), time.Now().Unix())
+	for k := range keys {
+		if keys[k].ID == kid {
+			keys[k].Status = global.KeyStatusActive
+			keys[k].Retired = 0
+			found = true
+		}
+	}
+	if !found {
+		resp["error"] = "密钥不存在"
+		return JSON(ctx, 404, &resp)
+	}
+	if err = saveRuleKeys(rule, target, keys); err != nil {
+		resp["error"] = err.Error()
+		return JSON(ctx, 400, &resp)
+	}
+	return Status(ctx, 204)
+}
+
+// 删除密钥，该密钥签发的授权将无法通过验证
+func (self *Key) Delete(ctx *tsing.Context) error {
+	var (
+		err    error
+		resp   = make(map[string]string)
+		name   string
+		target string
+		kid    string
+	)
+	if err = filter.Batch(
+		filter.String(ctx.PathParams.Value("name"), "name").Require().Base64RawURLDecode().Set(&name),
+		filter.String(ctx.PathParams.Value("kid"), "kid").Require().Set(&kid),
+		filter.String(ctx.Query("target"), "target").EnumString([]string{"authorizer", "updater"}).Set(&target),
+	); err != nil {
+		resp["error"] = err.Error()
+		return JSON(ctx, 400, &resp)
+	}
+	rule, exists := loadRule(name)
+	if !exists {
+		resp["error"] = "规则不存在"
+		return JSON(ctx, 404, &resp)
+	}
+	oldKeys := ruleKeys(&rule, target)
+	keys := make([]global.Key, 0, len(oldKeys))
+	for k := range oldKeys {
+		if oldKeys[k].ID != kid {
+			keys = append(keys, oldKeys[k])
+			continue
+		}
+		if oldKeys[k].Status == global.KeyStatusActive {
+			resp["error"] = "不能删除活动密钥，请先启用其它密钥"
+			return JSON(ctx, 400, &resp)
+		}
+	}
+	if len(keys) == len(oldKeys) {
+		return Status(ctx, 204)
+	}
+	if err = saveRuleKeys(rule, target, keys); err != nil {
+		resp["error"] = err.Error()
+		return JSON(ctx, 400, &resp)
+	}
+	return Status(ctx, 204)
+}
+
+// 立即轮换密钥，生成新的活动密钥，原活动密钥转为仅验证
+func (self *Key) Rotate(ctx *tsing.Context) error {
+	var (
+		err    error
+		resp   = make(map[string]string)
+		name   string
+		target string
+		keys   []global.Key
+	)
+	if err = filter.Batch(
+		filter.String(ctx.PathParams.Value("name"), "name").Require().Base64RawURLDecode().Set(&name),
+		filter.String(ctx.Post("target"), "target").EnumString([]string{"authorizer", "updater"}).Set(&target),
+	); err != nil {
+		resp["error"] = err.Error()
+		return JSON(ctx, 400, &resp)
+	}
+	rule, exists := loadRule(name)
+	if !exists {
+		resp["error"] = "规则不存在"
+		return JSON(ctx, 404, &resp)
+	}
+	if keys, err = rotateKeys(&rule, target, time.Now().Unix(), true); err != nil {
+		resp["error"] = err.Error()
+		return JSON(ctx, 400, &resp)
+	}
+	if keys == nil {
+		resp["error"] = "规则未配置更新器"
+		return JSON(ctx, 400, &resp)
+	}
+	if err = saveRuleKeys(rule, target, keys); err != nil {
+		resp["error"] = err.Error()
+		return JSON(ctx, 400, &resp)
+	}
+	resp["kid"] = keys[len(keys)-1].ID
+	return JSON(ctx, 200, &resp)
+}
+
+// 从本地加载规则
+func loadRule(name string) (global.Rule, bool) {
+	value, exists := global.Rules.Load(name)
+	if !exists {
+		return global.Rule{}, false
+	}
+	rule, ok := value.(global.Rule)
+	return rule, ok
+}
+
+// 获得规则中授权器或更新器的密钥集的副本
+func ruleKeys(rule *global.Rule, target string) []global.Key {
+	var keys []global.Key
+	if target == "updater" {
+		keys = rule.Updater.Keys
+	} else {
+		keys = rule.Authorizer.Keys
+	}
+	return append(make([]global.Key, 0, len(keys)+1), keys...)
+}
+
+// 为授权器或更新器生成新的活动密钥
+func generateKey(target, typ, config string, now int64) (key global.Key, err error) {
+	if key.ID, err = keyutil.NewKeyID(); err != nil {
+		return
+	}
+	if target == "updater" {
+		key.Config, err = updater.GenerateKey(typ, config)
+	} else {
+		key.Config, err = authorizer.GenerateKey(typ, config)
+	}
+	if err != nil {
+		return
+	}
+	key.Status = global.KeyStatusActive
+	key.Created = now
+	return
+}
+
+// 将活动密钥转为仅验证
+func retireActiveKey(keys []global.Key, now int64) []global.Key {
+	for k := range keys {
+		if keys[k].Status == global.KeyStatusActive {
+			keys[k].Status = global.KeyStatusVerify
+			keys[k].Retired = now
+		}
+	}
+	return keys
+}
+
+// 设置规则中授权器或更新器的密钥集，并重新构建实例校验配置
+func setRuleKeys(rule *global.Rule, target string, keys []global.Key) (err error) {
+	if target == "updater" {
+		if rule.Updater.Type == "" {
+			return errors.New("规则未配置更新器")
+		}
+		rule.Updater.Keys = keys
+		rule.Updater.Instance, err = updater.BuildWithKeys(rule.Updater.Type, rule.Updater.Config, rule.Updater.Keys)
+		return
+	}
+	rule.Authorizer.Keys = keys
+	rule.Authorizer.Instance, err = authorizer.BuildWithKeys(rule.Authorizer.Type, rule.Authorizer.Config, rule.Authorizer.Keys)
+	return
+}
+
+// 更新规则的密钥集并保存到存储器
+func saveRuleKeys(rule global.Rule, target string, keys []global.Key) error {
+	if err := setRuleKeys(&rule, target, keys); err != nil {
+		return err
+	}
+	if err := global.StorageInstance.SaveRule(rule); err != nil {
+		log.Err(err).Caller().Send()
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,388 @@
+package service
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+
+	"local/claimset"
+	"local/global"
+
+	"github.com/dxvgef/filter/v2"
+	"github.com/dxvgef/tsing"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// OAuth 2.0授权服务
+type OAuth struct{}
+
+// 客户端身份验证失败
+var errInvalidClient = errors.New("客户端身份验证失败")
+
+// 令牌端点(RFC 6749)，客户端使用client_id和client_secret换取授权，公开客户端只需要client_id
+func (self *OAuth) Token(ctx *tsing.Context) error {
+	var (
+		err       error
+		grantType string
+	)
+	if err = filter.Batch(
+		filter.String(ctx.Post("grant_type"), "grant_type").Require().Set(&grantType),
+	); err != nil {
+		return oauthError(ctx, 400, "invalid_request", err.Error())
+	}
+	client, err := authenticateClient(ctx)
+	if err != nil {
+		if err == errInvalidClient {
+			ctx.ResponseWriter.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+			return oauthError(ctx, 401, "invalid_client", err.Error())
+		}
+		return oauthError(ctx, 400, "invalid_request", err.Error())
+	}
+	switch grantType {
+	case "client_credentials":
+		if client.Public {
+			return oauthError(ctx, 400, "unauthorized_client", "公开客户端不能使用客户端凭证模式")
+		}
+		return clientCredentialsGrant(ctx, client)
+	case "authorization_code":
+		return authorizationCodeGrant(ctx, client)
+	case "refresh_token":
+		return refreshTokenGrant(ctx, client)
+	}
+	return oauthError(ctx, 400, "unsupported_grant_type", "不支持的grant_type："+grantType)
+}
+
+// 客户端凭证模式，以客户端自身的身份签发授权，不签发刷新授权
+func clientCredentialsGrant(ctx *tsing.Context, client global.Client) error {
+	var (
+		err      error
+		ruleName string
+		scopeStr string
+	)
+	if err = filter.Batch(
+		filter.String(ctx.Post("rule"), "rule").Set(&ruleName),
+		filter.String(ctx.Post("scope"), "scope").Set(&scopeStr),
+	); err != nil {
+		return oauthError(ctx, 400, "invalid_request", err.Error())
+	}
+	rule, err := clientRule(client, ruleName)
+	if err != nil {
+		return oauthError(ctx, 400, "invalid_request", err.Error())
+	}
+	grant := oauthGrant{
+		ClientID: client.ID,
+		Rule:     rule.Name,
+		Subject:  client.ID,
+		TokenUse: tokenUseClient,
+	}
+	if grant.Scope, err = clientScope(client, rule, scopeStr); err != nil {
+		return oauthError(ctx, 400, "invalid_scope", err.Error())
+	}
+	tokenStr, err := signGrant(rule, grant, "", sessionExpires(rule, time.Now().Unix()))
+	if err != nil {
+		return oauthError(ctx, 500, "server_error", "签发授权失败："+err.Error())
+	}
+	return tokenResponse(ctx, rule, tokenStr, "", "", grant.Scope)
+}
+
+// 授权码模式，校验授权码、重定向地址和PKCE的code_verifier
+func authorizationCodeGrant(ctx *tsing.Context, client global.Client) error {
+	var (
+		err             error
+		code            string
+		redirectURI     string
+		codeVerifier    string
+		refreshTokenStr string
+		idTokenStr      string
+	)
+	if err = filter.Batch(
+		filter.String(ctx.Post("code"), "code").Require().Set(&code),
+		filter.String(ctx.Post("redirect_uri"), "redirect_uri").Set(&redirectURI),
+		filter.String(ctx.Post("code_verifier"), "code_verifier").Require().Set(&codeVerifier),
+	); err != nil {
+		return oauthError(ctx, 400, "invalid_request", err.Error())
+	}
+	record, err := useAuthCode(code)
+	if err != nil {
+		if err == errAuthCodeInvalid {
+			return oauthError(ctx, 400, "invalid_grant", err.Error())
+		}
+		return oauthError(ctx, 500, "server_error", err.Error())
+	}
+	// 授权码只能由申请它的客户端使用，重定向地址必须与授权请求一致
+	if record.Grant.ClientID != client.ID || record.RedirectURI != redirectURI {
+		return oauthError(ctx, 400, "invalid_grant", errAuthCodeInvalid.Error())
+	}
+	if !verifyCodeChallenge(codeVerifier, record.CodeChallenge) {
+		return oauthError(ctx, 400, "invalid_grant", "code_verifier无效")
+	}
+	rule, err := clientRule(client, record.Grant.Rule)
+	if err != nil {
+		return oauthError(ctx, 400, "invalid_grant", err.Error())
+	}
+
+	now := time.Now().Unix()
+	tokenStr, err := signGrant(rule, record.Grant, record.TokenID, sessionExpires(rule, now))
+	if err != nil {
+		return oauthError(ctx, 500, "server_error", "签发授权失败："+err.Error())
+	}
+	// 使用规则的更新器签发刷新授权，刷新授权族在授权请求时创建
+	if rule.Updater.Type != "" {
+		refreshTokenStr, err = signRefreshToken(rule, tokenStr, refreshRecord{
+			Family:  record.Family,
+			Created: now,
+			Grant:   &record.Grant,
+		})
+		if err != nil {
+			return oauthError(ctx, 500, "server_error", "签发刷新授权失败："+err.Error())
+		}
+	}
+	// 申请了openid时签发ID Token
+	if hasScopes(record.Grant.Scope, []string{"openid"}) {
+		if idTokenStr, err = signIDToken(rule, record.Grant, record.Nonce, tokenStr, sessionExpires(rule, now)); err != nil {
+			return oauthError(ctx, 500, "server_error", "签发ID Token失败："+err.Error())
+		}
+	}
+	return tokenResponse(ctx, rule, tokenStr, refreshTokenStr, idTokenStr, record.Grant.Scope)
+}
+
+// 刷新授权模式，不需要传入原授权，可以缩小scope，刷新授权只能使用一次
+func refreshTokenGrant(ctx *tsing.Context, client global.Client) error {
+	var (
+		err                error
+		refreshTokenStr    string
+		newRefreshTokenStr string
+		ruleName           string
+		scopeStr           string
+		idTokenStr         string
+		rule               global.Rule
+		record             refreshRecord
+	)
+	if err = filter.Batch(
+		filter.String(ctx.Post("refresh_token"), "refresh_token").Require().Set(&refreshTokenStr),
+		filter.String(ctx.Post("rule"), "rule").Set(&ruleName),
+		filter.String(ctx.Post("scope"), "scope").Set(&scopeStr),
+	); err != nil {
+		return oauthError(ctx, 400, "invalid_request", err.Error())
+	}
+	// 未传入规则名称时使用客户端的规则依次验证刷新授权
+	names := client.Rules
+	if ruleName != "" {
+		names = []string{ruleName}
+	}
+	err = errRefreshTokenUnknown
+	for k := range names {
+		var exists bool
+		if rule, exists = loadRule(names[k]); !exists || !containsString(client.Rules, rule.Name) || rule.Updater.Type == "" {
+			continue
+		}
+		if _, err = verifyRefreshToken(rule, refreshTokenStr); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return oauthError(ctx, 400, "invalid_grant", "刷新授权："+err.Error())
+	}
+	// 使用刷新授权前先校验客户端申请的scope，避免刷新授权因参数错误失效
+	if _, err = clientScope(client, rule, scopeStr); err != nil {
+		return oauthError(ctx, 400, "invalid_scope", err.Error())
+	}
+	// 先读取刷新授权的数据，所有校验通过后才使用，避免刷新失败时刷新授权失效
+	if record, err = loadRefreshToken(refreshTokenStr); err != nil {
+		if err == errRefreshTokenUnknown || err == errRefreshTokenReused {
+			return oauthError(ctx, 400, "invalid_grant", err.Error())
+		}
+		return oauthError(ctx, 500, "server_error", err.Error())
+	}
+	// 只能使用客户端自己的授权码换取的刷新授权
+	if record.Grant == nil || record.Grant.ClientID != client.ID || record.Grant.Rule != rule.Name {
+		return oauthError(ctx, 400, "invalid_grant", errRefreshTokenUnknown.Error())
+	}
+	if global.IsRevoked(record.Family) {
+		return oauthError(ctx, 400, "invalid_grant", "刷新授权："+global.ErrTokenRevoked.Error())
+	}
+	if err = checkSession(rule, record); err != nil {
+		return oauthError(ctx, 400, "invalid_grant", err.Error())
+	}
+
+	// 新授权的scope不能超出原授权，以及客户端和规则当前允许的scope
+	grant := *record.Grant
+	allowed := parseScopes(allowedScopes(parseScopes(grant.Scope), allowedScopes(rule.Scopes, strings.Join(client.Scopes, " "))))
+	scope := strings.Join(allowed, " ")
+	if scopeStr != "" {
+		if scope, err = checkScopes(allowed, parseScopes(scopeStr)); err != nil {
+			return oauthError(ctx, 400, "invalid_scope", "超出原授权的scope")
+		}
+	}
+	// 通过刷新钩子获取最新的claims
+	if rule.RefreshHook != "" {
+		params := global.SignParams{Subject: grant.Subject, Aud: grant.Aud, Payload: grant.Payload, Claims: grant.Claims}
+		if err = callRefreshHook(rule, &params); err != nil {
+			if err == errRefreshHookDenied {
+				return oauthError(ctx, 400, "invalid_grant", err.Error())
+			}
+			return oauthError(ctx, 500, "server_error", "调用刷新钩子失败："+err.Error())
+		}
+		grant.Subject, grant.Aud, grant.Payload, grant.Claims = params.Subject, params.Aud, params.Payload, params.Claims
+	}
+	// 刷新授权只能使用一次，并发刷新时只有第一个使用的请求能签发新的授权
+	if err = useRefreshToken(refreshTokenStr); err != nil {
+		if err == errRefreshTokenUnknown || err == errRefreshTokenConflict {
+			return oauthError(ctx, 400, "invalid_grant", err.Error())
+		}
+		return oauthError(ctx, 500, "server_error", err.Error())
+	}
+	signed := grant
+	signed.Scope = scope
+	tokenStr, err := signGrant(rule, signed, "", sessionExpires(rule, record.Created))
+	if err != nil {
+		return oauthError(ctx, 500, "server_error", "签发授权失败："+err.Error())
+	}
+	// 签发属于同一个刷新授权族的刷新授权，保留原授权的scope
+	record.Count++
+	record.Grant = &grant
+	if newRefreshTokenStr, err = signRefreshToken(rule, tokenStr, record); err != nil {
+		return oauthError(ctx, 500, "server_error", "签发刷新授权失败："+err.Error())
+	}
+	// 新授权包含openid时签发新的ID Token，不包含nonce
+	if hasScopes(scope, []string{"openid"}) {
+		if idTokenStr, err = signIDToken(rule, grant, "", tokenStr, sessionExpires(rule, record.Created)); err != nil {
+			return oauthError(ctx, 500, "server_error", "签发ID Token失败："+err.Error())
+		}
+	}
+	return tokenResponse(ctx, rule, tokenStr, newRefreshTokenStr, idTokenStr, scope)
+}
+
+// 使用规则的授权器签发OAuth授权，tokenID为授权的jti，为空时生成新的jti，claims中的client_id为客户端的client_id，token_use为授权的用途
+func signGrant(rule global.Rule, grant oauthGrant, tokenID string, expires int64) (tokenStr string, err error) {
+	params := global.SignParams{
+		Subject: grant.Subject,
+		Aud:     grant.Aud,
+		Payload: grant.Payload,
+		Issuer:  rule.Issuer,
+		Scope:   grant.Scope,
+		Expires: expires,
+		Claims:  claimset.Combine(rule.Claims, grant.Claims),
+	}
+	// client_id和token_use是保留的claims，只能在此处设置
+	if params.Claims == nil {
+		params.Claims = make(map[string]interface{}, 2)
+	}
+	params.Claims["client_id"] = grant.ClientID
+	if grant.TokenUse != "" {
+		params.Claims[tokenUseClaim] = grant.TokenUse
+	}
+	if params.ID = tokenID; params.ID == "" {
+		if params.ID, err = newTokenID(); err != nil {
+			log.Err(err).Caller().Send()
+			return
+		}
+	}
+	if tokenStr, err = rule.Authorizer.Instance.Sign(params); err != nil {
+		log.Err(err).Caller().Send()
+	}
+	return
+}
+
+// 验证客户端的身份，支持HTTP Basic认证和表单参数(client_id和client_secret)，公开客户端不能传入client_secret
+func authenticateClient(ctx *tsing.Context) (global.Client, error) {
+	id, secret, basic := ctx.Request.BasicAuth()
+	if basic {
+		// Basic认证的client_id和client_secret需要先进行URL解码(RFC 6749 2.3.1)
+		var err error
+		if id, err = url.QueryUnescape(id); err != nil {
+			return global.Client{}, errInvalidClient
+		}
+		if secret, err = url.QueryUnescape(secret); err != nil {
+			return global.Client{}, errInvalidClient
+		}
+		if ctx.Post("client_secret") != "" {
+			return global.Client{}, errors.New("不能同时使用多种客户端身份验证方式")
+		}
+	} else {
+		id = ctx.Post("client_id")
+		secret = ctx.Post("client_secret")
+	}
+	if id == "" {
+		return global.Client{}, errInvalidClient
+	}
+	client, exists := loadClient(id)
+	if !exists {
+		return global.Client{}, errInvalidClient
+	}
+	if client.Public {
+		if secret != "" {
+			return global.Client{}, errInvalidClient
+		}
+		return client, nil
+	}
+	if secret == "" || bcrypt.CompareHashAndPassword(global.StrToBytes(client.SecretHash), global.StrToBytes(secret)) != nil {
+		return global.Client{}, errInvalidClient
+	}
+	return client, nil
+}
+
+// 获得客户端使用的规则，客户端允许使用多个规则时必须传入规则名称
+func clientRule(client global.Client, name string) (global.Rule, error) {
+	if name == "" {
+		if len(client.Rules) != 1 {
+			return global.Rule{}, errors.New("客户端允许使用多个规则，请传入规则名称")
+		}
+		name = client.Rules[0]
+	} else if !containsString(client.Rules, name) {
+		return global.Rule{}, errors.New("客户端不允许使用的规则：" + name)
+	}
+	rule, exists := loadRule(name)
+	if !exists {
+		return global.Rule{}, errors.New("规则不存在：" + name)
+	}
+	return rule, nil
+}
+
+// 获得客户端申请的scope，只能申请客户端和规则都允许的scope，未传入scope时使用客户端允许的所有scope
+func clientScope(client global.Client, rule global.Rule, scopeStr string) (string, error) {
+	allowed := parseScopes(allowedScopes(rule.Scopes, strings.Join(client.Scopes, " ")))
+	if scopeStr == "" {
+		return strings.Join(allowed, " "), nil
+	}
+	if _, err := checkScopes(allowed, parseScopes(scopeStr)); err != nil {
+		return "", errors.New("客户端不允许申请的scope")
+	}
+	return strings.Join(parseScopes(scopeStr), " "), nil
+}
+
+// 输出令牌端点的授权，响应不能被缓存
+func tokenResponse(ctx *tsing.Context, rule global.Rule, tokenStr, refreshTokenStr, idTokenStr, scope string) error {
+	resp := map[string]interface{}{
+		"access_token": tokenStr,
+		"token_type":   "Bearer",
+	}
+	if claims, err := rule.Authorizer.Instance.VeritySign(tokenStr); err == nil && claims.Expires > 0 {
+		resp["expires_in"] = claims.Expires - time.Now().Unix()
+	}
+	if refreshTokenStr != "" {
+		resp["refresh_token"] = refreshTokenStr
+	}
+	if idTokenStr != "" {
+		resp["id_token"] = idTokenStr
+	}
+	if scope != "" {
+		resp["scope"] = scope
+	}
+	ctx.ResponseWriter.Header().Set("Cache-Control", "no-store")
+	ctx.ResponseWriter.Header().Set("Pragma", "no-cache")
+	return JSON(ctx, 200, &resp)
+}
+
+// 输出令牌端点的错误(RFC 6749 5.2)
+func oauthError(ctx *tsing.Context, status int, code, description string) error {
+	resp := map[string]string{
+		"error":             code,
+		"error_description": description,
+	}
+	ctx.ResponseWriter.Header().Set("Cache-Control", "no-store")
+	ctx.ResponseWriter.Header().Set("Pragma", "no-cache")
+	return JSON(ctx, status, &resp)
+}
@@ -0,0 +1,270 @@
+package service
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+
+	"local/global"
+	"local/keyutil"
+
+	"github.com/dxvgef/filter/v2"
+	"github.com/dxvgef/tsing"
+	"github.com/rs/zerolog/log"
+)
+
+// 授权码的生命周期(秒)
+const authCodeTTL = 60
+
+var (
+	errAccessDenied    = errors.New("用户拒绝授权")
+	errAuthCodeInvalid = errors.New("授权码无效或已过期")
+)
+
+// OAuth授权的数据，签发授权和刷新授权时使用
+type oauthGrant struct {
+	ClientID string                 `json:"client_id"`
+	Rule     string                 `json:"rule"`
+	Subject  string                 `json:"sub,omitempty"`
+	Aud      string                 `json:"aud,omitempty"`
+	Payload  string                 `json:"payload,omitempty"`
+	Scope    string                 `json:"scope,omitempty"`
+	Claims   map[string]interface{} `json:"claims,omitempty"`
+	AuthTime int64                  `json:"auth_time,omitempty"` // 用户登录的时间，用于ID Token
+	TokenUse string                 `json:"token_use,omitempty"` // 授权的用途，客户端凭证模式为client
+}
+
+// 授权码的数据，保存在存储器中，到期自动删除
+type authCodeRecord struct {
+	Grant         oauthGrant `json:"grant"`
+	RedirectURI   string     `json:"redirect_uri,omitempty"` // 授权请求中的重定向地址，未传入时为空
+	CodeChallenge string     `json:"code_challenge"`         // PKCE的code_challenge(S256)
+	Nonce         string     `json:"nonce,omitempty"`        // OpenID Connect的nonce，写入ID Token
+	Family        string     `json:"family"`                 // 授权码换取的刷新授权所属的族，授权码被重复使用时吊销
+	TokenID       string     `json:"jti"`                    // 授权码换取的授权的jti，授权码被重复使用时吊销
+}
+
+// 授权端点(RFC 6749)，授权码模式，要求使用PKCE(RFC 7636)，通过规则的登录钩子验证用户的登录状态
+func (self *OAuth) Authorize(ctx *tsing.Context) error {
+	var (
+		err           error
+		clientID      string
+		redirectURI   string
+		responseType  string
+		state         string
+		scopeStr      string
+		ruleName      string
+		codeChallenge string
+		method        string
+		nonce         string
+	)
+	if err = filter.Batch(
+		filter.String(ctx.Query("client_id"), "client_id").Require().Set(&clientID),
+		filter.String(ctx.Query("redirect_uri"), "redirect_uri").Set(&redirectURI),
+	); err != nil {
+		return oauthError(ctx, 400, "invalid_request", err.Error())
+	}
+	// 客户端或重定向地址无效时不能重定向
+	client, exists := loadClient(clientID)
+	if !exists {
+		return oauthError(ctx, 400, "invalid_request", "客户端不存在")
+	}
+	target := redirectURI
+	if target == "" {
+		if len(client.RedirectURIs) != 1 {
+			return oauthError(ctx, 400, "invalid_request", "缺少redirect_uri")
+		}
+		target = client.RedirectURIs[0]
+	} else if !containsString(client.RedirectURIs, redirectURI) {
+		return oauthError(ctx, 400, "invalid_request", "客户端未注册的重定向地址")
+	}
+
+	// 之后的错误通过重定向返回给客户端
+	state = ctx.Query("state")
+	if err = filter.Batch(
+		filter.String(ctx.Query("response_type"), "response_type").Require().Set(&responseType),
+		filter.String(ctx.Query("scope"), "scope").Set(&scopeStr),
+		filter.String(ctx.Query("rule"), "rule").Set(&ruleName),
+		filter.String(ctx.Query("code_challenge"), "code_challenge").Require().Set(&codeChallenge),
+		filter.String(ctx.Query("code_challenge_method"), "code_challenge_method").Require().Set(&method),
+		filter.String(ctx.Query("nonce"), "nonce").Set(&nonce),
+	); err != nil {
+		return authorizeRedirect(ctx, target, url.Values{"error": {"invalid_request"}, "error_description": {err.Error()}}, state)
+	}
+	if responseType != "code" {
+		return authorizeRedirect(ctx, target, url.Values{"error": {"unsupported_response_type"}}, state)
+	}
+	if method != "S256" || !isPKCEValue(codeChallenge) {
+		return authorizeRedirect(ctx, target, url.Values{"error": {"invalid_request"}, "error_description": {"只支持S256的code_challenge"}}, state)
+	}
+	rule, err := clientRule(client, ruleName)
+	if err != nil {
+		return authorizeRedirect(ctx, target, url.Values{"error": {"invalid_request"}, "error_description": {err.Error()}}, state)
+	}
+	if rule.LoginHook == "" {
+		return authorizeRedirect(ctx, target, url.Values{"error": {"unauthorized_client"}, "error_description": {"规则未配置登录钩子"}}, state)
+	}
+	scope, err := clientScope(client, rule, scopeStr)
+	if err != nil {
+		return authorizeRedirect(ctx, target, url.Values{"error": {"invalid_scope"}}, state)
+	}
+	if hasScopes(scope, []string{"openid"}) && !supportsOIDC(rule) {
+		return authorizeRedirect(ctx, target, url.Values{"error": {"invalid_scope"}, "error_description": {"规则不支持OpenID Connect"}}, state)
+	}
+
+	// 通过登录钩子获得已登录的用户，未登录时重定向到登录页面
+	user, err := callLoginHook(ctx, rule, client.ID, scope)
+	switch {
+	case err == errLoginRequired && user.Redirect != "":
+		ctx.Redirect(302, user.Redirect)
+		return nil
+	case err == errLoginRequired:
+		return authorizeRedirect(ctx, target, url.Values{"error": {"login_required"}}, state)
+	case err == errAccessDenied:
+		return authorizeRedirect(ctx, target, url.Values{"error": {"access_denied"}}, state)
+	case err != nil:
+		return authorizeRedirect(ctx, target, url.Values{"error": {"server_error"}}, state)
+	}
+
+	// 签发授权码，只保存授权码的hash
+	record := authCodeRecord{
+		Grant: oauthGrant{
+			ClientID: client.ID,
+			Rule:     rule.Name,
+			Subject:  user.Subject,
+			Aud:      user.Aud,
+			Payload:  user.Payload,
+			Scope:    scope,
+			Claims:   user.Claims,
+			AuthTime: user.AuthTime,
+		},
+		RedirectURI:   redirectURI,
+		CodeChallenge: codeChallenge,
+		Nonce:         nonce,
+	}
+	if record.Grant.AuthTime == 0 {
+		record.Grant.AuthTime = time.Now().Unix()
+	}
+	code, err := keyutil.RandomBase64(32)
+	if err != nil {
+		log.Err(err).Caller().Send()
+		return authorizeRedirect(ctx, target, url.Values{"error": {"server_error"}}, state)
+	}
+	if record.Family, err = newTokenID(); err != nil {
+		log.Err(err).Caller().Send()
+		return authorizeRedirect(ctx, target, url.Values{"error": {"server_error"}}, state)
+	}
+	// 预先生成换取的授权的jti，授权码被重复使用时可以吊销已换取的授权
+	if record.TokenID, err = newTokenID(); err != nil {
+		log.Err(err).Caller().Send()
+		return authorizeRedirect(ctx, target, url.Values{"error": {"server_error"}}, state)
+	}
+	data, err := json.Marshal(&record)
+	if err != nil {
+		log.Err(err).Caller().Send()
+		return authorizeRedirect(ctx, target, url.Values{"error": {"server_error"}}, state)
+	}
+	if err = global.StorageInstance.SaveAuthCode(revocationID(code, ""), data, authCodeTTL); err != nil {
+		log.Err(err).Caller().Send()
+		return authorizeRedirect(ctx, target, url.Values{"error": {"server_error"}}, state)
+	}
+	return authorizeRedirect(ctx, target, url.Values{"code": {code}}, state)
+}
+
+// 将授权端点的结果附加到重定向地址的query中并重定向
+func authorizeRedirect(ctx *tsing.Context, target string, params url.Values, state string) error {
+	u, err := url.Parse(target)
+	if err != nil {
+		log.Err(err).Caller().Send()
+		return oauthError(ctx, 400, "invalid_request", "无效的重定向地址")
+	}
+	if state != "" {
+		params.Set("state", state)
+	}
+	query := u.Query()
+	for k := range params {
+		query.Set(k, params.Get(k))
+	}
+	u.RawQuery = query.Encode()
+	ctx.ResponseWriter.Header().Set("Cache-Control", "no-store")
+	ctx.Redirect(302, u.String())
+	return nil
+}
+
+// 使用授权码，授权码被重复使用时吊销其换取的授权和刷新授权
+func useAuthCode(code string) (record authCodeRecord, err error) {
+	data, first, err := global.StorageInstance.UseAuthCode(revocationID(code, ""))
+	if err != nil {
+		log.Err(err).Caller().Send()
+		return
+	}
+	if data == nil {
+		err = errAuthCodeInvalid
+		return
+	}
+	if err = json.Unmarshal(data, &record); err != nil {
+		log.Err(err).Caller().Send()
+		return
+	}
+	if first {
+		return
+	}
+	// 已使用的授权码被再次使用，说明授权码可能已泄露
+	var expires, tokenExpires int64
+	if rule, exists := loadRule(record.Grant.Rule); exists {
+		now := time.Now().Unix()
+		expires = sessionExpires(rule, now)
+		tokenExpires = grantExpires(rule, now)
+	}
+	if err = addRevoked(record.Family, expires); err != nil {
+		log.Err(err).Caller().Send()
+		return
+	}
+	// 之前保存的授权码没有jti
+	if record.TokenID != "" {
+		if err = addRevoked(record.TokenID, tokenExpires); err != nil {
+			log.Err(err).Caller().Send()
+			return
+		}
+	}
+	log.Warn().Str("client_id", record.Grant.ClientID).Msg("检测到授权码被重复使用")
+	err = errAuthCodeInvalid
+	return
+}
+
+// 获得在当前时间之前换取的授权的最晚过期时间，授权器没有设置有效期时返回会话的结束时间
+func grantExpires(rule global.Rule, now int64) int64 {
+	var base struct {
+		Expires int64 `json:"expires"`
+	}
+	expires := sessionExpires(rule, now)
+	if err := json.Unmarshal(global.StrToBytes(rule.Authorizer.Config), &base); err != nil || base.Expires <= 0 {
+		return expires
+	}
+	if expires == 0 || now+base.Expires < expires {
+		expires = now + base.Expires
+	}
+	return expires
+}
+
+// 校验PKCE的code_verifier，code_challenge = BASE64URL(SHA256(code_verifier))
+func verifyCodeChallenge(verifier, challenge string) bool {
+	if !isPKCEValue(verifier) {
+		return false
+	}
+	sum := sha256.Sum256(global.StrToBytes(verifier))
+	return subtle.ConstantTimeCompare(global.StrToBytes(base64.RawURLEncoding.EncodeToString(sum[:])), global.StrToBytes(challenge)) == 1
+}
+
+// code_verifier和code_challenge的长度为43-128，只能包含字母、数字和-._~
+func isPKCEValue(value string) bool {
+	if len(value) < 43 || len(value) > 128 {
+		return false
+	}
+	return strings.Trim(value, "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-._~") == ""
+}
@@ -0,0 +1,240 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// 测试用的重定向地址
+const testRedirectURI = "https://app.test/cb"
+
+// 启动测试用的登录钩子，返回固定的响应
+func loginHook(t *testing.T, body string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// 根据code_verifier生成S256的code_challenge
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// 发送授权请求，返回重定向地址中的参数
+func authorizeRequest(t *testing.T, query url.Values) url.Values {
+	t.Helper()
+	resp := request(t, "GET", "/oauth/authorize", query, nil)
+	if resp.Code != 302 {
+		t.Fatalf("授权请求没有重定向：%d %v", resp.Code, resp.Body)
+	}
+	location, err := url.Parse(http.Header(resp.Header).Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return location.Query()
+}
+
+// 获得授权码，失败时终止测试
+func authorizeCode(t *testing.T, clientID, verifier string, query url.Values) string {
+	t.Helper()
+	params := url.Values{
+		"client_id":             {clientID},
+		"response_type":         {"code"},
+		"code_challenge":        {codeChallenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+	for k := range query {
+		params.Set(k, query.Get(k))
+	}
+	result := authorizeRequest(t, params)
+	if result.Get("code") == "" {
+		t.Fatalf("获得授权码失败：%v", result)
+	}
+	return result.Get("code")
+}
+
+// 添加授权码模式使用的规则和公开客户端，返回client_id
+func setupCodeFlow(t *testing.T, hookBody string) string {
+	t.Helper()
+	addRule(t, url.Values{
+		"name":       {"app"},
+		"login_hook": {loginHook(t, hookBody).URL},
+		"authorizer": {`{"type":"JWT_HS256","config":"{\"expires\":60,\"secret\":\"123456\"}"}`},
+		"updater":    {`{"type":"JWT_HS256","config":"{\"expires\":600,\"secret\":\"654321\"}"}`},
+	})
+	clientID, _ := addClient(t, url.Values{"client_id": {"web"}, "public": {"true"}, "rules": {"app"}, "redirect_uris": {testRedirectURI}})
+	return clientID
+}
+
+// token_use和client_id是保留的claims，只有客户端凭证模式签发的授权才使用客户端的身份
+func TestReservedClaims(t *testing.T) {
+	setupTest(t)
+	addRule(t, url.Values{
+		"name":       {"app"},
+		"authorizer": {`{"type":"JWT_HS256","config":"{\"expires\":60,\"secret\":\"123456\"}"}`},
+	})
+	clientID, clientSecret := addClient(t, url.Values{"client_id": {"svc"}, "rules": {"app"}})
+	grantPermission(t, "app", clientID, true, "admin")
+
+	// 不能通过传入的claims或规则的默认claims设置保留的claims
+	for _, claims := range []string{`{"token_use":"client"}`, `{"token_use":"id"}`, `{"client_id":"svc"}`} {
+		if resp := request(t, "POST", "/auth", url.Values{"name": {"app"}, "sub": {clientID}, "claims": {claims}}, nil); resp.Code != 400 {
+			t.Errorf("签发授权时应拒绝保留的claims %s：%d", claims, resp.Code)
+		}
+		resp := request(t, "POST", "/rule/", url.Values{
+			"name":       {"other"},
+			"authorizer": {`{"type":"JWT_HS256","config":"{\"secret\":\"123456\"}"}`},
+			"claims":     {claims},
+		}, nil)
+		if resp.Code != 400 {
+			t.Errorf("规则的默认claims应拒绝保留的claims %s：%d", claims, resp.Code)
+		}
+	}
+
+	// 与客户端同名的用户不能使用客户端的权限
+	userToken := signToken(t, url.Values{"name": {"app"}, "sub": {clientID}}).String("token")
+	if resp := request(t, "GET", "/authorize", url.Values{"name": {"app"}, "token": {userToken}, "permission": {"admin"}}, nil); resp.Code != 403 {
+		t.Fatalf("用户的授权不应使用客户端的身份：%d %v", resp.Code, resp.Body)
+	}
+
+	// 客户端凭证模式签发的授权使用客户端的身份
+	resp := request(t, "POST", "/oauth/token", url.Values{"grant_type": {"client_credentials"}, "client_id": {clientID}, "client_secret": {clientSecret}}, nil)
+	if resp.Code != 200 {
+		t.Fatalf("客户端凭证模式签发授权失败：%d %v", resp.Code, resp.Body)
+	}
+	clientToken := resp.String("access_token")
+	resp = request(t, "GET", "/auth", url.Values{"name": {"app"}, "token": {clientToken}}, nil)
+	claims, _ := resp.Body["claims"].(map[string]interface{})
+	if resp.Code != 200 || claims["token_use"] != tokenUseClient || claims["client_id"] != clientID {
+		t.Fatalf("客户端凭证模式签发的授权的claims无效：%d %v", resp.Code, resp.Body)
+	}
+	if resp = request(t, "GET", "/authorize", url.Values{"name": {"app"}, "token": {clientToken}, "permission": {"admin"}}, nil); resp.Code != 200 {
+		t.Fatalf("客户端的授权应使用客户端的身份：%d %v", resp.Code, resp.Body)
+	}
+}
+
+func TestVerifyCodeChallenge(t *testing.T) {
+	// RFC 7636 附录B的示例
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	challenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+	cases := []struct {
+		name      string
+		verifier  string
+		challenge string
+		want      bool
+	}{
+		{"RFC 7636示例", verifier, challenge, true},
+		{"不匹配", verifier[:42] + "a", challenge, false},
+		{"长度不足43", verifier[:42], codeChallenge(verifier[:42]), false},
+		{"长度超过128", strings.Repeat("a", 129), codeChallenge(strings.Repeat("a", 129)), false},
+		{"最大长度", strings.Repeat("a", 128), codeChallenge(strings.Repeat("a", 128)), true},
+		{"无效的字符", verifier[:42] + "+", codeChallenge(verifier[:42] + "+"), false},
+		{"plain方式", verifier, verifier, false},
+	}
+	for _, c := range cases {
+		if got := verifyCodeChallenge(c.verifier, c.challenge); got != c.want {
+			t.Errorf("%s：期望%v，实际%v", c.name, c.want, got)
+		}
+	}
+}
+
+// 授权码模式只支持S256的PKCE，code_verifier无效时授权码失效
+func TestPKCE(t *testing.T) {
+	setupTest(t)
+	clientID := setupCodeFlow(t, `{"sub":"bob"}`)
+	verifier := strings.Repeat("v", 43)
+
+	// 授权请求的code_challenge
+	authorizeCases := []struct {
+		name      string
+		challenge string
+		method    string
+		err       string
+	}{
+		{"plain方式", verifier, "plain", "invalid_request"},
+		{"缺少方式", codeChallenge(verifier), "", "invalid_request"},
+		{"challenge长度无效", "abc", "S256", "invalid_request"},
+		{"S256", codeChallenge(verifier), "S256", ""},
+	}
+	for _, c := range authorizeCases {
+		result := authorizeRequest(t, url.Values{
+			"client_id":             {clientID},
+			"response_type":         {"code"},
+			"code_challenge":        {c.challenge},
+			"code_challenge_method": {c.method},
+			"state":                 {"xyz"},
+		})
+		if result.Get("error") != c.err || result.Get("state") != "xyz" || (c.err == "") != (result.Get("code") != "") {
+			t.Errorf("%s：期望%q，实际%v", c.name, c.err, result)
+		}
+	}
+
+	// 换取授权时的code_verifier
+	tokenCases := []struct {
+		name     string
+		verifier string
+		code     int
+		err      string
+	}{
+		{"缺少code_verifier", "", 400, "invalid_request"},
+		{"不匹配", strings.Repeat("w", 43), 400, "invalid_grant"},
+		{"使用code_challenge", codeChallenge(verifier), 400, "invalid_grant"},
+		{"匹配", verifier, 200, ""},
+	}
+	for _, c := range tokenCases {
+		code := authorizeCode(t, clientID, verifier, nil)
+		resp := request(t, "POST", "/oauth/token", url.Values{"grant_type": {"authorization_code"}, "client_id": {clientID}, "code": {code}, "code_verifier": {c.verifier}}, nil)
+		if resp.Code != c.code || resp.String("error") != c.err {
+			t.Errorf("%s：期望%d %q，实际%d %v", c.name, c.code, c.err, resp.Code, resp.Body)
+			continue
+		}
+		if c.code == 200 {
+			continue
+		}
+		// 校验失败的授权码已被使用，不能再换取授权
+		if c.err == "invalid_grant" {
+			resp = request(t, "POST", "/oauth/token", url.Values{"grant_type": {"authorization_code"}, "client_id": {clientID}, "code": {code}, "code_verifier": {verifier}}, nil)
+			if resp.Code != 400 || resp.String("error") != "invalid_grant" {
+				t.Errorf("%s：授权码应已失效：%d %v", c.name, resp.Code, resp.Body)
+			}
+		}
+	}
+}
+
+// 授权码被重复使用时吊销其换取的授权和刷新授权
+func TestAuthCodeReuse(t *testing.T) {
+	setupTest(t)
+	clientID := setupCodeFlow(t, `{"sub":"bob"}`)
+	verifier := strings.Repeat("v", 43)
+	code := authorizeCode(t, clientID, verifier, nil)
+	form := url.Values{"grant_type": {"authorization_code"}, "client_id": {clientID}, "code": {code}, "code_verifier": {verifier}}
+	resp := request(t, "POST", "/oauth/token", form, nil)
+	if resp.Code != 200 {
+		t.Fatalf("换取授权失败：%d %v", resp.Code, resp.Body)
+	}
+	accessToken, refreshToken := resp.String("access_token"), resp.String("refresh_token")
+	if resp = request(t, "GET", "/auth", url.Values{"name": {"app"}, "token": {accessToken}}, nil); resp.Code != 200 {
+		t.Fatalf("换取的授权无效：%d %v", resp.Code, resp.Body)
+	}
+
+	if resp = request(t, "POST", "/oauth/token", form, nil); resp.Code != 400 || resp.String("error") != "invalid_grant" {
+		t.Fatalf("重复使用授权码应失败：%d %v", resp.Code, resp.Body)
+	}
+	if resp = request(t, "GET", "/auth", url.Values{"name": {"app"}, "token": {accessToken}}, nil); resp.Code != 401 || resp.String("code") != "token_revoked" {
+		t.Fatalf("授权码被重复使用后换取的授权应被吊销：%d %v", resp.Code, resp.Body)
+	}
+	resp = request(t, "POST", "/oauth/token", url.Values{"grant_type": {"refresh_token"}, "client_id": {clientID}, "refresh_token": {refreshToken}}, nil)
+	if resp.Code != 400 || resp.String("error") != "invalid_grant" {
+		t.Fatalf("授权码被重复使用后换取的刷新授权应被吊销：%d %v", resp.Code, resp.Body)
+	}
+}
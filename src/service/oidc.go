@@ -0,0 +1,232 @@
+package service
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"strings"
+	"time"
+
+	"local/claimset"
+	"local/global"
+
+	"github.com/dxvgef/filter/v2"
+	"github.com/dxvgef/tsing"
+	"github.com/rs/zerolog/log"
+	"github.com/tjfoc/gmsm/sm3"
+)
+
+// OpenID Connect
+type OIDC struct{}
+
+// ID Token的最长生命周期(秒)，规则的授权永不过期时也必须有exp
+const idTokenTTL = 3600
+
+// 标记token用途的claim，验证授权时拒绝ID Token，RBAC根据客户端凭证模式的标记使用客户端的身份
+const (
+	tokenUseClaim  = "token_use"
+	tokenUseID     = "id"
+	tokenUseClient = "client"
+)
+
+// 发现文档(OpenID Connect Discovery 1.0)
+type oidcDiscovery struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ScopesSupported                   []string `json:"scopes_supported,omitempty"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+// 输出签发者为服务地址的规则的发现文档
+func (self *OIDC) Discovery(ctx *tsing.Context) error {
+	var (
+		rule   global.Rule
+		found  bool
+		issuer = publicURL(ctx)
+	)
+	global.Rules.Range(func(_, value interface{}) bool {
+		if r, ok := value.(global.Rule); ok && r.Issuer == issuer && supportsOIDC(r) {
+			rule, found = r, true
+			return false
+		}
+		return true
+	})
+	if !found {
+		resp := map[string]string{"error": "没有签发者为" + issuer + "的规则"}
+		return JSON(ctx, 404, &resp)
+	}
+	return discoveryResponse(ctx, rule)
+}
+
+// 输出单个规则的发现文档
+func (self *OIDC) RuleDiscovery(ctx *tsing.Context) error {
+	resp := make(map[string]string)
+	name, err := filter.String(ctx.PathParams.Value("name"), "name").Require().Base64RawURLDecode().String()
+	if err != nil {
+		resp["error"] = err.Error()
+		return JSON(ctx, 400, &resp)
+	}
+	rule, exists := loadRule(name)
+	if !exists {
+		resp["error"] = "规则不存在"
+		return JSON(ctx, 404, &resp)
+	}
+	if !supportsOIDC(rule) {
+		resp["error"] = "规则不支持OpenID Connect"
+		return JSON(ctx, 404, &resp)
+	}
+	return discoveryResponse(ctx, rule)
+}
+
+// 输出规则的发现文档，端点地址使用服务的公开地址
+func discoveryResponse(ctx *tsing.Context, rule global.Rule) error {
+	base := publicURL(ctx)
+	resp := oidcDiscovery{
+		Issuer:                            rule.Issuer,
+		AuthorizationEndpoint:             base + "/oauth/authorize",
+		TokenEndpoint:                     base + "/oauth/token",
+		UserInfoEndpoint:                  base + "/userinfo",
+		JWKSURI:                           base + "/.well-known/jwks/" + base64.RawURLEncoding.EncodeToString(global.StrToBytes(rule.Name)),
+		ScopesSupported:                   rule.Scopes,
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{"authorization_code", "refresh_token", "client_credentials"},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{jwtAlg(rule.Authorizer.Type)},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+		ClaimsSupported:                   []string{"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce", "at_hash"},
+	}
+	ctx.ResponseWriter.Header().Set("Cache-Control", "public, max-age=300")
+	return JSON(ctx, 200, &resp)
+}
+
+// 用户信息端点，返回授权的sub和自定义claims，授权必须包含openid
+func (self *OIDC) UserInfo(ctx *tsing.Context) error {
+	tokenStr := bearerToken(ctx)
+	if tokenStr == "" {
+		ctx.ResponseWriter.Header().Set("WWW-Authenticate", `Bearer realm="userinfo"`)
+		resp := map[string]string{"error": "缺少授权"}
+		return JSON(ctx, 401, &resp)
+	}
+	var (
+		err    error
+		rule   global.Rule
+		claims global.AuthorizerClaims
+	)
+	rules := introspectRules("", tokenStr)
+	if len(rules) == 0 {
+		return TokenError(ctx, "userinfo", "", global.ErrSignatureInvalid)
+	}
+	for k := range rules {
+		rule = rules[k]
+		if claims, err = verifyAccessToken(rule, tokenStr); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return TokenError(ctx, rule.Name, "", err)
+	}
+	if !hasScopes(claims.Scope, []string{"openid"}) {
+		return TokenError(ctx, rule.Name, "", global.ErrScopeInsufficient)
+	}
+	resp := make(map[string]interface{}, len(claims.Claims)+1)
+	for k, v := range claims.Claims {
+		if k != "client_id" {
+			resp[k] = v
+		}
+	}
+	resp["sub"] = claims.Subject
+	ctx.ResponseWriter.Header().Set("Cache-Control", "no-store")
+	return JSON(ctx, 200, &resp)
+}
+
+// 从Authorization头或表单参数access_token中获得授权
+func bearerToken(ctx *tsing.Context) string {
+	auth := ctx.Request.Header.Get("Authorization")
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ctx.Post("access_token")
+}
+
+// 规则使用非对称的JWT算法并设置了签发者时才能签发ID Token
+func supportsOIDC(rule global.Rule) bool {
+	return rule.Issuer != "" && jwtAlg(rule.Authorizer.Type) != ""
+}
+
+// 服务的公开地址，未配置时使用请求的Host
+func publicURL(ctx *tsing.Context) string {
+	if global.Config.Service.PublicURL != "" {
+		return strings.TrimSuffix(global.Config.Service.PublicURL, "/")
+	}
+	scheme := "http"
+	if ctx.Request.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + ctx.Request.Host
+}
+
+// 使用规则的授权器签发ID Token，aud为客户端的client_id，at_hash为授权的hash，expires为会话的结束时间
+func signIDToken(rule global.Rule, grant oauthGrant, nonce, accessToken string, expires int64) (tokenStr string, err error) {
+	if limit := time.Now().Unix() + idTokenTTL; expires == 0 || expires > limit {
+		expires = limit
+	}
+	claims := map[string]interface{}{
+		"auth_time": grant.AuthTime,
+		"at_hash":   atHash(jwtAlg(rule.Authorizer.Type), accessToken),
+		"azp":       grant.ClientID,
+	}
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+	params := global.SignParams{
+		Issuer:  rule.Issuer,
+		Subject: grant.Subject,
+		Aud:     grant.ClientID,
+		Expires: expires,
+		Claims:  claimset.Combine(grant.Claims, claims),
+	}
+	params.Claims[tokenUseClaim] = tokenUseID
+	if params.ID, err = newTokenID(); err != nil {
+		log.Err(err).Caller().Send()
+		return
+	}
+	if tokenStr, err = rule.Authorizer.Instance.Sign(params); err != nil {
+		log.Err(err).Caller().Send()
+	}
+	return
+}
+
+// 判断token是否为ID Token
+func isIDToken(claims global.AuthorizerClaims) bool {
+	use, _ := claims.Claims[tokenUseClaim].(string)
+	return use == tokenUseID
+}
+
+// 计算at_hash，取授权的hash的左半部分，hash算法与签名算法一致
+func atHash(alg, tokenStr string) string {
+	var sum []byte
+	switch {
+	case alg == "SM2":
+		sum = sm3.Sm3Sum(global.StrToBytes(tokenStr))
+	case strings.HasSuffix(alg, "384"):
+		s := sha512.Sum384(global.StrToBytes(tokenStr))
+		sum = s[:]
+	case strings.HasSuffix(alg, "512"), alg == "EdDSA":
+		s := sha512.Sum512(global.StrToBytes(tokenStr))
+		sum = s[:]
+	default:
+		s := sha256.Sum256(global.StrToBytes(tokenStr))
+		sum = s[:]
+	}
+	return base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2])
+}
@@ -0,0 +1,248 @@
+package service
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"local/global"
+)
+
+// 测试用的签发者，与测试请求的Host一致
+const testIssuer = "http://auth.test"
+
+// 添加支持OpenID Connect的规则，session为会话的生命周期，0为不限制
+func addOIDCRule(t *testing.T, name, issuer string, expires, session int64, loginHookURL string) {
+	t.Helper()
+	form := url.Values{
+		"name":         {name},
+		"issuer":       {issuer},
+		"scopes":       {"openid,profile"},
+		"generate_key": {"true"},
+		"authorizer":   {`{"type":"JWT_ES256","config":"{\"expires\":` + strconv.FormatInt(expires, 10) + `}"}`},
+		"updater":      {`{"type":"JWT_HS256","config":"{\"expires\":600}"}`},
+	}
+	if session > 0 {
+		form.Set("session", `{"lifetime":`+strconv.FormatInt(session, 10)+`}`)
+	}
+	if loginHookURL != "" {
+		form.Set("login_hook", loginHookURL)
+	}
+	addRule(t, form)
+}
+
+func TestDiscovery(t *testing.T) {
+	setupTest(t)
+	global.Config.Service.PublicURL = ""
+	addOIDCRule(t, "oidc", testIssuer, 60, 0, "")
+	addRule(t, url.Values{
+		"name":       {"hmac"},
+		"issuer":     {testIssuer},
+		"authorizer": {`{"type":"JWT_HS256","config":"{\"secret\":\"123456\"}"}`},
+	})
+
+	resp := request(t, "GET", "/.well-known/openid-configuration", nil, nil)
+	if resp.Code != 200 {
+		t.Fatalf("获取发现文档失败：%d %v", resp.Code, resp.Body)
+	}
+	expected := map[string]string{
+		"issuer":                 testIssuer,
+		"authorization_endpoint": testIssuer + "/oauth/authorize",
+		"token_endpoint":         testIssuer + "/oauth/token",
+		"userinfo_endpoint":      testIssuer + "/userinfo",
+		"jwks_uri":               testIssuer + "/.well-known/jwks/" + global.EncodeKey("oidc"),
+	}
+	for k, v := range expected {
+		if resp.String(k) != v {
+			t.Errorf("%s：期望%s，实际%s", k, v, resp.String(k))
+		}
+	}
+	list := func(name string) string {
+		values, _ := resp.Body[name].([]interface{})
+		items := make([]string, len(values))
+		for k := range values {
+			items[k], _ = values[k].(string)
+		}
+		return strings.Join(items, " ")
+	}
+	lists := map[string]string{
+		"scopes_supported":                      "openid profile",
+		"response_types_supported":              "code",
+		"id_token_signing_alg_values_supported": "ES256",
+		"code_challenge_methods_supported":      "S256",
+	}
+	for k, v := range lists {
+		if list(k) != v {
+			t.Errorf("%s：期望%s，实际%s", k, v, list(k))
+		}
+	}
+
+	cases := []struct {
+		name   string
+		target string
+		code   int
+	}{
+		{"规则的发现文档", "/oidc/" + global.EncodeKey("oidc") + "/.well-known/openid-configuration", 200},
+		{"对称算法的规则", "/oidc/" + global.EncodeKey("hmac") + "/.well-known/openid-configuration", 404},
+		{"规则不存在", "/oidc/" + global.EncodeKey("none") + "/.well-known/openid-configuration", 404},
+	}
+	for _, c := range cases {
+		if resp = request(t, "GET", c.target, nil, nil); resp.Code != c.code {
+			t.Errorf("%s：期望%d，实际%d %v", c.name, c.code, resp.Code, resp.Body)
+		}
+	}
+
+	// 没有签发者为服务地址的规则
+	setupTest(t)
+	addOIDCRule(t, "oidc", "https://other.test", 60, 0, "")
+	if resp = request(t, "GET", "/.well-known/openid-configuration", nil, nil); resp.Code != 404 {
+		t.Errorf("没有签发者为服务地址的规则时应返回404：%d", resp.Code)
+	}
+}
+
+// ID Token的claims和有效期
+func TestIDToken(t *testing.T) {
+	cases := []struct {
+		name     string
+		expires  int64 // 授权器的有效期
+		session  int64 // 会话的生命周期
+		lifetime int64 // ID Token的有效期
+	}{
+		{"授权的有效期较短", 60, 0, 60},
+		{"不超过1小时", 7200, 0, idTokenTTL},
+		{"不超过会话的结束时间", 7200, 600, 600},
+	}
+	hook := loginHook(t, `{"sub":"bob","claims":{"name":"Bob"},"auth_time":1600000000}`)
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			setupTest(t)
+			addOIDCRule(t, "oidc", testIssuer, c.expires, c.session, hook.URL)
+			clientID, _ := addClient(t, url.Values{"client_id": {"web"}, "public": {"true"}, "rules": {"oidc"}, "scopes": {"openid,profile"}, "redirect_uris": {testRedirectURI}})
+			rule, _ := loadRule("oidc")
+			verifier := strings.Repeat("v", 43)
+
+			code := authorizeCode(t, clientID, verifier, url.Values{"scope": {"openid profile"}, "nonce": {"n-0S6"}})
+			now := time.Now().Unix()
+			resp := request(t, "POST", "/oauth/token", url.Values{"grant_type": {"authorization_code"}, "client_id": {clientID}, "code": {code}, "code_verifier": {verifier}}, nil)
+			if resp.Code != 200 || resp.String("id_token") == "" {
+				t.Fatalf("签发ID Token失败：%d %v", resp.Code, resp.Body)
+			}
+			checkIDToken(t, rule, resp, clientID, "n-0S6", now+c.lifetime)
+
+			// 刷新时签发不包含nonce的ID Token
+			now = time.Now().Unix()
+			resp = request(t, "POST", "/oauth/token", url.Values{"grant_type": {"refresh_token"}, "client_id": {clientID}, "refresh_token": {resp.String("refresh_token")}}, nil)
+			if resp.Code != 200 || resp.String("id_token") == "" {
+				t.Fatalf("刷新时签发ID Token失败：%d %v", resp.Code, resp.Body)
+			}
+			checkIDToken(t, rule, resp, clientID, "", now+c.lifetime)
+
+			// ID Token不能做为授权使用
+			if resp = request(t, "GET", "/auth", url.Values{"name": {"oidc"}, "token": {resp.String("id_token")}}, nil); resp.Code != 401 {
+				t.Fatalf("ID Token不应验证通过：%d %v", resp.Code, resp.Body)
+			}
+		})
+	}
+}
+
+// 校验令牌端点返回的ID Token，exp允许1秒的误差
+func checkIDToken(t *testing.T, rule global.Rule, resp testResponse, clientID, nonce string, exp int64) {
+	t.Helper()
+	claims, err := rule.Authorizer.Instance.VeritySign(resp.String("id_token"))
+	if err != nil {
+		t.Fatalf("ID Token的签名无效：%v", err)
+	}
+	if claims.Issuer != testIssuer || claims.Subject != "bob" || claims.Aud != clientID {
+		t.Errorf("ID Token的iss、sub或aud无效：%s %s %s", claims.Issuer, claims.Subject, claims.Aud)
+	}
+	if claims.Expires < exp || claims.Expires > exp+1 {
+		t.Errorf("ID Token的exp无效：期望%d，实际%d", exp, claims.Expires)
+	}
+	expected := map[string]interface{}{
+		"azp":       clientID,
+		"at_hash":   atHash("ES256", resp.String("access_token")),
+		"auth_time": float64(1600000000),
+		"name":      "Bob",
+		"token_use": tokenUseID,
+	}
+	for k, v := range expected {
+		if claims.Claims[k] != v {
+			t.Errorf("ID Token的%s：期望%v，实际%v", k, v, claims.Claims[k])
+		}
+	}
+	if value, exists := claims.Claims["nonce"]; nonce != "" && value != nonce || nonce == "" && exists {
+		t.Errorf("ID Token的nonce：期望%q，实际%v", nonce, value)
+	}
+	if _, exists := claims.Claims["client_id"]; exists {
+		t.Error("ID Token不应包含client_id")
+	}
+}
+
+// 用户信息端点只接受包含openid的授权
+func TestUserInfo(t *testing.T) {
+	setupTest(t)
+	addOIDCRule(t, "oidc", testIssuer, 60, 0, "")
+	sign := func(scope string) string {
+		return signToken(t, url.Values{"name": {"oidc"}, "sub": {"bob"}, "scope": {scope}, "claims": {`{"name":"Bob"}`}}).String("token")
+	}
+	openid, profile := sign("openid profile"), sign("profile")
+
+	cases := []struct {
+		name   string
+		method string
+		form   url.Values
+		header map[string]string
+		code   int
+		err    string
+	}{
+		{"Authorization头", "GET", nil, map[string]string{"Authorization": "Bearer " + openid}, 200, ""},
+		{"小写的Bearer", "GET", nil, map[string]string{"Authorization": "bearer " + openid}, 200, ""},
+		{"表单参数", "POST", url.Values{"access_token": {openid}}, nil, 200, ""},
+		{"缺少授权", "GET", nil, nil, 401, ""},
+		{"不包含openid", "GET", nil, map[string]string{"Authorization": "Bearer " + profile}, 403, "insufficient_scope"},
+		{"无效的授权", "GET", nil, map[string]string{"Authorization": "Bearer abc"}, 401, "signature_invalid"},
+	}
+	for _, c := range cases {
+		resp := request(t, c.method, "/userinfo", c.form, c.header)
+		if resp.Code != c.code || (c.err != "" && resp.String("code") != c.err) {
+			t.Errorf("%s：期望%d %s，实际%d %v", c.name, c.code, c.err, resp.Code, resp.Body)
+			continue
+		}
+		if c.code == 200 && (resp.String("sub") != "bob" || resp.String("name") != "Bob") {
+			t.Errorf("%s：用户信息无效：%v", c.name, resp.Body)
+		}
+		if c.code != 200 && len(resp.Header["Www-Authenticate"]) == 0 {
+			t.Errorf("%s：缺少WWW-Authenticate头", c.name)
+		}
+	}
+}
+
+// at_hash的hash算法与签名算法一致，取左半部分
+func TestAtHash(t *testing.T) {
+	cases := []struct {
+		alg   string
+		token string
+		want  string
+	}{
+		// OpenID Connect Core 1.0 附录A.3的示例
+		{"RS256", "jHkWEdUXMU1BwAsC4vtUsZwnNvTIxEl0z9K3vx5KF0Y", "77QmUPtjPfzWtF2AnpK9RQ"},
+		{"RS256", "abc", "ungWv48Bz-pBQUDeXa4iIw"},
+		{"PS256", "abc", "ungWv48Bz-pBQUDeXa4iIw"},
+		{"ES256", "abc", "ungWv48Bz-pBQUDeXa4iIw"},
+		{"RS384", "abc", "ywB1P0WjXou1oD1pmsZQBycsMqsO3tFj"},
+		{"ES384", "abc", "ywB1P0WjXou1oD1pmsZQBycsMqsO3tFj"},
+		{"PS512", "abc", "3a81oZNherrMQXNJriBBMRLm-k6JqX6iCp7u5ktV05o"},
+		{"ES512", "abc", "3a81oZNherrMQXNJriBBMRLm-k6JqX6iCp7u5ktV05o"},
+		// EdDSA(Ed25519)使用SHA-512
+		{"EdDSA", "abc", "3a81oZNherrMQXNJriBBMRLm-k6JqX6iCp7u5ktV05o"},
+		// SM2使用SM3，SM3("abc")为GB/T 32905的示例
+		{"SM2", "abc", "Zsfw9GLu7dnR8tRr3BDk4g"},
+	}
+	for _, c := range cases {
+		if got := atHash(c.alg, c.token); got != c.want {
+			t.Errorf("%s：期望%s，实际%s", c.alg, c.want, got)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package service
+
+import (
+	"encoding/json"
+	"time"
+
+	"local/global"
+	"local/policy"
+
+	"github.com/dxvgef/filter/v2"
+	"github.com/dxvgef/tsing"
+	"github.com/rs/zerolog/log"
+)
+
+// 策略的试运行结果
+type policyResult struct {
+	Name       string `json:"name,omitempty"`
+	Expression string `json:"expression"`
+	Result     bool   `json:"result"`
+	Error      string `json:"error,omitempty"`
+}
+
+// 试运行策略，不传入expression时对规则的所有策略求值
+// claims来自token(只验证签名，不检查有效期和吊销状态)或JSON对象格式的claims
+func (self *Rule) DryRunPolicy(ctx *tsing.Context) error {
+	var (
+		err                 error
+		resp                = make(map[string]string)
+		name                string
+		expression          string
+		tokenStr, claimsStr string
+		req                 policy.Request
+		unix                int64
+		policies            []global.Policy
+		claimsVar           map[string]interface{}
+	)
+	if err = filter.Batch(
+		filter.String(ctx.PathParams.Value("name"), "name").Require().Base64RawURLDecode().Set(&name),
+		filter.String(ctx.Post("expression"), "expression").Set(&expression),
+		filter.String(ctx.Post("token"), "token").Set(&tokenStr),
+		filter.String(ctx.Post("claims"), "claims").IsJSON().Set(&claimsStr),
+		filter.String(ctx.Post("ip"), "ip").IsIP().Set(&req.IP),
+		filter.String(ctx.Post("aud"), "aud").Set(&req.Aud),
+		filter.String(ctx.Post("method"), "method").Set(&req.Method),
+		filter.String(ctx.Post("resource"), "resource").Set(&req.Resource),
+		filter.String(ctx.Post("time"), "time").IsDigit().Set(&unix),
+	); err != nil {
+		resp["error"] = err.Error()
+		return JSON(ctx, 400, &resp)
+	}
+	rule, exists := loadRule(name)
+	if !exists {
+		resp["error"] = "规则不存在"
+		return JSON(ctx, 404, &resp)
+	}
+
+	// 要求值的策略
+	policies = rule.Policies
+	if expression != "" {
+		program, err := policy.Compile(expression)
+		if err != nil {
+			resp["error"] = "策略编译失败：" + err.Error()
+			return JSON(ctx, 400, &resp)
+		}
+		policies = []global.Policy{{Expression: expression, Program: program}}
+	}
+
+	// 授权的claims
+	switch {
+	case tokenStr != "":
+		claims, err := rule.Authorizer.Instance.VeritySign(tokenStr)
+		if err != nil {
+			return TokenError(ctx, rule.Name, "", err)
+		}
+		claimsVar = policy.ClaimsVar(claims)
+	case claimsStr != "":
+		if err = json.Unmarshal(global.StrToBytes(claimsStr), &claimsVar); err != nil {
+			resp["error"] = "claims必须是JSON对象"
+			return JSON(ctx, 400, &resp)
+		}
+	default:
+		claimsVar = make(map[string]interface{})
+	}
+
+	// 请求的上下文，未传入时间时使用当前时间
+	req.Time = time.Now()
+	if unix > 0 {
+		req.Time = time.Unix(unix, 0)
+	}
+	vars := map[string]interface{}{
+		"claims":  claimsVar,
+		"request": policy.RequestVar(req),
+	}
+
+	passed := true
+	results := make([]policyResult, len(policies))
+	for k := range policies {
+		results[k].Name = policies[k].Name
+		results[k].Expression = policies[k].Expression
+		if policies[k].Program == nil {
+			results[k].Error = "策略未编译"
+		} else if results[k].Result, err = policies[k].Program.Eval(vars); err != nil {
+			results[k].Error = err.Error()
+		}
+		if !results[k].Result {
+			passed = false
+		}
+	}
+	result := map[string]interface{}{
+		"passed":  passed,
+		"results": results,
+	}
+	return JSON(ctx, 200, &result)
+}
+
+// 对规则的策略求值，未传入ip时使用请求方的ip，不通过时返回ErrPolicyDenied
+func checkPolicies(ctx *tsing.Context, rule global.Rule, claims global.AuthorizerClaims, req policy.Request) error {
+	if len(rule.Policies) == 0 {
+		return nil
+	}
+	if req.IP == "" {
+		req.IP = clientIP(ctx)
+	}
+	req.Time = time.Now()
+	failed, err := policy.Check(rule.Policies, policy.Vars(claims, req))
+	if err != nil {
+		log.Debug().Err(err).Str("rule", rule.Name).Str("policy", failed).Msg("策略求值失败")
+		return global.ErrPolicyDenied
+	}
+	if failed != "" {
+		log.Debug().Str("rule", rule.Name).Str("policy", failed).Msg("授权不满足策略")
+		return global.ErrPolicyDenied
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package service
+
+import (
+	"net/url"
+	"testing"
+
+	"local/global"
+)
+
+// 测试用的策略：受众为billing且来自内网，删除操作要求admin角色
+const testPolicies = `[` +
+	`{"name":"billing","expression":"claims.aud == \"billing\" && request.ip in cidr(\"10.0.0.0/8\")"},` +
+	`{"name":"delete","expression":"request.method != \"DELETE\" || \"admin\" in claims.roles"}` +
+	`]`
+
+// 添加带有策略的规则
+func addPolicyRule(t *testing.T) {
+	t.Helper()
+	addRule(t, url.Values{
+		"name":       {"policy"},
+		"policies":   {testPolicies},
+		"authorizer": {`{"type":"JWT_HS256","config":"{\"expires\":60,\"secret\":\"123456\"}"}`},
+	})
+}
+
+// 验证授权和检查权限时对规则的所有策略求值，不满足时返回policy_denied
+func TestVerityPolicy(t *testing.T) {
+	setupTest(t)
+	addPolicyRule(t)
+	grantPermission(t, "policy", "bob", false, "read")
+	userToken := signToken(t, url.Values{"name": {"policy"}, "sub": {"bob"}, "aud": {"billing"}, "claims": {`{"roles":["user"]}`}}).String("token")
+	adminToken := signToken(t, url.Values{"name": {"policy"}, "sub": {"bob"}, "aud": {"billing"}, "claims": {`{"roles":["admin"]}`}}).String("token")
+	shopToken := signToken(t, url.Values{"name": {"policy"}, "sub": {"bob"}, "aud": {"shop"}}).String("token")
+
+	cases := []struct {
+		name   string
+		token  string
+		aud    string
+		ip     string
+		method string
+		code   int
+	}{
+		{"满足所有策略", userToken, "billing", "10.1.2.3", "GET", 200},
+		{"IP不在网段中", userToken, "billing", "192.168.0.1", "GET", 403},
+		{"未传入IP时使用请求方的IP", userToken, "billing", "", "GET", 403},
+		{"受众不满足策略", shopToken, "shop", "10.1.2.3", "GET", 403},
+		{"删除操作缺少admin角色", userToken, "billing", "10.1.2.3", "DELETE", 403},
+		{"删除操作拥有admin角色", adminToken, "billing", "10.1.2.3", "DELETE", 200},
+		{"无效的授权不求值策略", "abc", "billing", "10.1.2.3", "GET", 401},
+	}
+	for _, c := range cases {
+		form := url.Values{"name": {"policy"}, "token": {c.token}, "aud": {c.aud}, "method": {c.method}}
+		if c.ip != "" {
+			form.Set("ip", c.ip)
+		}
+		for _, target := range []string{"/auth", "/authorize"} {
+			if target == "/authorize" {
+				form.Set("permission", "read")
+			}
+			resp := request(t, "GET", target, form, nil)
+			if resp.Code != c.code {
+				t.Errorf("%s %s：期望%d，实际%d %v", c.name, target, c.code, resp.Code, resp.Body)
+				continue
+			}
+			if c.code == 403 && resp.String("code") != "policy_denied" {
+				t.Errorf("%s %s：错误代码无效：%v", c.name, target, resp.Body)
+			}
+		}
+	}
+
+	// 编译失败的策略不能保存
+	for _, policies := range []string{`[{"expression":"claims.aud =="}]`, `[{"expression":""}]`, `{}`} {
+		resp := request(t, "POST", "/rule/", url.Values{
+			"name":       {"invalid"},
+			"policies":   {policies},
+			"authorizer": {`{"type":"JWT_HS256","config":"{\"secret\":\"123456\"}"}`},
+		}, nil)
+		if resp.Code != 400 {
+			t.Errorf("%s：应返回400，实际%d %v", policies, resp.Code, resp.Body)
+		}
+	}
+	if _, exists := loadRule("invalid"); exists {
+		t.Error("策略无效的规则不应保存")
+	}
+}
+
+// 试运行规则的策略或传入的表达式
+func TestDryRunPolicy(t *testing.T) {
+	setupTest(t)
+	addPolicyRule(t)
+	tokenStr := signToken(t, url.Values{"name": {"policy"}, "sub": {"bob"}, "aud": {"billing"}}).String("token")
+	target := "/rule/" + global.EncodeKey("policy") + "/policies/dry_run"
+
+	cases := []struct {
+		name    string
+		form    url.Values
+		code    int
+		passed  bool
+		results []bool
+	}{
+		{"使用token的claims", url.Values{"token": {tokenStr}, "ip": {"10.0.0.1"}}, 200, true, []bool{true, true}},
+		{"使用传入的claims", url.Values{"claims": {`{"aud":"billing","roles":[]}`}, "ip": {"10.0.0.1"}, "method": {"DELETE"}}, 200, false, []bool{true, false}},
+		{"没有claims", url.Values{"ip": {"10.0.0.1"}}, 200, false, []bool{false, true}},
+		{"传入表达式", url.Values{"expression": {"request.time == 1600000000"}, "time": {"1600000000"}}, 200, true, nil},
+		{"无效的表达式", url.Values{"expression": {"request.hour =="}}, 400, false, nil},
+		{"无效的授权", url.Values{"token": {"abc"}}, 401, false, nil},
+		{"无效的claims", url.Values{"claims": {`[]`}}, 400, false, nil},
+	}
+	for _, c := range cases {
+		resp := request(t, "POST", target, c.form, nil)
+		if resp.Code != c.code {
+			t.Errorf("%s：期望%d，实际%d %v", c.name, c.code, resp.Code, resp.Body)
+			continue
+		}
+		if c.code != 200 {
+			continue
+		}
+		if resp.Body["passed"] != c.passed {
+			t.Errorf("%s：passed期望%v，实际%v", c.name, c.passed, resp.Body)
+		}
+		if c.results == nil {
+			continue
+		}
+		results, _ := resp.Body["results"].([]interface{})
+		if len(results) != len(c.results) {
+			t.Errorf("%s：结果数量无效：%v", c.name, results)
+			continue
+		}
+		for k := range results {
+			result, _ := results[k].(map[string]interface{})
+			if result["result"] != c.results[k] {
+				t.Errorf("%s：策略%v的结果期望%v，实际%v", c.name, result["name"], c.results[k], result)
+			}
+		}
+	}
+
+	if resp := request(t, "POST", "/rule/"+global.EncodeKey("none")+"/policies/dry_run", nil, nil); resp.Code != 404 {
+		t.Errorf("规则不存在时应返回404：%d", resp.Code)
+	}
+}
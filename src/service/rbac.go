@@ -0,0 +1,337 @@
+package service
+
+import (
+	"sort"
+	"strings"
+
+	"local/global"
+
+	"github.com/dxvgef/filter/v2"
+	"github.com/dxvgef/tsing"
+	"github.com/rs/zerolog/log"
+)
+
+// 权限、角色和主体管理
+type RBAC struct{}
+
+// 列出所有权限
+func (self *RBAC) ListPermissions(ctx *tsing.Context) error {
+	list := make([]global.Permission, 0, global.SyncMapLen(&global.Permissions))
+	global.Permissions.Range(func(_, value interface{}) bool {
+		if permission, ok := value.(global.Permission); ok {
+			list = append(list, permission)
+		}
+		return true
+	})
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return JSON(ctx, 200, &list)
+}
+
+// 添加或更新权限
+func (self *RBAC) PutPermission(ctx *tsing.Context) error {
+	var (
+		err        error
+		resp       = make(map[string]string)
+		permission global.Permission
+	)
+	if err = filter.Batch(
+		filter.String(ctx.PathParams.Value("name"), "name").Require().Base64RawURLDecode().Set(&permission.Name),
+		filter.String(ctx.Post("description"), "description").Set(&permission.Description),
+	); err != nil {
+		resp["error"] = err.Error()
+		return JSON(ctx, 400, &resp)
+	}
+	if err = global.StorageInstance.SavePermission(permission); err != nil {
+		log.Err(err).Caller().Send()
+		resp["error"] = err.Error()
+		return JSON(ctx, 500, &resp)
+	}
+	return Status(ctx, 204)
+}
+
+// 删除权限，权限被角色使用时不能删除
+func (self *RBAC) DeletePermission(ctx *tsing.Context) error {
+	var (
+		err  error
+		resp = make(map[string]string)
+		name string
+	)
+	name, err = filter.String(ctx.PathParams.Value("name"), "name").Require().Base64RawURLDecode().String()
+	if err != nil {
+		resp["error"] = err.Error()
+		return JSON(ctx, 400, &resp)
+	}
+	if _, exists := global.Permissions.Load(name); !exists {
+		return Status(ctx, 204)
+	}
+	if role := permissionUsedBy(name); role != "" {
+		resp["error"] = "权限正在被角色使用：" + role
+		return JSON(ctx, 400, &resp)
+	}
+	if err = global.StorageInstance.DeletePermission(name); err != nil {
+		log.Err(err).Caller().Send()
+		resp["error"] = err.Error()
+		return JSON(ctx, 500, &resp)
+	}
+	return Status(ctx, 204)
+}
+
+// 列出所有角色
+func (self *RBAC) ListRoles(ctx *tsing.Context) error {
+	list := make([]global.Role, 0, global.SyncMapLen(&global.Roles))
+	global.Roles.Range(func(_, value interface{}) bool {
+		if role, ok := value.(global.Role); ok {
+			list = append(list, role)
+		}
+		return true
+	})
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return JSON(ctx, 200, &list)
+}
+
+// 添加或更新角色，角色的权限必须已存在
+func (self *RBAC) PutRole(ctx *tsing.Context) error {
+	var (
+		err         error
+		resp        = make(map[string]string)
+		role        global.Role
+		permissions string
+	)
+	if err = filter.Batch(
+		filter.String(ctx.PathParams.Value("name"), "name").Require().Base64RawURLDecode().Set(&role.Name),
+		filter.String(ctx.Post("description"), "description").Set(&role.Description),
+		filter.String(ctx.Post("permissions"), "permissions").Set(&permissions),
+	); err != nil {
+		resp["error"] = err.Error()
+		return JSON(ctx, 400, &resp)
+	}
+	role.Permissions = parseNames(permissions)
+	for k := range role.Permissions {
+		if _, exists := global.Permissions.Load(role.Permissions[k]); !exists {
+			resp["error"] = "权限不存在：" + role.Permissions[k]
+			return JSON(ctx, 400, &resp)
+		}
+	}
+	if err = global.StorageInstance.SaveRole(role); err != nil {
+		log.Err(err).Caller().Send()
+		resp["error"] = err.Error()
+		return JSON(ctx, 500, &resp)
+	}
+	return Status(ctx, 204)
+}
+
+// 删除角色，角色被主体使用时不能删除
+func (self *RBAC) DeleteRole(ctx *tsing.Context) error {
+	var (
+		err  error
+		resp = make(map[string]string)
+		name string
+	)
+	name, err = filter.String(ctx.PathParams.Value("name"), "name").Require().Base64RawURLDecode().String()
+	if err != nil {
+		resp["error"] = err.Error()
+		return JSON(ctx, 400, &resp)
+	}
+	if _, exists := global.Roles.Load(name); !exists {
+		return Status(ctx, 204)
+	}
+	if subject := roleUsedBy(name); subject != "" {
+		resp["error"] = "角色正在被主体使用：" + subject
+		return JSON(ctx, 400, &resp)
+	}
+	if err = global.StorageInstance.DeleteRole(name); err != nil {
+		log.Err(err).Caller().Send()
+		resp["error"] = err.Error()
+		return JSON(ctx, 500, &resp)
+	}
+	return Status(ctx, 204)
+}
+
+// 获取主体的角色和权限
+func (self *RBAC) GetSubject(ctx *tsing.Context) error {
+	var (
+		err     error
+		resp    = make(map[string]string)
+		subject global.Subject
+	)
+	if subject, err = subjectParams(ctx); err != nil {
+		resp["error"] = err.Error()
+		return JSON(ctx, 400, &resp)
+	}
+	subject, exists := loadSubject(subject.Key())
+	if !exists {
+		resp["error"] = "主体不存在"
+		return JSON(ctx, 404, &resp)
+	}
+	result := map[string]interface{}{
+		"rule":        subject.Rule,
+		"name":        subject.Name,
+		"client":      subject.Client,
+		"roles":       subject.Roles,
+		"permissions": subjectPermissions(subject),
+	}
+	return JSON(ctx, 200, &result)
+}
+
+// 设置主体的角色，规则和角色必须已存在
+func (self *RBAC) PutSubject(ctx *tsing.Context) error {
+	var (
+		err     error
+		resp    = make(map[string]string)
+		subject global.Subject
+		roles   string
+	)
+	if subject, err = subjectParams(ctx); err != nil {
+		resp["error"] = err.Error()
+		return JSON(ctx, 400, &resp)
+	}
+	if err = filter.Batch(
+		filter.String(ctx.Post("roles"), "roles").Set(&roles),
+	); err != nil {
+		resp["error"] = err.Error()
+		return JSON(ctx, 400, &resp)
+	}
+	if _, exists := global.Rules.Load(subject.Rule); !exists {
+		resp["error"] = "规则不存在"
+		return JSON(ctx, 400, &resp)
+	}
+	subject.Roles = parseNames(roles)
+	for k := range subject.Roles {
+		if _, exists := global.Roles.Load(subject.Roles[k]); !exists {
+			resp["error"] = "角色不存在：" + subject.Roles[k]
+			return JSON(ctx, 400, &resp)
+		}
+	}
+	if err = global.StorageInstance.SaveSubject(subject); err != nil {
+		log.Err(err).Caller().Send()
+		resp["error"] = err.Error()
+		return JSON(ctx, 500, &resp)
+	}
+	return Status(ctx, 204)
+}
+
+// 删除主体的角色
+func (self *RBAC) DeleteSubject(ctx *tsing.Context) error {
+	var (
+		err     error
+		resp    = make(map[string]string)
+		subject global.Subject
+	)
+	if subject, err = subjectParams(ctx); err != nil {
+		resp["error"] = err.Error()
+		return JSON(ctx, 400, &resp)
+	}
+	if _, exists := global.Subjects.Load(subject.Key()); !exists {
+		return Status(ctx, 204)
+	}
+	if err = global.StorageInstance.DeleteSubject(subject); err != nil {
+		log.Err(err).Caller().Send()
+		resp["error"] = err.Error()
+		return JSON(ctx, 500, &resp)
+	}
+	return Status(ctx, 204)
+}
+
+// 解析主体的规则名称、sub和身份类型，client=true时为OAuth客户端的身份
+func subjectParams(ctx *tsing.Context) (subject global.Subject, err error) {
+	err = filter.Batch(
+		filter.String(ctx.PathParams.Value("rule"), "rule").Require().Base64RawURLDecode().Set(&subject.Rule),
+		filter.String(ctx.PathParams.Value("name"), "name").Require().Base64RawURLDecode().Set(&subject.Name),
+		filter.String(ctx.Query("client"), "client").IsBool().Set(&subject.Client),
+	)
+	return
+}
+
+// 解析以逗号分隔的名称，去除空白和重复的名称
+func parseNames(value string) []string {
+	names := make([]string, 0)
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || containsString(names, name) {
+			continue
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+func loadRole(name string) (global.Role, bool) {
+	value, exists := global.Roles.Load(name)
+	if !exists {
+		return global.Role{}, false
+	}
+	role, ok := value.(global.Role)
+	return role, ok
+}
+
+func loadSubject(key string) (global.Subject, bool) {
+	value, exists := global.Subjects.Load(key)
+	if !exists {
+		return global.Subject{}, false
+	}
+	subject, ok := value.(global.Subject)
+	return subject, ok
+}
+
+// 获得使用了权限的任一角色，没有时返回空字符串
+func permissionUsedBy(permission string) (name string) {
+	global.Roles.Range(func(_, value interface{}) bool {
+		if role, ok := value.(global.Role); ok && containsString(role.Permissions, permission) {
+			name = role.Name
+			return false
+		}
+		return true
+	})
+	return
+}
+
+// 获得使用了角色的任一主体，没有时返回空字符串
+func roleUsedBy(role string) (name string) {
+	global.Subjects.Range(func(_, value interface{}) bool {
+		if subject, ok := value.(global.Subject); ok && containsString(subject.Roles, role) {
+			name = subject.Name
+			return false
+		}
+		return true
+	})
+	return
+}
+
+// 获得授权在规则下对应的主体，客户端凭证模式签发的授权使用客户端的身份
+func tokenSubject(rule global.Rule, claims global.AuthorizerClaims) (global.Subject, bool) {
+	use, _ := claims.Claims[tokenUseClaim].(string)
+	return loadSubject(global.Subject{Rule: rule.Name, Name: claims.Subject, Client: use == tokenUseClient}.Key())
+}
+
+// 获得主体通过所有角色拥有的权限
+func subjectPermissions(subject global.Subject) []string {
+	permissions := make([]string, 0)
+	for k := range subject.Roles {
+		role, exists := loadRole(subject.Roles[k])
+		if !exists {
+			continue
+		}
+		for i := range role.Permissions {
+			if !containsString(permissions, role.Permissions[i]) {
+				permissions = append(permissions, role.Permissions[i])
+			}
+		}
+	}
+	sort.Strings(permissions)
+	return permissions
+}
+
+// 判断主体是否拥有权限，返回授予该权限的角色
+func subjectGranted(subject global.Subject, permission string) (string, bool) {
+	for k := range subject.Roles {
+		role, exists := loadRole(subject.Roles[k])
+		if exists && containsString(role.Permissions, permission) {
+			return role.Name, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,194 @@
+package service
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"local/global"
+
+	"github.com/dxvgef/gommon/encrypt"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	errRefreshTokenUnknown  = errors.New("刷新授权无效")
+	errRefreshTokenReused   = errors.New("刷新授权已被使用，已吊销该刷新授权的所有后续授权")
+	errRefreshTokenConflict = errors.New("刷新授权正在被其它请求使用")
+)
+
+// 刷新授权的数据，保存在存储器中，用于单次使用和重用检测
+type refreshRecord struct {
+	Family  string      `json:"family"`          // 刷新授权族，签发授权时创建，之后每次刷新得到的刷新授权都属于同一族
+	TTL     int64       `json:"ttl,omitempty"`   // 刷新授权的生命周期(秒)
+	Created int64       `json:"created"`         // 会话的开始时间，即刷新授权族的创建时间
+	Count   int         `json:"count,omitempty"` // 会话中已刷新的次数
+	Grant   *oauthGrant `json:"grant,omitempty"` // OAuth授权的数据，用于refresh_token模式
+	Token   *tokenData  `json:"token,omitempty"` // 绑定的授权的数据，用于刷新授权
+}
+
+// 授权的数据，刷新授权时沿用，不透明授权过期后会从存储器中删除，只能从此处获得
+type tokenData struct {
+	ID      string                 `json:"jti,omitempty"`
+	Payload string                 `json:"payload,omitempty"`
+	Aud     string                 `json:"aud,omitempty"`
+	IP      string                 `json:"ip,omitempty"`
+	Subject string                 `json:"sub,omitempty"`
+	Scope   string                 `json:"scope,omitempty"`
+	Claims  map[string]interface{} `json:"claims,omitempty"`
+}
+
+// 从签名参数获得授权的数据
+func newTokenData(params global.SignParams) *tokenData {
+	return &tokenData{
+		ID:      params.ID,
+		Payload: params.Payload,
+		Aud:     params.Aud,
+		IP:      params.IP,
+		Subject: params.Subject,
+		Scope:   params.Scope,
+		Claims:  params.Claims,
+	}
+}
+
+// 签发绑定到授权的刷新授权，record中的family为空时创建新的刷新授权族
+func signRefreshToken(rule global.Rule, tokenStr string, record refreshRecord) (refreshTokenStr string, err error) {
+	var (
+		tokenHash string
+		data      []byte
+		now       = time.Now().Unix()
+	)
+	// 计算access token的hash
+	if tokenHash, err = encrypt.MD5ByStr(tokenStr); err != nil {
+		log.Err(err).Caller().Send()
+		return
+	}
+	if refreshTokenStr, err = rule.Updater.Instance.Sign(tokenHash); err != nil {
+		log.Err(err).Caller().Send()
+		return
+	}
+	if record.Family == "" {
+		if record.Family, err = newTokenID(); err != nil {
+			log.Err(err).Caller().Send()
+			return
+		}
+		record.Created = now
+	}
+	// 从新签发的刷新授权中获得过期时间，做为存储器中数据的生命周期，但不能超过会话的结束时间
+	record.TTL = 0
+	expires := sessionExpires(rule, record.Created)
+	if claims, err := rule.Updater.Instance.VeritySign(refreshTokenStr); err == nil && claims.Expires > 0 && (expires == 0 || claims.Expires < expires) {
+		expires = claims.Expires
+	}
+	if expires > 0 {
+		record.TTL = expires - now
+		if record.TTL <= 0 {
+			record.TTL = 1
+		}
+	}
+	if data, err = json.Marshal(&record); err != nil {
+		log.Err(err).Caller().Send()
+		return
+	}
+	if err = global.StorageInstance.SaveRefreshToken(revocationID(refreshTokenStr, ""), data, record.TTL); err != nil {
+		log.Err(err).Caller().Send()
+		return "", err
+	}
+	return
+}
+
+// 校验刷新授权是否绑定到传入的授权
+func refreshTokenBound(refreshClaims global.UpdaterClaims, tokenStr string) bool {
+	tokenHash, err := encrypt.MD5ByStr(tokenStr)
+	if err != nil {
+		log.Err(err).Caller().Send()
+		return false
+	}
+	return subtle.ConstantTimeCompare(global.StrToBytes(refreshClaims.TokenHash), global.StrToBytes(tokenHash)) == 1
+}
+
+// 读取刷新授权的数据但不使用，用于在签发新授权前校验，刷新授权已被使用时按重复使用处理
+func loadRefreshToken(refreshTokenStr string) (record refreshRecord, err error) {
+	data, used, err := global.StorageInstance.LoadRefreshToken(revocationID(refreshTokenStr, ""))
+	if err != nil {
+		log.Err(err).Caller().Send()
+		return
+	}
+	if data == nil {
+		err = errRefreshTokenUnknown
+		return
+	}
+	if err = json.Unmarshal(data, &record); err != nil {
+		log.Err(err).Caller().Send()
+		return
+	}
+	if used {
+		err = revokeRefreshFamily(record)
+	}
+	return
+}
+
+// 使用刷新授权，只有第一个使用的请求成功，并发刷新时其它请求返回errRefreshTokenConflict
+// 此时刷新授权已被本次刷新之前的请求使用，但并不是在其使用之后被重用，所以不吊销刷新授权族
+func useRefreshToken(refreshTokenStr string) (err error) {
+	data, first, err := global.StorageInstance.UseRefreshToken(revocationID(refreshTokenStr, ""))
+	if err != nil {
+		log.Err(err).Caller().Send()
+		return
+	}
+	if data == nil {
+		return errRefreshTokenUnknown
+	}
+	if !first {
+		return errRefreshTokenConflict
+	}
+	return nil
+}
+
+// 已使用的刷新授权被再次使用，说明刷新授权可能已泄露，吊销整个族
+func revokeRefreshFamily(record refreshRecord) error {
+	// 族中最后签发的刷新授权不会晚于当前时间加上生命周期过期
+	var expires int64
+	if record.TTL > 0 {
+		expires = time.Now().Unix() + record.TTL
+	}
+	if err := addRevoked(record.Family, expires); err != nil {
+		log.Err(err).Caller().Send()
+		return err
+	}
+	log.Warn().Str("family", record.Family).Msg("检测到刷新授权被重复使用")
+	return errRefreshTokenReused
+}
+
+// 获得会话的结束时间(Unix时间戳)，未限制会话的生命周期时返回0
+func sessionExpires(rule global.Rule, created int64) int64 {
+	if rule.Session == nil || rule.Session.Lifetime <= 0 || created <= 0 {
+		return 0
+	}
+	return created + rule.Session.Lifetime
+}
+
+// 校验会话是否允许再次刷新
+func checkSession(rule global.Rule, record refreshRecord) error {
+	if end := sessionExpires(rule, record.Created); end > 0 && end <= time.Now().Unix() {
+		return errors.New("会话已过期")
+	}
+	if rule.Session != nil && rule.Session.MaxRefresh > 0 && record.Count >= rule.Session.MaxRefresh {
+		return errors.New("会话的刷新次数已达上限")
+	}
+	return nil
+}
+
+// 将授权的数据转换为授权的claims
+func tokenClaims(data *tokenData) global.AuthorizerClaims {
+	return global.AuthorizerClaims{
+		ID:      data.ID,
+		Payload: data.Payload,
+		Aud:     data.Aud,
+		IP:      data.IP,
+		Subject: data.Subject,
+		Scope:   data.Scope,
+		Claims:  data.Claims,
+	}
+}
@@ -0,0 +1,146 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"local/global"
+)
+
+// 刷新授权的状态与读取、使用的结果
+func TestRefreshTokenReuse(t *testing.T) {
+	cases := []struct {
+		name    string
+		used    bool // 读取前已被使用
+		race    bool // 读取后、使用前被其它请求使用
+		loadErr error
+		useErr  error
+		revoked bool
+	}{
+		{"首次使用", false, false, nil, nil, false},
+		{"并发使用", false, true, nil, errRefreshTokenConflict, false},
+		{"使用后再次使用", true, false, errRefreshTokenReused, nil, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			storage := setupTest(t)
+			addRule(t, url.Values{
+				"name":       {"refresh"},
+				"authorizer": {`{"type":"JWT_HS256","config":"{\"expires\":60,\"secret\":\"123456\"}"}`},
+				"updater":    {`{"type":"JWT_HS256","config":"{\"expires\":600,\"secret\":\"654321\"}"}`},
+			})
+			refreshTokenStr := signToken(t, url.Values{"name": {"refresh"}, "sub": {"bob"}}).String("refresh_token")
+			var stored refreshRecord
+			if err := json.Unmarshal(storage.once["refresh/"+revocationID(refreshTokenStr, "")], &stored); err != nil {
+				t.Fatal(err)
+			}
+			if c.used {
+				if err := useRefreshToken(refreshTokenStr); err != nil {
+					t.Fatal(err)
+				}
+			}
+			record, err := loadRefreshToken(refreshTokenStr)
+			if err != c.loadErr {
+				t.Fatalf("读取：期望%v，实际%v", c.loadErr, err)
+			}
+			if err == nil {
+				if record.Family != stored.Family {
+					t.Fatalf("刷新授权族无效：%s", record.Family)
+				}
+				if c.race {
+					if _, _, err = storage.UseRefreshToken(revocationID(refreshTokenStr, "")); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if err = useRefreshToken(refreshTokenStr); err != c.useErr {
+					t.Fatalf("使用：期望%v，实际%v", c.useErr, err)
+				}
+			}
+			if global.IsRevoked(stored.Family) != c.revoked {
+				t.Fatalf("刷新授权族的吊销状态应为%v", c.revoked)
+			}
+		})
+	}
+	setupTest(t)
+	if _, err := loadRefreshToken("abc"); err != errRefreshTokenUnknown {
+		t.Fatalf("不存在的刷新授权：%v", err)
+	}
+	if err := useRefreshToken("abc"); err != errRefreshTokenUnknown {
+		t.Fatalf("不存在的刷新授权：%v", err)
+	}
+}
+
+// 通过刷新钩子在校验之后、使用之前模拟其它请求的行为
+func TestRefresh(t *testing.T) {
+	var hook func() int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(hook())
+	}))
+	defer server.Close()
+
+	cases := []struct {
+		name    string
+		hook    func(storage *memStorage, refreshTokenStr string) int
+		code    int
+		retry   int  // 之后使用同一个刷新授权的状态码
+		revoked bool // 刷新授权族是否被吊销
+	}{
+		{"刷新成功后重复使用", nil, 200, 400, true},
+		{"刷新钩子拒绝时可以重试", func(*memStorage, string) int { return 403 }, 400, 200, false},
+		{"并发刷新", func(storage *memStorage, refreshTokenStr string) int {
+			_, _, _ = storage.UseRefreshToken(revocationID(refreshTokenStr, ""))
+			return 204
+		}, 409, 400, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			storage := setupTest(t)
+			addRule(t, url.Values{
+				"name":         {"refresh"},
+				"authorizer":   {`{"type":"JWT_HS256","config":"{\"expires\":60,\"secret\":\"123456\"}"}`},
+				"updater":      {`{"type":"JWT_HS256","config":"{\"expires\":600,\"secret\":\"654321\"}"}`},
+				"refresh_hook": {server.URL},
+			})
+			resp := signToken(t, url.Values{"name": {"refresh"}, "sub": {"bob"}})
+			tokenStr, refreshTokenStr := resp.String("token"), resp.String("refresh_token")
+			var record refreshRecord
+			if err := json.Unmarshal(storage.once["refresh/"+revocationID(refreshTokenStr, "")], &record); err != nil {
+				t.Fatal(err)
+			}
+			form := url.Values{"name": {"refresh"}, "token": {tokenStr}, "refresh_token": {refreshTokenStr}}
+
+			// 只在第一次刷新时调用测试用例的钩子
+			hook = func() int {
+				hook = func() int { return 204 }
+				if c.hook == nil {
+					return 204
+				}
+				return c.hook(storage, refreshTokenStr)
+			}
+			if resp = request(t, "PUT", "/auth", form, nil); resp.Code != c.code {
+				t.Fatalf("刷新：期望%d，实际%d %v", c.code, resp.Code, resp.Body)
+			}
+			// 并发刷新失败时不吊销刷新授权族，其它请求得到的授权仍然有效
+			if c.code != 200 && global.IsRevoked(record.Family) {
+				t.Fatal("刷新失败时不应吊销刷新授权族")
+			}
+			newTokenStr, newRefreshTokenStr := resp.String("token"), resp.String("refresh_token")
+			if resp = request(t, "PUT", "/auth", form, nil); resp.Code != c.retry {
+				t.Fatalf("再次刷新：期望%d，实际%d %v", c.retry, resp.Code, resp.Body)
+			}
+			if global.IsRevoked(record.Family) != c.revoked {
+				t.Fatalf("刷新授权族的吊销状态应为%v", c.revoked)
+			}
+			// 刷新授权族被吊销后，族中后续签发的刷新授权也不能使用
+			if c.revoked && newRefreshTokenStr != "" {
+				form = url.Values{"name": {"refresh"}, "token": {newTokenStr}, "refresh_token": {newRefreshTokenStr}}
+				if resp = request(t, "PUT", "/auth", form, nil); resp.Code != 401 || resp.String("code") != "token_revoked" {
+					t.Fatalf("已吊销的刷新授权族中的刷新授权仍然可以使用：%d %v", resp.Code, resp.Body)
+				}
+			}
+		})
+	}
+}
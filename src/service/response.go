@@ -3,6 +3,9 @@ package service
 import (
 	"encoding/json"
 	"net/http"
+	"strings"
+
+	"local/global"
 
 	"github.com/dxvgef/tsing"
 	"github.com/rs/zerolog/log"
@@ -59,3 +62,56 @@ func Status(ctx *tsing.Context, status int) error {
 	}
 	return nil
 }
+
+// 授权验证失败的错误代码
+var tokenErrorCodes = map[error]string{
+	global.ErrTokenMalformed:    "token_malformed",
+	global.ErrAlgorithmMismatch: "algorithm_mismatch",
+	global.ErrSignatureInvalid:  "signature_invalid",
+	global.ErrTokenExpired:      "token_expired",
+	global.ErrTokenNotYetValid:  "token_not_yet_valid",
+	global.ErrTokenRevoked:      "token_revoked",
+	global.ErrTokenUseMismatch:  "token_use_mismatch",
+	global.ErrIssuerMismatch:    "issuer_mismatch",
+	global.ErrAudienceMismatch:  "audience_mismatch",
+	global.ErrIPMismatch:        "ip_mismatch",
+	global.ErrScopeInsufficient: "insufficient_scope",
+	global.ErrPermissionDenied:  "permission_denied",
+	global.ErrPolicyDenied:      "policy_denied",
+}
+
+// 输出授权验证失败的错误，prefix为错误信息的前缀，用于区分授权和刷新授权
+// 返回401状态码(scope不足、没有权限或不满足策略时返回403)、错误代码和WWW-Authenticate，不是授权验证的错误时返回500
+func TokenError(ctx *tsing.Context, realm, prefix string, err error) error {
+	resp := make(map[string]string)
+	code, exists := tokenErrorCodes[err]
+	if !exists {
+		log.Err(err).Caller().Send()
+		resp["error"] = err.Error()
+		return JSON(ctx, 500, &resp)
+	}
+	status, errorType := 401, "invalid_token"
+	if err == global.ErrScopeInsufficient || err == global.ErrPermissionDenied || err == global.ErrPolicyDenied {
+		status, errorType = 403, "insufficient_scope"
+	}
+	ctx.ResponseWriter.Header().Set("WWW-Authenticate", `Bearer realm=`+quotedString(realm)+`, error="`+errorType+`", error_description="`+code+`"`)
+	resp["error"] = prefix + err.Error()
+	resp["code"] = code
+	return JSON(ctx, status, &resp)
+}
+
+// quoted-string中需要转义的字符
+var quotedReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// 将字符串转为HTTP头中的quoted-string(RFC 7230)，转义双引号和反斜杠
+func quotedString(value string) string {
+	return `"` + quotedReplacer.Replace(value) + `"`
+}
+
+// 忽略授权验证的错误，只返回其它错误(例如存储器错误)
+func ignoreTokenError(err error) error {
+	if _, exists := tokenErrorCodes[err]; exists {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,48 @@
+package service
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestQuotedString(t *testing.T) {
+	cases := []struct {
+		value string
+		want  string
+	}{
+		{`test`, `"test"`},
+		{``, `""`},
+		{`a"b`, `"a\"b"`},
+		{`a\b`, `"a\\b"`},
+		{`\"`, `"\\\""`},
+	}
+	for _, c := range cases {
+		if got := quotedString(c.value); got != c.want {
+			t.Errorf("%s：期望%s，实际%s", c.value, c.want, got)
+		}
+	}
+}
+
+// WWW-Authenticate中的realm为规则名称，引号和反斜杠需要转义
+func TestTokenErrorRealm(t *testing.T) {
+	cases := []struct {
+		rule   string
+		header string
+	}{
+		{"app", `Bearer realm="app", error="invalid_token", error_description="token_malformed"`},
+		{`a"b`, `Bearer realm="a\"b", error="invalid_token", error_description="token_malformed"`},
+		{`a\", error="x`, `Bearer realm="a\\\", error=\"x", error="invalid_token", error_description="token_malformed"`},
+	}
+	for _, c := range cases {
+		setupTest(t)
+		addRule(t, url.Values{
+			"name":       {c.rule},
+			"authorizer": {`{"type":"JWT_HS256","config":"{\"secret\":\"123456\"}"}`},
+		})
+		resp := request(t, "GET", "/auth", url.Values{"name": {c.rule}, "token": {"abc"}}, nil)
+		if header := http.Header(resp.Header).Get("WWW-Authenticate"); resp.Code != 401 || header != c.header {
+			t.Errorf("%s：期望%s，实际%d %s", c.rule, c.header, resp.Code, header)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"local/authorizer/opaque"
+	"local/global"
+
+	"github.com/dxvgef/filter/v2"
+	"github.com/dxvgef/tsing"
+	"github.com/rs/zerolog/log"
+)
+
+// 吊销授权
+func (self *Auth) Revoke(ctx *tsing.Context) error {
+	var (
+		err      error
+		resp     = make(map[string]string)
+		name     string
+		tokenStr string
+		hint     string
+	)
+	if err = filter.Batch(
+		filter.String(ctx.Query("name"), "name").Require().Set(&name),
+		filter.String(ctx.Query("token"), "token").Require().Set(&tokenStr),
+		filter.String(ctx.Query("token_type_hint"), "token_type_hint").EnumString([]string{"access_token", "refresh_token"}).Set(&hint),
+	); err != nil {
+		resp["error"] = err.Error()
+		return JSON(ctx, 400, &resp)
+	}
+	rule, exists := loadRule(name)
+	if !exists {
+		resp["error"] = "规则不存在"
+		return JSON(ctx, 400, &resp)
+	}
+	revoked, err := revokeToken(rule, tokenStr, hint)
+	if err != nil {
+		resp["error"] = err.Error()
+		return JSON(ctx, 500, &resp)
+	}
+	if !revoked {
+		resp["error"] = "签名验证失败"
+		return JSON(ctx, 400, &resp)
+	}
+	return Status(ctx, 204)
+}
+
+// 按RFC 7009吊销授权，无效的token也视为吊销成功
+func (self *Auth) RevokeToken(ctx *tsing.Context) error {
+	var (
+		err      error
+		resp     = make(map[string]string)
+		name     string
+		tokenStr string
+		hint     string
+	)
+	if err = filter.Batch(
+		filter.String(ctx.Post("name"), "name").Require().Set(&name),
+		filter.String(ctx.Post("token"), "token").Require().Set(&tokenStr),
+		filter.String(ctx.Post("token_type_hint"), "token_type_hint").Set(&hint),
+	); err != nil {
+		resp["error"] = err.Error()
+		return JSON(ctx, 400, &resp)
+	}
+	rule, exists := loadRule(name)
+	if !exists {
+		resp["error"] = "规则不存在"
+		return JSON(ctx, 400, &resp)
+	}
+	if _, err = revokeToken(rule, tokenStr, hint); err != nil {
+		resp["error"] = err.Error()
+		return JSON(ctx, 500, &resp)
+	}
+	return Status(ctx, 200)
+}
+
+// 吊销授权或刷新授权，hint为refresh_token时优先按刷新授权处理，token无效时返回false
+func revokeToken(rule global.Rule, tokenStr, hint string) (revoked bool, err error) {
+	if hint == "refresh_token" {
+		if revoked, err = revokeRefreshToken(rule, tokenStr); revoked || err != nil {
+			return
+		}
+		return revokeAccessToken(rule, tokenStr)
+	}
+	if revoked, err = revokeAccessToken(rule, tokenStr); revoked || err != nil {
+		return
+	}
+	return revokeRefreshToken(rule, tokenStr)
+}
+
+// 吊销授权，不透明授权同时从存储器中删除
+func revokeAccessToken(rule global.Rule, tokenStr string) (bool, error) {
+	// 已过期的不透明授权仍然需要从存储器中删除
+	claims, err := rule.Authorizer.Instance.VeritySign(tokenStr)
+	if err != nil && err != global.ErrTokenExpired {
+		return false, ignoreTokenError(err)
+	}
+	// 仍然加入吊销列表，防止通过刷新授权中保存的授权数据刷新已吊销的不透明授权
+	if err = addRevoked(revocationID(tokenStr, claims.ID), claims.Expires); err != nil {
+		return true, err
+	}
+	if rule.Authorizer.Type == "OPAQUE" {
+		hash := opaque.Hash(tokenStr)
+		if err = global.StorageInstance.DeleteToken(hash); err != nil {
+			log.Err(err).Caller().Send()
+			return true, err
+		}
+		// 不等待存储器的监听事件，立即在本节点生效
+		global.Tokens.Delete(hash)
+	}
+	return true, nil
+}
+
+// 吊销刷新授权
+func revokeRefreshToken(rule global.Rule, tokenStr string) (bool, error) {
+	if rule.Updater.Type == "" {
+		return false, nil
+	}
+	claims, err := rule.Updater.Instance.VeritySign(tokenStr)
+	if err != nil {
+		return false, ignoreTokenError(err)
+	}
+	return true, addRevoked(revocationID(tokenStr, ""), claims.Expires)
+}
+
+// 没有过期时间的授权在吊销列表中保留的时间(秒)，避免吊销列表无限增长
+const revokedLifetime = 365 * 24 * 3600
+
+// 将授权加入吊销列表，已过期的授权无需处理，没有过期时间的授权保留revokedLifetime秒
+func addRevoked(id string, expires int64) error {
+	now := time.Now().Unix()
+	if expires == 0 {
+		expires = now + revokedLifetime
+	} else if expires <= now {
+		return nil
+	}
+	if global.StorageInstance == nil {
+		return errors.New("存储器未初始化")
+	}
+	if err := global.StorageInstance.SaveRevoked(id, expires); err != nil {
+		log.Err(err).Caller().Send()
+		return err
+	}
+	// 不等待存储器的监听事件，立即在本节点生效
+	global.Revoked.Store(id, expires)
+	return nil
+}
+
+// 获得授权在吊销列表中的标识，有jti时使用jti，否则使用token的hash
+func revocationID(tokenStr, jti string) string {
+	if jti != "" {
+		return jti
+	}
+	sum := sha256.Sum256(global.StrToBytes(tokenStr))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,123 @@
+package service
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"local/global"
+)
+
+// 吊销列表的有效期与授权相同，没有过期时间的授权最多保留revokedLifetime秒
+func TestAddRevoked(t *testing.T) {
+	now := time.Now().Unix()
+	cases := []struct {
+		name    string
+		expires int64
+		stored  bool
+		min     int64 // 保存的过期时间范围
+		max     int64
+	}{
+		{"有过期时间", now + 60, true, now + 60, now + 60},
+		{"没有过期时间", 0, true, now + revokedLifetime, now + revokedLifetime + 1},
+		{"已过期", now - 1, false, 0, 0},
+		{"刚好过期", now, false, 0, 0},
+	}
+	for _, c := range cases {
+		storage := setupTest(t)
+		if err := addRevoked("id", c.expires); err != nil {
+			t.Fatal(err)
+		}
+		expires, stored := storage.revoked["id"]
+		if stored != c.stored || global.IsRevoked("id") != c.stored {
+			t.Errorf("%s：吊销状态应为%v", c.name, c.stored)
+			continue
+		}
+		if stored && (expires < c.min || expires > c.max) {
+			t.Errorf("%s：过期时间%d不在%d-%d之间", c.name, expires, c.min, c.max)
+		}
+		if local, _ := global.Revoked.Load("id"); stored && local != expires {
+			t.Errorf("%s：本地的过期时间与存储器不一致：%v", c.name, local)
+		}
+	}
+
+	global.StorageInstance = nil
+	if err := addRevoked("id", 0); err == nil {
+		t.Error("存储器未初始化时应返回错误")
+	}
+}
+
+func TestRevoke(t *testing.T) {
+	cases := []struct {
+		name     string
+		config   string
+		refresh  bool  // 吊销刷新授权
+		lifetime int64 // 吊销列表中的剩余时长
+	}{
+		{"授权", `{\"expires\":60,\"secret\":\"123456\"}`, false, 60},
+		{"没有过期时间的授权", `{\"secret\":\"123456\"}`, false, revokedLifetime},
+		{"刷新授权", `{\"expires\":60,\"secret\":\"123456\"}`, true, 600},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			storage := setupTest(t)
+			addRule(t, url.Values{
+				"name":       {"revoke"},
+				"authorizer": {`{"type":"JWT_HS256","config":"` + c.config + `"}`},
+				"updater":    {`{"type":"JWT_HS256","config":"{\"expires\":600,\"secret\":\"654321\"}"}`},
+			})
+			resp := signToken(t, url.Values{"name": {"revoke"}, "sub": {"bob"}})
+			tokenStr, refreshTokenStr := resp.String("token"), resp.String("refresh_token")
+			target, hint := tokenStr, "access_token"
+			if c.refresh {
+				target, hint = refreshTokenStr, "refresh_token"
+			}
+			now := time.Now().Unix()
+			if resp = request(t, "DELETE", "/auth", url.Values{"name": {"revoke"}, "token": {target}, "token_type_hint": {hint}}, nil); resp.Code != 204 {
+				t.Fatalf("吊销失败：%d %v", resp.Code, resp.Body)
+			}
+			if len(storage.revoked) != 1 {
+				t.Fatalf("吊销列表无效：%v", storage.revoked)
+			}
+			for _, expires := range storage.revoked {
+				if expires < now+c.lifetime || expires > now+c.lifetime+1 {
+					t.Fatalf("吊销列表的过期时间无效：%d", expires-now)
+				}
+			}
+
+			form := url.Values{"name": {"revoke"}, "token": {tokenStr}, "refresh_token": {refreshTokenStr}}
+			if c.refresh {
+				if resp = request(t, "PUT", "/auth", form, nil); resp.Code != 401 || resp.String("code") != "token_revoked" {
+					t.Fatalf("已吊销的刷新授权应刷新失败：%d %v", resp.Code, resp.Body)
+				}
+				return
+			}
+			if resp = request(t, "GET", "/auth", url.Values{"name": {"revoke"}, "token": {tokenStr}}, nil); resp.Code != 401 || resp.String("code") != "token_revoked" {
+				t.Fatalf("已吊销的授权应验证失败：%d %v", resp.Code, resp.Body)
+			}
+			// 已吊销的授权不能刷新
+			if resp = request(t, "PUT", "/auth", form, nil); resp.Code != 401 || resp.String("code") != "token_revoked" {
+				t.Fatalf("已吊销的授权应刷新失败：%d %v", resp.Code, resp.Body)
+			}
+		})
+	}
+}
+
+// 无效的token，DELETE /auth返回400，RFC 7009的/revoke返回200，都不加入吊销列表
+func TestRevokeInvalidToken(t *testing.T) {
+	storage := setupTest(t)
+	addRule(t, url.Values{
+		"name":       {"revoke"},
+		"authorizer": {`{"type":"JWT_HS256","config":"{\"expires\":60,\"secret\":\"123456\"}"}`},
+	})
+	form := url.Values{"name": {"revoke"}, "token": {"abc"}}
+	if resp := request(t, "DELETE", "/auth", form, nil); resp.Code != 400 {
+		t.Fatalf("吊销无效的token应返回400：%d %v", resp.Code, resp.Body)
+	}
+	if resp := request(t, "POST", "/revoke", form, nil); resp.Code != 200 {
+		t.Fatalf("按RFC 7009吊销无效的token应返回200：%d %v", resp.Code, resp.Body)
+	}
+	if len(storage.revoked) != 0 {
+		t.Fatalf("无效的token不应加入吊销列表：%v", storage.revoked)
+	}
+}
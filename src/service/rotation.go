@@ -0,0 +1,236 @@
+package service
+
+import (
+	"encoding/json"
+	"time"
+
+	"local/global"
+	"local/keyutil"
+
+	"github.com/rs/zerolog/log"
+)
+
+// 密钥轮换的检查间隔，同时也是轮换锁的持有时长
+const rotationCheckInterval = time.Minute
+
+// 定时检查并轮换规则的密钥
+func startRotation() {
+	ticker := time.NewTicker(rotationCheckInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		checkRotation()
+	}
+}
+
+// 检查所有规则，轮换到期的密钥并删除超过保留时长的旧密钥
+func checkRotation() {
+	var (
+		names []string
+		now   = time.Now().Unix()
+	)
+	global.Rules.Range(func(_, value interface{}) bool {
+		rule, ok := value.(global.Rule)
+		if !ok {
+			return true
+		}
+		if rotationDue(rule.Authorizer.Rotation, rule.Authorizer.Config, rule.Authorizer.Keys, now) ||
+			rotationDue(rule.Updater.Rotation, rule.Updater.Config, rule.Updater.Keys, now) {
+			names = append(names, rule.Name)
+		}
+		return true
+	})
+	if len(names) == 0 {
+		return
+	}
+	// 多节点部署时只由获得锁的节点执行轮换，锁到期前其它节点已经通过监听同步了轮换结果
+	locked, err := global.StorageInstance.Lock("rotation", int64(rotationCheckInterval/time.Second))
+	if err != nil {
+		log.Err(err).Caller().Msg("获取密钥轮换锁失败")
+		return
+	}
+	if !locked {
+		return
+	}
+	for k := range names {
+		rule, exists := loadRule(names[k])
+		if !exists {
+			continue
+		}
+		if err = rotateRule(rule, now); err != nil {
+			log.Err(err).Caller().Str("rule", names[k]).Msg("密钥轮换失败")
+			continue
+		}
+		log.Info().Str("rule", names[k]).Msg("密钥轮换完成")
+	}
+}
+
+// 按策略轮换规则中授权器和更新器的密钥，有变化时保存到存储器
+func rotateRule(rule global.Rule, now int64) error {
+	var changed bool
+	for _, target := range []string{"authorizer", "updater"} {
+		keys, err := rotateKeys(&rule, target, now, false)
+		if err != nil {
+			return err
+		}
+		if keys == nil {
+			continue
+		}
+		if err = setRuleKeys(&rule, target, keys); err != nil {
+			return err
+		}
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return global.StorageInstance.SaveRule(rule)
+}
+
+// 计算轮换后的密钥集，没有变化时返回nil，force为true时无视策略立即轮换
+func rotateKeys(rule *global.Rule, target string, now int64, force bool) ([]global.Key, error) {
+	var (
+		typ, config string
+		policy      *global.Rotation
+		rotate      = force
+		changed     bool
+	)
+	if target == "updater" {
+		typ, config, policy = rule.Updater.Type, rule.Updater.Config, rule.Updater.Rotation
+	} else {
+		typ, config, policy = rule.Authorizer.Type, rule.Authorizer.Config, rule.Authorizer.Rotation
+	}
+	if typ == "" || (policy == nil && !force) {
+		return nil, nil
+	}
+	oldKeys := ruleKeys(rule, target)
+	if !rotate {
+		rotate = activeKeyDue(policy, oldKeys, now)
+	}
+
+	// 删除超过保留时长的仅验证密钥
+	grace := rotationGrace(policy, config)
+	keys := make([]global.Key, 0, len(oldKeys)+2)
+	for k := range oldKeys {
+		if keyExpired(&oldKeys[k], grace, now) {
+			changed = true
+			continue
+		}
+		keys = append(keys, oldKeys[k])
+	}
+	if !rotate {
+		if changed {
+			return keys, nil
+		}
+		return nil, nil
+	}
+
+	// 首次轮换时，将基础配置做为仅验证密钥保留，使之前签发的授权仍然有效
+	keys, err := withLegacyKey(rule, target, keys, now)
+	if err != nil {
+		return nil, err
+	}
+	key, err := generateKey(target, typ, config, now)
+	if err != nil {
+		return nil, err
+	}
+	keys = append(retireActiveKey(keys, now), key)
+	return keys, nil
+}
+
+// 没有密钥集时，将基础配置做为仅验证密钥加入密钥集，使之前签发的授权仍然有效
+func withLegacyKey(rule *global.Rule, target string, keys []global.Key, now int64) ([]global.Key, error) {
+	if len(keys) > 0 {
+		return keys, nil
+	}
+	legacyID, err := legacyKeyID(rule, target)
+	if err != nil {
+		return nil, err
+	}
+	return append(keys, global.Key{
+		ID:      legacyID,
+		Status:  global.KeyStatusVerify,
+		Created: now,
+		Retired: now,
+	}), nil
+}
+
+// 获得基础配置做为仅验证密钥时的kid，沿用之前签发的授权header中的kid，基础配置没有kid时随机生成
+func legacyKeyID(rule *global.Rule, target string) (string, error) {
+	var (
+		instance interface{} = rule.Authorizer.Instance
+		config               = rule.Authorizer.Config
+	)
+	if target == "updater" {
+		instance, config = rule.Updater.Instance, rule.Updater.Config
+	}
+	if kid := baseKeyID(instance, config); kid != "" {
+		return kid, nil
+	}
+	return keyutil.NewKeyID()
+}
+
+// 获得基础配置签发的授权header中的kid，非对称算法未设置key_id时为公钥的指纹，没有kid时返回空字符串
+func baseKeyID(instance interface{}, config string) string {
+	var base struct {
+		KeyID string `json:"key_id"`
+	}
+	if keyIDInstance, ok := instance.(global.KeyIDInstance); ok && keyIDInstance.ExportKeyID() != "" {
+		return keyIDInstance.ExportKeyID()
+	}
+	if err := json.Unmarshal(global.StrToBytes(config), &base); err == nil {
+		return base.KeyID
+	}
+	return ""
+}
+
+// 判断是否需要轮换密钥或删除旧密钥
+func rotationDue(policy *global.Rotation, config string, keys []global.Key, now int64) bool {
+	if policy == nil {
+		return false
+	}
+	if activeKeyDue(policy, keys, now) {
+		return true
+	}
+	grace := rotationGrace(policy, config)
+	for k := range keys {
+		if keyExpired(&keys[k], grace, now) {
+			return true
+		}
+	}
+	return false
+}
+
+// 判断密钥是否超过了保留时长，只处理由活动密钥转为仅验证的密钥
+func keyExpired(key *global.Key, grace, now int64) bool {
+	return grace > 0 && key.Status == global.KeyStatusVerify && key.Retired > 0 && key.Retired+grace <= now
+}
+
+// 判断活动密钥是否到了轮换时间，没有密钥集时视为到期
+func activeKeyDue(policy *global.Rotation, keys []global.Key, now int64) bool {
+	if policy == nil || policy.Interval <= 0 {
+		return false
+	}
+	for k := range keys {
+		if keys[k].Status == global.KeyStatusActive {
+			return keys[k].Created+policy.Interval <= now
+		}
+	}
+	return true
+}
+
+// 旧密钥的保留时长，未配置时为授权生命周期的2倍，没有轮换策略或授权永不过期时旧密钥永久保留
+func rotationGrace(policy *global.Rotation, config string) int64 {
+	var base struct {
+		Expires int64 `json:"expires"`
+	}
+	if policy == nil {
+		return 0
+	}
+	if policy.Grace > 0 {
+		return policy.Grace
+	}
+	if err := json.Unmarshal(global.StrToBytes(config), &base); err != nil {
+		return 0
+	}
+	return 2 * base.Expires
+}
@@ -0,0 +1,176 @@
+package service
+
+import (
+	"net/url"
+	"testing"
+
+	"local/global"
+	"local/keyutil"
+)
+
+func TestKeyExpired(t *testing.T) {
+	cases := []struct {
+		name  string
+		key   global.Key
+		grace int64
+		want  bool
+	}{
+		{"超过保留时长", global.Key{Status: global.KeyStatusVerify, Retired: 100}, 50, true},
+		{"刚好到期", global.Key{Status: global.KeyStatusVerify, Retired: 150}, 50, true},
+		{"未到期", global.Key{Status: global.KeyStatusVerify, Retired: 160}, 50, false},
+		{"永久保留", global.Key{Status: global.KeyStatusVerify, Retired: 100}, 0, false},
+		{"活动密钥", global.Key{Status: global.KeyStatusActive, Retired: 100}, 50, false},
+		{"手动添加的仅验证密钥", global.Key{Status: global.KeyStatusVerify}, 50, false},
+	}
+	for _, c := range cases {
+		if got := keyExpired(&c.key, c.grace, 200); got != c.want {
+			t.Errorf("%s：期望%v，实际%v", c.name, c.want, got)
+		}
+	}
+}
+
+func TestActiveKeyDue(t *testing.T) {
+	policy := &global.Rotation{Interval: 100}
+	cases := []struct {
+		name   string
+		policy *global.Rotation
+		keys   []global.Key
+		want   bool
+	}{
+		{"没有策略", nil, nil, false},
+		{"间隔为0", &global.Rotation{}, nil, false},
+		{"没有密钥集", policy, nil, true},
+		{"活动密钥到期", policy, []global.Key{{Status: global.KeyStatusVerify, Created: 500}, {Status: global.KeyStatusActive, Created: 100}}, true},
+		{"活动密钥未到期", policy, []global.Key{{Status: global.KeyStatusActive, Created: 150}}, false},
+		{"只有仅验证密钥", policy, []global.Key{{Status: global.KeyStatusVerify, Created: 150}}, true},
+	}
+	for _, c := range cases {
+		if got := activeKeyDue(c.policy, c.keys, 200); got != c.want {
+			t.Errorf("%s：期望%v，实际%v", c.name, c.want, got)
+		}
+	}
+}
+
+func TestRotationGrace(t *testing.T) {
+	cases := []struct {
+		name   string
+		policy *global.Rotation
+		config string
+		want   int64
+	}{
+		{"没有策略", nil, `{"expires":60}`, 0},
+		{"指定保留时长", &global.Rotation{Interval: 10, Grace: 30}, `{"expires":60}`, 30},
+		{"授权生命周期的2倍", &global.Rotation{Interval: 10}, `{"expires":60}`, 120},
+		{"授权永不过期", &global.Rotation{Interval: 10}, `{}`, 0},
+		{"无效的配置", &global.Rotation{Interval: 10}, `[]`, 0},
+	}
+	for _, c := range cases {
+		if got := rotationGrace(c.policy, c.config); got != c.want {
+			t.Errorf("%s：期望%d，实际%d", c.name, c.want, got)
+		}
+	}
+}
+
+func TestRotateKeys(t *testing.T) {
+	setupTest(t)
+	addRule(t, url.Values{
+		"name":       {"rotate"},
+		"authorizer": {`{"type":"JWT_HS256","config":"{\"expires\":60,\"secret\":\"123456\"}","rotation":{"interval":100,"grace":50}}`},
+	})
+	rule, _ := loadRule("rotate")
+
+	// 未到期且没有过期的旧密钥时不变化
+	rule.Authorizer.Keys = []global.Key{{ID: "a", Status: global.KeyStatusActive, Created: 150}}
+	if keys, err := rotateKeys(&rule, "authorizer", 200, false); err != nil || keys != nil {
+		t.Fatalf("不应轮换：%v %v", keys, err)
+	}
+
+	// 只删除超过保留时长的旧密钥
+	rule.Authorizer.Keys = []global.Key{
+		{ID: "old", Status: global.KeyStatusVerify, Created: 0, Retired: 100},
+		{ID: "a", Status: global.KeyStatusActive, Created: 150},
+	}
+	keys, err := rotateKeys(&rule, "authorizer", 200, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0].ID != "a" || keys[0].Status != global.KeyStatusActive {
+		t.Fatalf("应只删除过期的旧密钥：%v", keys)
+	}
+
+	// 活动密钥到期时生成新的活动密钥，原活动密钥转为仅验证
+	rule.Authorizer.Keys = []global.Key{{ID: "a", Status: global.KeyStatusActive, Created: 50}}
+	if keys, err = rotateKeys(&rule, "authorizer", 200, false); err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0].Status != global.KeyStatusVerify || keys[0].Retired != 200 ||
+		keys[1].Status != global.KeyStatusActive || keys[1].Created != 200 || keys[1].Config == "" {
+		t.Fatalf("轮换后的密钥集无效：%v", keys)
+	}
+	if err = keyutil.CheckKeys(keys); err != nil {
+		t.Fatal(err)
+	}
+
+	// 没有策略时只有强制轮换才生成新密钥
+	rule.Authorizer.Rotation = nil
+	if keys, err = rotateKeys(&rule, "authorizer", 200, false); err != nil || keys != nil {
+		t.Fatalf("没有策略时不应轮换：%v %v", keys, err)
+	}
+	if _, err = rotateKeys(&rule, "updater", 200, true); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 首次轮换或添加首个密钥时，基础配置保留为仅验证密钥，之前签发的授权仍然有效
+func TestLegacyKey(t *testing.T) {
+	cases := []struct {
+		name    string
+		config  string
+		path    string
+		form    url.Values
+		keyID   string
+		checkID bool
+	}{
+		{"轮换HMAC", `{"expires":60,"secret":"123456","key_id":"base"}`, "/rotate", url.Values{"target": {"authorizer"}}, "base", true},
+		{"轮换没有kid的HMAC", `{"expires":60,"secret":"123456"}`, "/rotate", url.Values{"target": {"authorizer"}}, "", false},
+		{"添加首个密钥", `{"expires":60,"secret":"123456","key_id":"base"}`, "/keys", url.Values{"target": {"authorizer"}, "kid": {"new"}, "config": {`{"secret":"654321"}`}}, "base", true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			setupTest(t)
+			addRule(t, url.Values{
+				"name":       {"legacy"},
+				"authorizer": {`{"type":"JWT_HS256","config":` + quoteJSON(c.config) + `}`},
+			})
+			token := signToken(t, url.Values{"name": {"legacy"}, "sub": {"bob"}}).String("token")
+			if c.checkID && keyutil.TokenKeyID(token) != c.keyID {
+				t.Fatalf("基础配置签发的授权的kid无效：%s", keyutil.TokenKeyID(token))
+			}
+
+			if resp := request(t, "POST", "/rule/"+global.EncodeKey("legacy")+c.path, c.form, nil); resp.Code != 200 {
+				t.Fatalf("添加密钥失败：%d %v", resp.Code, resp.Body)
+			}
+			rule, _ := loadRule("legacy")
+			keys := rule.Authorizer.Keys
+			if len(keys) != 2 || keys[0].Status != global.KeyStatusVerify || keys[1].Status != global.KeyStatusActive {
+				t.Fatalf("基础配置没有保留为仅验证密钥：%v", keys)
+			}
+			if c.checkID && keys[0].ID != c.keyID {
+				t.Fatalf("仅验证密钥的kid应沿用基础配置的key_id：%s", keys[0].ID)
+			}
+
+			// 之前签发的授权仍然有效
+			if resp := request(t, "GET", "/auth", url.Values{"name": {"legacy"}, "token": {token}}, nil); resp.Code != 200 {
+				t.Fatalf("之前签发的授权验证失败：%d %v", resp.Code, resp.Body)
+			}
+			// 新签发的授权使用新的活动密钥
+			token = signToken(t, url.Values{"name": {"legacy"}, "sub": {"bob"}}).String("token")
+			if keyutil.TokenKeyID(token) != keys[1].ID {
+				t.Fatalf("新签发的授权未使用活动密钥：%s", keyutil.TokenKeyID(token))
+			}
+			if resp := request(t, "GET", "/auth", url.Values{"name": {"legacy"}, "token": {token}}, nil); resp.Code != 200 {
+				t.Fatalf("新签发的授权验证失败：%d %v", resp.Code, resp.Body)
+			}
+		})
+	}
+}
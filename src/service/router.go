@@ -1,24 +1,76 @@
 package service
 
 func setRouter() {
+	// 公开的JWKS，不检查secret
+	var jwksHandler JWKS
+	engine.GET("/.well-known/jwks.json", jwksHandler.All)   // 所有规则的公钥
+	engine.GET("/.well-known/jwks/:name", jwksHandler.Rule) // 单个规则的公钥
+
+	// OAuth 2.0授权端点和令牌端点，使用客户端的身份验证，不检查secret
+	var oauthHandler OAuth
+	engine.GET("/oauth/authorize", oauthHandler.Authorize) // 授权码模式的授权请求
+	engine.POST("/oauth/token", oauthHandler.Token)        // 签发授权
+
+	// OpenID Connect，不检查secret
+	var oidcHandler OIDC
+	engine.GET("/.well-known/openid-configuration", oidcHandler.Discovery)                // 签发者为服务地址的规则的发现文档
+	engine.GET("/oidc/:name/.well-known/openid-configuration", oidcHandler.RuleDiscovery) // 单个规则的发现文档
+	engine.GET("/userinfo", oidcHandler.UserInfo)                                         // 用户信息
+	engine.POST("/userinfo", oidcHandler.UserInfo)
+
 	// 检查secret
 	router := engine.Group("", CheckSecret)
 
 	// 数据管理
 	var dataHandler Data
-	router.GET("/data/", dataHandler.OutputJSON) // 输出所有配置
-	router.POST("/data/", dataHandler.LoadAll)   // 从存储器加载所有配置
-	router.PUT("/data/", dataHandler.SaveAll)    // 将所有配置保存到存储器
+	router.GET("/data/", dataHandler.OutputJSON)       // 输出所有配置
+	router.POST("/data/", dataHandler.LoadAll)         // 从存储器加载所有配置
+	router.PUT("/data/", dataHandler.SaveAll)          // 将所有配置保存到存储器
+	router.PUT("/data/encrypt", dataHandler.ReEncrypt) // 重新加载主密钥并重新加密所有规则
 
 	// 规则管理
 	var ruleHandler Rule
-	router.POST("/rule/", ruleHandler.Add)           // 添加
-	router.PUT("/rule/:name", ruleHandler.Put)       // 添加或更新
-	router.DELETE("/rule/:name", ruleHandler.Delete) // 删除规则
+	router.POST("/rule/", ruleHandler.Add)                                // 添加
+	router.PUT("/rule/:name", ruleHandler.Put)                            // 添加或更新
+	router.DELETE("/rule/:name", ruleHandler.Delete)                      // 删除规则
+	router.GET("/rule/:name/public_key", ruleHandler.PublicKey)           // 获取公钥
+	router.POST("/rule/:name/policies/dry_run", ruleHandler.DryRunPolicy) // 试运行策略
+
+	// 密钥管理
+	var keyHandler Key
+	router.GET("/rule/:name/keys", keyHandler.List)           // 列出密钥
+	router.POST("/rule/:name/keys", keyHandler.Add)           // 添加密钥
+	router.PUT("/rule/:name/keys/:kid", keyHandler.Promote)   // 设为活动密钥
+	router.DELETE("/rule/:name/keys/:kid", keyHandler.Delete) // 删除密钥
+	router.POST("/rule/:name/rotate", keyHandler.Rotate)      // 立即轮换密钥
 
 	// 授权管理
 	var authHandler Auth
-	router.POST("/auth", authHandler.Sign)   // 生成授权
-	router.PUT("/auth", authHandler.Refresh) // 刷新授权
-	router.GET("/auth", authHandler.Verity)  // 验证授权
+	router.POST("/auth", authHandler.Sign)             // 生成授权
+	router.PUT("/auth", authHandler.Refresh)           // 刷新授权
+	router.GET("/auth", authHandler.Verity)            // 验证授权
+	router.DELETE("/auth", authHandler.Revoke)         // 吊销授权
+	router.POST("/revoke", authHandler.RevokeToken)    // 吊销授权(RFC 7009)
+	router.POST("/introspect", authHandler.Introspect) // 授权自省(RFC 7662)
+	router.GET("/authorize", authHandler.Authorize)    // 验证授权并检查权限
+
+	// 权限、角色和主体管理
+	var rbacHandler RBAC
+	router.GET("/rbac/permissions", rbacHandler.ListPermissions)           // 列出权限
+	router.PUT("/rbac/permissions/:name", rbacHandler.PutPermission)       // 添加或更新权限
+	router.DELETE("/rbac/permissions/:name", rbacHandler.DeletePermission) // 删除权限
+	router.GET("/rbac/roles", rbacHandler.ListRoles)                       // 列出角色
+	router.PUT("/rbac/roles/:name", rbacHandler.PutRole)                   // 添加或更新角色
+	router.DELETE("/rbac/roles/:name", rbacHandler.DeleteRole)             // 删除角色
+	router.GET("/rbac/subjects/:rule/:name", rbacHandler.GetSubject)       // 获取主体的角色和权限
+	router.PUT("/rbac/subjects/:rule/:name", rbacHandler.PutSubject)       // 设置主体的角色
+	router.DELETE("/rbac/subjects/:rule/:name", rbacHandler.DeleteSubject) // 删除主体的角色
+
+	// OAuth客户端管理
+	var clientHandler Client
+	router.GET("/oauth/clients", clientHandler.List)                    // 列出客户端
+	router.POST("/oauth/clients", clientHandler.Add)                    // 添加客户端
+	router.PUT("/oauth/clients/:id", clientHandler.Put)                 // 更新客户端
+	router.POST("/oauth/clients/:id/secret", clientHandler.ResetSecret) // 重新生成client_secret
+	router.DELETE("/oauth/clients/:id", clientHandler.Delete)           // 删除客户端
 }
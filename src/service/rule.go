@@ -2,9 +2,15 @@ package service
 
 import (
 	"encoding/json"
+	"errors"
 	"local/authorizer"
+	"local/claimset"
 	"local/global"
+	"local/keyutil"
+	"local/policy"
 	"local/updater"
+	"strings"
+	"time"
 
 	"github.com/dxvgef/filter/v2"
 	"github.com/dxvgef/tsing"
@@ -21,11 +27,23 @@ func (self *Rule) Add(ctx *tsing.Context) error {
 		resp                            = make(map[string]string)
 		rule                            global.Rule
 		authorizerConfig, updaterConfig string
+		claimsStr, sessionStr, scopes   string
+		policiesStr                     string
+		generateKey                     bool
+		generated                       = make(map[string]map[string]string)
 	)
 	if err = filter.Batch(
 		filter.String(ctx.Post("name"), "name").Require().Set(&rule.Name),
 		filter.String(ctx.Post("authorizer"), "authorizer").Require().IsJSON().Set(&authorizerConfig),
 		filter.String(ctx.Post("updater"), "updater").IsJSON().Set(&updaterConfig),
+		filter.String(ctx.Post("issuer"), "issuer").Set(&rule.Issuer),
+		filter.String(ctx.Post("claims"), "claims").IsJSON().Set(&claimsStr),
+		filter.String(ctx.Post("session"), "session").IsJSON().Set(&sessionStr),
+		filter.String(ctx.Post("refresh_hook"), "refresh_hook").IsURL().Set(&rule.RefreshHook),
+		filter.String(ctx.Post("login_hook"), "login_hook").IsURL().Set(&rule.LoginHook),
+		filter.String(ctx.Post("scopes"), "scopes").Set(&scopes),
+		filter.String(ctx.Post("policies"), "policies").IsJSON().Set(&policiesStr),
+		filter.String(ctx.Post("generate_key"), "generate_key").IsBool().Set(&generateKey),
 	); err != nil {
 		resp["error"] = err.Error()
 		return JSON(ctx, 400, &resp)
@@ -35,13 +53,42 @@ func (self *Rule) Add(ctx *tsing.Context) error {
 		resp["error"] = "规则已存在"
 		return JSON(ctx, 400, &resp)
 	}
+	// 解析默认的自定义claims
+	if rule.Claims, err = claimset.Parse(claimsStr); err != nil {
+		resp["error"] = err.Error()
+		return JSON(ctx, 400, &resp)
+	}
+	// 解析会话策略
+	if rule.Session, err = parseSession(sessionStr); err != nil {
+		resp["error"] = err.Error()
+		return JSON(ctx, 400, &resp)
+	}
+	// 解析允许签发的scope
+	if rule.Scopes, err = parseRuleScopes(scopes); err != nil {
+		resp["error"] = err.Error()
+		return JSON(ctx, 400, &resp)
+	}
+	// 解析并编译策略
+	if rule.Policies, err = parsePolicies(policiesStr); err != nil {
+		resp["error"] = err.Error()
+		return JSON(ctx, 400, &resp)
+	}
 	// 解析授权器配置
 	if err = json.Unmarshal(global.StrToBytes(authorizerConfig), &rule.Authorizer); err != nil {
 		log.Err(err).Caller().Msg("解析authorizer配置失败")
 		return err
 	}
+	// 类型名称不区分大小写，统一保存为大写
+	rule.Authorizer.Type = strings.ToUpper(rule.Authorizer.Type)
+	// 由服务端生成授权器的密钥
+	if generateKey {
+		if err = setGeneratedKey(&rule, "authorizer"); err != nil {
+			resp["error"] = err.Error()
+			return JSON(ctx, 400, &resp)
+		}
+	}
 	// 构建授权器实例
-	rule.Authorizer.Instance, err = authorizer.Build(rule.Authorizer.Type, rule.Authorizer.Config)
+	rule.Authorizer.Instance, err = authorizer.BuildWithKeys(rule.Authorizer.Type, rule.Authorizer.Config, rule.Authorizer.Keys)
 	if err != nil {
 		log.Err(err).Caller().Msg("构建authorizer实例失败")
 		return err
@@ -52,9 +99,17 @@ func (self *Rule) Add(ctx *tsing.Context) error {
 			log.Err(err).Caller().Msg("解析updater配置失败")
 			return err
 		}
+		rule.Updater.Type = strings.ToUpper(rule.Updater.Type)
 		if rule.Updater.Type != "" {
+			// 由服务端生成更新器的密钥
+			if generateKey {
+				if err = setGeneratedKey(&rule, "updater"); err != nil {
+					resp["error"] = err.Error()
+					return JSON(ctx, 400, &resp)
+				}
+			}
 			// 构建授权器实例
-			rule.Updater.Instance, err = updater.Build(rule.Updater.Type, rule.Updater.Config)
+			rule.Updater.Instance, err = updater.BuildWithKeys(rule.Updater.Type, rule.Updater.Config, rule.Updater.Keys)
 			if err != nil {
 				log.Err(err).Caller().Msg("构建updater实例失败")
 				return err
@@ -67,6 +122,20 @@ func (self *Rule) Add(ctx *tsing.Context) error {
 		resp["error"] = err.Error()
 		return JSON(ctx, 500, &resp)
 	}
+	// 只返回生成的密钥的kid和公钥，不返回私钥
+	if generateKey {
+		if generated["authorizer"], err = generatedKeyInfo(rule.Authorizer.Keys, rule.Authorizer.Instance); err != nil {
+			log.Err(err).Caller().Send()
+			return err
+		}
+		if rule.Updater.Type != "" {
+			if generated["updater"], err = generatedKeyInfo(rule.Updater.Keys, rule.Updater.Instance); err != nil {
+				log.Err(err).Caller().Send()
+				return err
+			}
+		}
+		return JSON(ctx, 200, &generated)
+	}
 	return Status(ctx, 204)
 }
 
@@ -77,22 +146,53 @@ func (self *Rule) Put(ctx *tsing.Context) error {
 		resp                            = make(map[string]string)
 		rule                            global.Rule
 		authorizerConfig, updaterConfig string
+		claimsStr, sessionStr, scopes   string
+		policiesStr                     string
 	)
 	if err = filter.Batch(
 		filter.String(ctx.PathParams.Value("name"), "name").Require().Base64RawURLDecode().Set(&rule.Name),
 		filter.String(ctx.Post("authorizer"), "authorizer").Require().IsJSON().Set(&authorizerConfig),
 		filter.String(ctx.Post("updater"), "updater").IsJSON().Set(&updaterConfig),
+		filter.String(ctx.Post("issuer"), "issuer").Set(&rule.Issuer),
+		filter.String(ctx.Post("claims"), "claims").IsJSON().Set(&claimsStr),
+		filter.String(ctx.Post("session"), "session").IsJSON().Set(&sessionStr),
+		filter.String(ctx.Post("refresh_hook"), "refresh_hook").IsURL().Set(&rule.RefreshHook),
+		filter.String(ctx.Post("login_hook"), "login_hook").IsURL().Set(&rule.LoginHook),
+		filter.String(ctx.Post("scopes"), "scopes").Set(&scopes),
+		filter.String(ctx.Post("policies"), "policies").IsJSON().Set(&policiesStr),
 	); err != nil {
 		resp["error"] = err.Error()
 		return JSON(ctx, 400, &resp)
 	}
+	// 解析默认的自定义claims
+	if rule.Claims, err = claimset.Parse(claimsStr); err != nil {
+		resp["error"] = err.Error()
+		return JSON(ctx, 400, &resp)
+	}
+	// 解析会话策略
+	if rule.Session, err = parseSession(sessionStr); err != nil {
+		resp["error"] = err.Error()
+		return JSON(ctx, 400, &resp)
+	}
+	// 解析允许签发的scope
+	if rule.Scopes, err = parseRuleScopes(scopes); err != nil {
+		resp["error"] = err.Error()
+		return JSON(ctx, 400, &resp)
+	}
+	// 解析并编译策略
+	if rule.Policies, err = parsePolicies(policiesStr); err != nil {
+		resp["error"] = err.Error()
+		return JSON(ctx, 400, &resp)
+	}
 	// 解析授权器配置
 	if err = json.Unmarshal(global.StrToBytes(authorizerConfig), &rule.Authorizer); err != nil {
 		log.Err(err).Caller().Msg("解析authorizer配置失败")
 		return err
 	}
+	// 类型名称不区分大小写，统一保存为大写
+	rule.Authorizer.Type = strings.ToUpper(rule.Authorizer.Type)
 	// 构建授权器实例
-	rule.Authorizer.Instance, err = authorizer.Build(rule.Authorizer.Type, rule.Authorizer.Config)
+	rule.Authorizer.Instance, err = authorizer.BuildWithKeys(rule.Authorizer.Type, rule.Authorizer.Config, rule.Authorizer.Keys)
 	if err != nil {
 		log.Err(err).Caller().Msg("构建authorizer实例失败")
 		return err
@@ -103,9 +203,10 @@ func (self *Rule) Put(ctx *tsing.Context) error {
 			log.Err(err).Caller().Msg("解析updater配置失败")
 			return err
 		}
+		rule.Updater.Type = strings.ToUpper(rule.Updater.Type)
 		if rule.Updater.Type != "" {
 			// 构建授权器实例
-			rule.Updater.Instance, err = updater.Build(rule.Updater.Type, rule.Updater.Config)
+			rule.Updater.Instance, err = updater.BuildWithKeys(rule.Updater.Type, rule.Updater.Config, rule.Updater.Keys)
 			if err != nil {
 				log.Err(err).Caller().Msg("构建updater实例失败")
 				return err
@@ -145,3 +246,128 @@ func (self *Rule) Delete(ctx *tsing.Context) error {
 	}
 	return Status(ctx, 204)
 }
+
+// 获取规则的公钥，只有使用非对称算法的授权器和更新器才有公钥
+func (self *Rule) PublicKey(ctx *tsing.Context) error {
+	var (
+		err  error
+		resp = make(map[string]string)
+		name string
+	)
+	name, err = filter.String(ctx.PathParams.Value("name"), "name").Require().Base64RawURLDecode().String()
+	if err != nil {
+		resp["error"] = err.Error()
+		return JSON(ctx, 400, &resp)
+	}
+	// 判断规则是否存在
+	value, exists := global.Rules.Load(name)
+	if !exists {
+		resp["error"] = "规则不存在"
+		return JSON(ctx, 404, &resp)
+	}
+	rule, ok := value.(global.Rule)
+	if !ok {
+		return errors.New("规则类型断言失败")
+	}
+	if instance, ok := rule.Authorizer.Instance.(global.PublicKeyInstance); ok && instance.ExportPublicKey() != nil {
+		if resp["authorizer"], err = keyutil.PublicKeyToBase64(instance.ExportPublicKey()); err != nil {
+			log.Err(err).Caller().Send()
+			return err
+		}
+	}
+	if instance, ok := rule.Updater.Instance.(global.PublicKeyInstance); ok && instance.ExportPublicKey() != nil {
+		if resp["updater"], err = keyutil.PublicKeyToBase64(instance.ExportPublicKey()); err != nil {
+			log.Err(err).Caller().Send()
+			return err
+		}
+	}
+	if len(resp) == 0 {
+		resp["error"] = "规则未使用非对称算法"
+		return JSON(ctx, 400, &resp)
+	}
+	return JSON(ctx, 200, &resp)
+}
+
+// 密钥和证书字段，生成密钥时基础配置中不能包含
+var keyMaterialFields = []string{"private_key", "public_key", "certificate", "x5c", "x5t", "secret", "key", "iv"}
+
+// 为规则的授权器或更新器生成密钥，做为唯一的活动密钥
+func setGeneratedKey(rule *global.Rule, target string) error {
+	var typ, config string
+	var keys []global.Key
+	if target == "updater" {
+		typ, config, keys = rule.Updater.Type, rule.Updater.Config, rule.Updater.Keys
+	} else {
+		typ, config, keys = rule.Authorizer.Type, rule.Authorizer.Config, rule.Authorizer.Keys
+	}
+	if len(keys) > 0 {
+		return errors.New("生成密钥时不能传入keys")
+	}
+	// 基础配置中的密钥和证书与生成的密钥不匹配
+	if config != "" {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(global.StrToBytes(config), &fields); err != nil {
+			return errors.New(target + "的config必须是JSON对象")
+		}
+		for k := range keyMaterialFields {
+			if _, exists := fields[keyMaterialFields[k]]; exists {
+				return errors.New("生成密钥时" + target + "的config中不能包含" + keyMaterialFields[k])
+			}
+		}
+	}
+	key, err := generateKey(target, typ, config, time.Now().Unix())
+	if err != nil {
+		return err
+	}
+	if target == "updater" {
+		rule.Updater.Keys = []global.Key{key}
+	} else {
+		rule.Authorizer.Keys = []global.Key{key}
+	}
+	return nil
+}
+
+// 获得生成的密钥的kid和公钥，对称算法只有kid
+func generatedKeyInfo(keys []global.Key, instance interface{}) (map[string]string, error) {
+	var err error
+	info := make(map[string]string, 2)
+	if len(keys) > 0 {
+		info["kid"] = keys[0].ID
+	}
+	if publicKeyInstance, ok := instance.(global.PublicKeyInstance); ok && publicKeyInstance.ExportPublicKey() != nil {
+		if info["public_key"], err = keyutil.PublicKeyToBase64(publicKeyInstance.ExportPublicKey()); err != nil {
+			return nil, err
+		}
+	}
+	return info, nil
+}
+
+// 解析会话策略，为空时返回nil
+func parseSession(value string) (*global.Session, error) {
+	if value == "" {
+		return nil, nil
+	}
+	var session global.Session
+	if err := json.Unmarshal(global.StrToBytes(value), &session); err != nil {
+		return nil, errors.New("session必须是JSON对象")
+	}
+	if session.Lifetime < 0 || session.MaxRefresh < 0 {
+		return nil, errors.New("session的lifetime和max_refresh不能小于0")
+	}
+	return &session, nil
+}
+
+// 解析JSON数组格式的策略并编译，为空时返回nil
+func parsePolicies(value string) ([]global.Policy, error) {
+	if value == "" {
+		return nil, nil
+	}
+	var policies []global.Policy
+	if err := json.Unmarshal(global.StrToBytes(value), &policies); err != nil {
+		return nil, errors.New("policies必须是JSON数组")
+	}
+	if err := policy.Build(policies); err != nil {
+		return nil, err
+	}
+	return policies, nil
+}
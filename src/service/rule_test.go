@@ -0,0 +1,41 @@
+package service
+
+import (
+	"net/url"
+	"testing"
+
+	"local/global"
+)
+
+// 删除规则时同时删除其主体，不影响编码后的名称以其为前缀的规则
+func TestDeleteRule(t *testing.T) {
+	setupTest(t)
+	for _, name := range []string{"abc", "abcd"} {
+		addRule(t, url.Values{
+			"name":       {name},
+			"authorizer": {`{"type":"JWT_HS256","config":"{\"secret\":\"123456\"}"}`},
+		})
+		grantPermission(t, name, "bob", false, "read")
+		grantPermission(t, name, "svc", true, "read")
+	}
+	if resp := request(t, "DELETE", "/rule/"+global.EncodeKey("abc"), nil, nil); resp.Code != 204 {
+		t.Fatalf("删除规则失败：%d %v", resp.Code, resp.Body)
+	}
+	cases := []struct {
+		subject global.Subject
+		exists  bool
+	}{
+		{global.Subject{Rule: "abc", Name: "bob"}, false},
+		{global.Subject{Rule: "abc", Name: "svc", Client: true}, false},
+		{global.Subject{Rule: "abcd", Name: "bob"}, true},
+		{global.Subject{Rule: "abcd", Name: "svc", Client: true}, true},
+	}
+	for _, c := range cases {
+		if _, exists := global.Subjects.Load(c.subject.Key()); exists != c.exists {
+			t.Errorf("%s：主体是否存在应为%v", c.subject.Key(), c.exists)
+		}
+	}
+	if _, exists := loadRule("abc"); exists {
+		t.Error("规则没有删除")
+	}
+}
@@ -0,0 +1,75 @@
+package service
+
+import (
+	"errors"
+	"strings"
+)
+
+// 解析以逗号或空格分隔的scope，去除重复的scope，为空时返回nil
+func parseScopes(value string) []string {
+	var scopes []string
+	for _, scope := range strings.FieldsFunc(value, isScopeSeparator) {
+		if !containsString(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
+
+// scope的分隔符
+func isScopeSeparator(r rune) bool {
+	return r == ',' || r == ' '
+}
+
+// 解析规则允许签发的scope，scope中不能包含引号和反斜杠
+func parseRuleScopes(value string) ([]string, error) {
+	scopes := parseScopes(value)
+	for k := range scopes {
+		if strings.ContainsAny(scopes[k], "\"\\") {
+			return nil, errors.New("scope不能包含引号和反斜杠：" + scopes[k])
+		}
+	}
+	return scopes, nil
+}
+
+// 校验请求的scope是否都在规则允许签发的scope中，返回以空格分隔的scope
+func checkScopes(allowed, requested []string) (string, error) {
+	for k := range requested {
+		if !containsString(allowed, requested[k]) {
+			return "", errors.New("规则不允许签发的scope：" + requested[k])
+		}
+	}
+	return strings.Join(requested, " "), nil
+}
+
+// 从授权的scope中去除规则已不允许签发的scope
+func allowedScopes(allowed []string, scope string) string {
+	var scopes []string
+	for _, s := range strings.Fields(scope) {
+		if containsString(allowed, s) {
+			scopes = append(scopes, s)
+		}
+	}
+	return strings.Join(scopes, " ")
+}
+
+// 判断授权的scope是否包含所有要求的scope
+func hasScopes(scope string, required []string) bool {
+	granted := strings.Fields(scope)
+	for k := range required {
+		if !containsString(granted, required[k]) {
+			return false
+		}
+	}
+	return true
+}
+
+// 判断字符串切片中是否包含指定的字符串
+func containsString(values []string, value string) bool {
+	for k := range values {
+		if values[k] == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,139 @@
+package service
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+
+	"local/global"
+)
+
+func TestParseScopes(t *testing.T) {
+	cases := []struct {
+		value string
+		want  []string
+	}{
+		{"", nil},
+		{" , ", nil},
+		{"read", []string{"read"}},
+		{"read,write", []string{"read", "write"}},
+		{"read write", []string{"read", "write"}},
+		{"read, write ,read", []string{"read", "write"}},
+	}
+	for _, c := range cases {
+		if got := parseScopes(c.value); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%q：期望%v，实际%v", c.value, c.want, got)
+		}
+	}
+	if _, err := parseRuleScopes(`read,"write"`); err == nil {
+		t.Error("规则的scope不能包含引号")
+	}
+}
+
+func TestCheckScopes(t *testing.T) {
+	allowed := []string{"read", "write"}
+	cases := []struct {
+		requested []string
+		scope     string
+		err       bool
+	}{
+		{nil, "", false},
+		{[]string{"read"}, "read", false},
+		{[]string{"write", "read"}, "write read", false},
+		{[]string{"read", "admin"}, "", true},
+	}
+	for _, c := range cases {
+		scope, err := checkScopes(allowed, c.requested)
+		if scope != c.scope || (err != nil) != c.err {
+			t.Errorf("%v：期望%q %v，实际%q %v", c.requested, c.scope, c.err, scope, err)
+		}
+	}
+}
+
+func TestHasScopes(t *testing.T) {
+	cases := []struct {
+		scope    string
+		required []string
+		want     bool
+	}{
+		{"", nil, true},
+		{"read write", nil, true},
+		{"read write", []string{"read"}, true},
+		{"read write", []string{"write", "read"}, true},
+		{"read write", []string{"admin"}, false},
+		{"read write", []string{"read", "admin"}, false},
+		{"", []string{"read"}, false},
+		// 不按前缀匹配
+		{"readonly", []string{"read"}, false},
+	}
+	for _, c := range cases {
+		if got := hasScopes(c.scope, c.required); got != c.want {
+			t.Errorf("%q %v：期望%v，实际%v", c.scope, c.required, c.want, got)
+		}
+	}
+}
+
+// 签发时只接受规则允许的scope，验证时授权必须包含所有要求的scope
+func TestVerityScope(t *testing.T) {
+	setupTest(t)
+	form := url.Values{
+		"name":       {"scope"},
+		"scopes":     {"read,write,admin"},
+		"authorizer": {`{"type":"JWT_HS256","config":"{\"expires\":60,\"secret\":\"123456\"}"}`},
+		"updater":    {`{"type":"JWT_HS256","config":"{\"expires\":600,\"secret\":\"654321\"}"}`},
+	}
+	addRule(t, form)
+	if resp := request(t, "POST", "/auth", url.Values{"name": {"scope"}, "sub": {"bob"}, "scope": {"read,delete"}}, nil); resp.Code != 400 {
+		t.Fatalf("签发规则不允许的scope应返回400：%d %v", resp.Code, resp.Body)
+	}
+	resp := signToken(t, url.Values{"name": {"scope"}, "sub": {"bob"}, "scope": {"read,write"}})
+	tokenStr, refreshTokenStr := resp.String("token"), resp.String("refresh_token")
+
+	cases := []struct {
+		name  string
+		scope string
+		code  int
+	}{
+		{"不要求scope", "", 200},
+		{"包含要求的scope", "read", 200},
+		{"逗号分隔", "read,write", 200},
+		{"空格分隔", "write read", 200},
+		{"缺少scope", "admin", 403},
+		{"缺少部分scope", "read,admin", 403},
+	}
+	for _, c := range cases {
+		resp = request(t, "GET", "/auth", url.Values{"name": {"scope"}, "token": {tokenStr}, "scope": {c.scope}}, nil)
+		if resp.Code != c.code {
+			t.Errorf("%s：期望%d，实际%d %v", c.name, c.code, resp.Code, resp.Body)
+			continue
+		}
+		if c.code == 200 && resp.String("scope") != "read write" {
+			t.Errorf("%s：授权的scope无效：%v", c.name, resp.Body["scope"])
+		}
+		if c.code == 403 {
+			if resp.String("code") != "insufficient_scope" {
+				t.Errorf("%s：错误代码无效：%v", c.name, resp.Body)
+			}
+			if header := resp.Header["Www-Authenticate"]; len(header) == 0 || header[0] != `Bearer realm="scope", error="insufficient_scope", error_description="insufficient_scope"` {
+				t.Errorf("%s：WWW-Authenticate无效：%v", c.name, header)
+			}
+		}
+	}
+
+	// 规则不再允许的scope在刷新时被去除
+	form.Set("scopes", "read")
+	if resp = request(t, "PUT", "/rule/"+global.EncodeKey("scope"), form, nil); resp.Code != 204 {
+		t.Fatalf("更新规则失败：%d %v", resp.Code, resp.Body)
+	}
+	resp = request(t, "PUT", "/auth", url.Values{"name": {"scope"}, "token": {tokenStr}, "refresh_token": {refreshTokenStr}}, nil)
+	if resp.Code != 200 {
+		t.Fatalf("刷新授权失败：%d %v", resp.Code, resp.Body)
+	}
+	tokenStr = resp.String("token")
+	if resp = request(t, "GET", "/auth", url.Values{"name": {"scope"}, "token": {tokenStr}, "scope": {"read"}}, nil); resp.Code != 200 || resp.String("scope") != "read" {
+		t.Errorf("刷新后的scope无效：%d %v", resp.Code, resp.Body)
+	}
+	if resp = request(t, "GET", "/auth", url.Values{"name": {"scope"}, "token": {tokenStr}, "scope": {"write"}}, nil); resp.Code != 403 {
+		t.Errorf("刷新后不应包含规则不再允许的scope：%d %v", resp.Code, resp.Body)
+	}
+}
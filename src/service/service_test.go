@@ -0,0 +1,281 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"local/global"
+
+	"github.com/dxvgef/tsing"
+)
+
+// 测试用的内存存储器，写入时直接同步到本地数据，相当于立即收到了监听事件
+type memStorage struct {
+	mutex   sync.Mutex
+	tokens  map[string][]byte
+	once    map[string][]byte // 刷新授权和授权码的数据，key为前缀+hash
+	used    map[string]bool   // 已使用的刷新授权和授权码
+	revoked map[string]int64
+}
+
+func newMemStorage() *memStorage {
+	return &memStorage{
+		tokens:  make(map[string][]byte),
+		once:    make(map[string][]byte),
+		used:    make(map[string]bool),
+		revoked: make(map[string]int64),
+	}
+}
+
+func (self *memStorage) LoadAllRule() error { return nil }
+func (self *memStorage) LoadRule([]byte) error {
+	return nil
+}
+func (self *memStorage) SaveAllRule() error { return nil }
+
+func (self *memStorage) SaveRule(rule global.Rule) error {
+	global.Rules.Store(rule.Name, rule)
+	return nil
+}
+
+func (self *memStorage) DeleteRule(name string) error {
+	global.Subjects.Range(func(key, _ interface{}) bool {
+		if strings.HasPrefix(key.(string), name+"/") {
+			global.Subjects.Delete(key)
+		}
+		return true
+	})
+	return global.DeleteRule(name)
+}
+
+func (self *memStorage) SaveToken(hash string, data []byte, _ int64) error {
+	self.mutex.Lock()
+	self.tokens[hash] = data
+	self.mutex.Unlock()
+	global.Tokens.Store(hash, data)
+	return nil
+}
+
+func (self *memStorage) LoadToken(hash string) ([]byte, error) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	return self.tokens[hash], nil
+}
+
+func (self *memStorage) DeleteToken(hash string) error {
+	self.mutex.Lock()
+	delete(self.tokens, hash)
+	self.mutex.Unlock()
+	global.Tokens.Delete(hash)
+	return nil
+}
+
+func (self *memStorage) SaveRefreshToken(hash string, data []byte, _ int64) error {
+	return self.putOnce("refresh/"+hash, data)
+}
+
+func (self *memStorage) LoadRefreshToken(hash string) ([]byte, bool, error) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	return self.once["refresh/"+hash], self.used["refresh/"+hash], nil
+}
+
+func (self *memStorage) UseRefreshToken(hash string) ([]byte, bool, error) {
+	return self.useOnce("refresh/" + hash)
+}
+
+func (self *memStorage) SaveAuthCode(hash string, data []byte, _ int64) error {
+	return self.putOnce("codes/"+hash, data)
+}
+
+func (self *memStorage) UseAuthCode(hash string) ([]byte, bool, error) {
+	return self.useOnce("codes/" + hash)
+}
+
+func (self *memStorage) putOnce(key string, data []byte) error {
+	self.mutex.Lock()
+	self.once[key] = data
+	self.mutex.Unlock()
+	return nil
+}
+
+func (self *memStorage) useOnce(key string) ([]byte, bool, error) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	data, exists := self.once[key]
+	if !exists {
+		return nil, false, nil
+	}
+	first := !self.used[key]
+	self.used[key] = true
+	return data, first, nil
+}
+
+func (self *memStorage) SaveRevoked(id string, expires int64) error {
+	self.mutex.Lock()
+	self.revoked[id] = expires
+	self.mutex.Unlock()
+	global.Revoked.Store(id, expires)
+	return nil
+}
+
+func (self *memStorage) LoadAllRevoked() error { return nil }
+func (self *memStorage) LoadAllRBAC() error    { return nil }
+
+func (self *memStorage) SavePermission(permission global.Permission) error {
+	global.Permissions.Store(permission.Name, permission)
+	return nil
+}
+
+func (self *memStorage) DeletePermission(name string) error {
+	global.Permissions.Delete(name)
+	return nil
+}
+
+func (self *memStorage) SaveRole(role global.Role) error {
+	global.Roles.Store(role.Name, role)
+	return nil
+}
+
+func (self *memStorage) DeleteRole(name string) error {
+	global.Roles.Delete(name)
+	return nil
+}
+
+func (self *memStorage) SaveSubject(subject global.Subject) error {
+	global.Subjects.Store(subject.Key(), subject)
+	return nil
+}
+
+func (self *memStorage) DeleteSubject(subject global.Subject) error {
+	global.DeleteSubject(subject.Key())
+	return nil
+}
+
+func (self *memStorage) LoadAllClient() error { return nil }
+
+func (self *memStorage) SaveClient(client global.Client) error {
+	global.Clients.Store(client.ID, client)
+	return nil
+}
+
+func (self *memStorage) DeleteClient(id string) error {
+	global.Clients.Delete(id)
+	return nil
+}
+
+func (self *memStorage) Lock(string, int64) (bool, error) { return true, nil }
+func (self *memStorage) Watch() error                     { return nil }
+
+// 测试用的SECRET
+const testSecret = "test-secret"
+
+// 使用内存存储器初始化服务，并清除之前的测试留下的本地数据
+func setupTest(t *testing.T) *memStorage {
+	t.Helper()
+	for _, m := range []*sync.Map{&global.Rules, &global.Tokens, &global.Revoked, &global.Permissions, &global.Roles, &global.Subjects, &global.Clients} {
+		global.SyncMapClean(m)
+	}
+	storage := newMemStorage()
+	global.StorageInstance = storage
+	global.Config.Service.Secret = testSecret
+	engine = tsing.New(tsing.Config{EventHandlerError: true, EventHandler: eventHandler})
+	setRouter()
+	return storage
+}
+
+// 测试请求的响应
+type testResponse struct {
+	Code   int
+	Header map[string][]string
+	Body   map[string]interface{}
+}
+
+// 获得响应中的字符串字段
+func (self testResponse) String(name string) string {
+	value, _ := self.Body[name].(string)
+	return value
+}
+
+// 发送表单请求，GET和DELETE请求的参数放在URL中
+func request(t *testing.T, method, target string, form url.Values, header map[string]string) testResponse {
+	t.Helper()
+	var body string
+	if method == "GET" || method == "DELETE" {
+		if len(form) > 0 {
+			target += "?" + form.Encode()
+		}
+	} else {
+		body = form.Encode()
+	}
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Host = "auth.test"
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("SECRET", testSecret)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	resp := testResponse{Code: w.Code, Header: w.Header()}
+	if w.Body.Len() > 0 {
+		_ = json.Unmarshal(w.Body.Bytes(), &resp.Body)
+	}
+	return resp
+}
+
+// 添加规则，失败时终止测试
+func addRule(t *testing.T, form url.Values) {
+	t.Helper()
+	if resp := request(t, "POST", "/rule/", form, nil); resp.Code != 204 && resp.Code != 200 {
+		t.Fatalf("添加规则失败：%d %v", resp.Code, resp.Body)
+	}
+}
+
+// 签发授权，失败时终止测试
+func signToken(t *testing.T, form url.Values) testResponse {
+	t.Helper()
+	resp := request(t, "POST", "/auth", form, nil)
+	if resp.Code != 200 || resp.String("token") == "" {
+		t.Fatalf("签发授权失败：%d %v", resp.Code, resp.Body)
+	}
+	return resp
+}
+
+// 将字符串编码为JSON字符串，用于在JSON中嵌入配置
+func quoteJSON(value string) string {
+	data, _ := json.Marshal(value)
+	return string(data)
+}
+
+// 添加OAuth客户端，返回client_id和client_secret
+func addClient(t *testing.T, form url.Values) (string, string) {
+	t.Helper()
+	resp := request(t, "POST", "/oauth/clients", form, nil)
+	if resp.Code != 200 {
+		t.Fatalf("添加客户端失败：%d %v", resp.Code, resp.Body)
+	}
+	return resp.String("client_id"), resp.String("client_secret")
+}
+
+// 授予主体权限，perm为权限和角色的名称
+func grantPermission(t *testing.T, rule, name string, client bool, perm string) {
+	t.Helper()
+	if resp := request(t, "PUT", "/rbac/permissions/"+global.EncodeKey(perm), nil, nil); resp.Code != 204 {
+		t.Fatalf("添加权限失败：%d %v", resp.Code, resp.Body)
+	}
+	if resp := request(t, "PUT", "/rbac/roles/"+global.EncodeKey(perm), url.Values{"permissions": {perm}}, nil); resp.Code != 204 {
+		t.Fatalf("添加角色失败：%d %v", resp.Code, resp.Body)
+	}
+	target := "/rbac/subjects/" + global.EncodeKey(rule) + "/" + global.EncodeKey(name)
+	if client {
+		target += "?client=true"
+	}
+	if resp := request(t, "PUT", target, url.Values{"roles": {perm}}, nil); resp.Code != 204 {
+		t.Fatalf("设置主体的角色失败：%d %v", resp.Code, resp.Body)
+	}
+}
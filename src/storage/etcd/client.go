@@ -0,0 +1,53 @@
+package etcd
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"local/global"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/rs/zerolog/log"
+)
+
+// OAuth客户端的键名前缀
+const clientPrefix = "/clients/"
+
+// 从存储器加载所有OAuth客户端到本地
+func (self *Etcd) LoadAllClient() error {
+	ctx, ctxCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer ctxCancel()
+	resp, err := self.client.Get(ctx, self.KeyPrefix+clientPrefix, clientv3.WithPrefix())
+	if err != nil {
+		log.Err(err).Caller().Send()
+		return err
+	}
+	for k := range resp.Kvs {
+		if err = loadClient(resp.Kvs[k].Value); err != nil {
+			log.Err(err).Caller().Send()
+			return err
+		}
+	}
+	return nil
+}
+
+// 将OAuth客户端保存到存储器
+func (self *Etcd) SaveClient(client global.Client) error {
+	return self.putJSON(clientPrefix, client.ID, &client)
+}
+
+// 删除存储器中的OAuth客户端
+func (self *Etcd) DeleteClient(id string) error {
+	return self.deleteJSON(clientPrefix, id)
+}
+
+// 将OAuth客户端写入到本地
+func loadClient(value []byte) error {
+	var client global.Client
+	if err := json.Unmarshal(value, &client); err != nil {
+		return err
+	}
+	global.Clients.Store(client.ID, client)
+	return nil
+}
@@ -0,0 +1,11 @@
+package etcd
+
+// 保存OAuth授权码的数据，到期后自动删除
+func (self *Etcd) SaveAuthCode(hash string, data []byte, ttl int64) error {
+	return self.putOnce("/codes/", hash, data, ttl)
+}
+
+// 将OAuth授权码标记为已使用，返回授权码的数据及是否为首次使用，授权码不存在或已过期时返回nil
+func (self *Etcd) UseAuthCode(hash string) ([]byte, bool, error) {
+	return self.useOnce("/codes/", hash)
+}
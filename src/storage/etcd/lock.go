@@ -0,0 +1,42 @@
+package etcd
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/rs/zerolog/log"
+)
+
+// 获取分布式锁，锁在ttl秒后随租约到期自动释放
+func (self *Etcd) Lock(name string, ttl int64) (bool, error) {
+	var key strings.Builder
+	key.WriteString(self.KeyPrefix)
+	key.WriteString("/locks/")
+	key.WriteString(name)
+
+	ctx, ctxCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer ctxCancel()
+	lease, err := self.client.Grant(ctx, ttl)
+	if err != nil {
+		log.Err(err).Caller().Send()
+		return false, err
+	}
+	// 只有键不存在时才写入，写入成功即获得锁
+	resp, err := self.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(key.String()), "=", 0)).
+		Then(clientv3.OpPut(key.String(), "", clientv3.WithLease(lease.ID))).
+		Commit()
+	if err != nil {
+		log.Err(err).Caller().Send()
+		return false, err
+	}
+	if !resp.Succeeded {
+		if _, err = self.client.Revoke(ctx, lease.ID); err != nil {
+			log.Err(err).Caller().Send()
+		}
+		return false, nil
+	}
+	return true, nil
+}
@@ -0,0 +1,146 @@
+package etcd
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"local/global"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/rs/zerolog/log"
+)
+
+// RBAC数据的键名前缀
+const (
+	rbacPrefix           = "/rbac/"
+	rbacPermissionPrefix = "/rbac/permissions/"
+	rbacRolePrefix       = "/rbac/roles/"
+	rbacSubjectPrefix    = "/rbac/subjects/"
+)
+
+// 从存储器加载所有权限、角色和主体到本地
+func (self *Etcd) LoadAllRBAC() error {
+	ctx, ctxCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer ctxCancel()
+	resp, err := self.client.Get(ctx, self.KeyPrefix+rbacPrefix, clientv3.WithPrefix())
+	if err != nil {
+		log.Err(err).Caller().Send()
+		return err
+	}
+	for k := range resp.Kvs {
+		if err = self.loadRBAC(resp.Kvs[k].Key, resp.Kvs[k].Value); err != nil {
+			log.Err(err).Caller().Send()
+			return err
+		}
+	}
+	return nil
+}
+
+// 将权限保存到存储器
+func (self *Etcd) SavePermission(permission global.Permission) error {
+	return self.putJSON(rbacPermissionPrefix, permission.Name, &permission)
+}
+
+// 删除存储器中的权限
+func (self *Etcd) DeletePermission(name string) error {
+	return self.deleteJSON(rbacPermissionPrefix, name)
+}
+
+// 将角色保存到存储器
+func (self *Etcd) SaveRole(role global.Role) error {
+	return self.putJSON(rbacRolePrefix, role.Name, &role)
+}
+
+// 删除存储器中的角色
+func (self *Etcd) DeleteRole(name string) error {
+	return self.deleteJSON(rbacRolePrefix, name)
+}
+
+// 将主体的角色保存到存储器
+func (self *Etcd) SaveSubject(subject global.Subject) error {
+	return self.putKey(rbacSubjectPrefix+subject.Key(), &subject)
+}
+
+// 删除存储器中主体的角色
+func (self *Etcd) DeleteSubject(subject global.Subject) error {
+	return self.deleteKey(rbacSubjectPrefix + subject.Key())
+}
+
+// 将数据序列化为JSON写入存储器，键名为前缀+编码后的名称
+func (self *Etcd) putJSON(prefix, name string, data interface{}) error {
+	return self.putKey(prefix+global.EncodeKey(name), data)
+}
+
+// 将数据编码成JSON写入到存储器，key为不含KeyPrefix的键名
+func (self *Etcd) putKey(key string, data interface{}) error {
+	dataBytes, err := json.Marshal(data)
+	if err != nil {
+		log.Err(err).Caller().Send()
+		return err
+	}
+	ctx, ctxCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer ctxCancel()
+	if _, err = self.client.Put(ctx, self.KeyPrefix+key, global.BytesToStr(dataBytes)); err != nil {
+		log.Err(err).Caller().Send()
+		return err
+	}
+	return nil
+}
+
+// 删除存储器中前缀+编码后的名称的数据
+func (self *Etcd) deleteJSON(prefix, name string) error {
+	return self.deleteKey(prefix + global.EncodeKey(name))
+}
+
+// 删除存储器中的数据，key为不含KeyPrefix的键名
+func (self *Etcd) deleteKey(key string) error {
+	ctx, ctxCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer ctxCancel()
+	if _, err := self.client.Delete(ctx, self.KeyPrefix+key); err != nil {
+		log.Err(err).Caller().Send()
+		return err
+	}
+	return nil
+}
+
+// 将RBAC数据写入到本地
+func (self *Etcd) loadRBAC(key, value []byte) (err error) {
+	keyStr := global.BytesToStr(key)
+	switch {
+	case strings.HasPrefix(keyStr, self.KeyPrefix+rbacPermissionPrefix):
+		var permission global.Permission
+		if err = json.Unmarshal(value, &permission); err != nil {
+			return
+		}
+		global.Permissions.Store(permission.Name, permission)
+	case strings.HasPrefix(keyStr, self.KeyPrefix+rbacRolePrefix):
+		var role global.Role
+		if err = json.Unmarshal(value, &role); err != nil {
+			return
+		}
+		global.Roles.Store(role.Name, role)
+	case strings.HasPrefix(keyStr, self.KeyPrefix+rbacSubjectPrefix):
+		var subject global.Subject
+		if err = json.Unmarshal(value, &subject); err != nil {
+			return
+		}
+		global.Subjects.Store(subject.Key(), subject)
+	}
+	return
+}
+
+// 删除本地的RBAC数据
+func (self *Etcd) unloadRBAC(key []byte) error {
+	keyStr := global.BytesToStr(key)
+	switch {
+	case strings.HasPrefix(keyStr, self.KeyPrefix+rbacPermissionPrefix):
+		return global.DeletePermission(keyStr)
+	case strings.HasPrefix(keyStr, self.KeyPrefix+rbacRolePrefix):
+		return global.DeleteRole(keyStr)
+	case strings.HasPrefix(keyStr, self.KeyPrefix+rbacSubjectPrefix):
+		global.DeleteSubject(strings.TrimPrefix(keyStr, self.KeyPrefix+rbacSubjectPrefix))
+	}
+	return nil
+}
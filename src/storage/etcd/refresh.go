@@ -0,0 +1,120 @@
+package etcd
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/rs/zerolog/log"
+)
+
+// 保存刷新授权的数据，ttl大于0时使用租约自动过期
+func (self *Etcd) SaveRefreshToken(hash string, data []byte, ttl int64) error {
+	return self.putOnce("/refresh/", hash, data, ttl)
+}
+
+// 将刷新授权标记为已使用，返回刷新授权的数据及是否为首次使用，刷新授权不存在时返回nil
+func (self *Etcd) UseRefreshToken(hash string) ([]byte, bool, error) {
+	return self.useOnce("/refresh/", hash)
+}
+
+// 读取刷新授权的数据但不标记为已使用，返回刷新授权的数据及是否已被使用，刷新授权不存在时返回nil
+func (self *Etcd) LoadRefreshToken(hash string) ([]byte, bool, error) {
+	return self.loadOnce("/refresh/", hash)
+}
+
+// 保存只能使用一次的数据，ttl大于0时使用租约自动过期
+func (self *Etcd) putOnce(prefix, hash string, data []byte, ttl int64) error {
+	var (
+		key  strings.Builder
+		opts []clientv3.OpOption
+	)
+	key.WriteString(self.KeyPrefix)
+	key.WriteString(prefix)
+	key.WriteString(hash)
+
+	ctx, ctxCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer ctxCancel()
+	if ttl > 0 {
+		lease, err := self.client.Grant(ctx, ttl)
+		if err != nil {
+			log.Err(err).Caller().Send()
+			return err
+		}
+		opts = append(opts, clientv3.WithLease(lease.ID))
+	}
+	if _, err := self.client.Put(ctx, key.String(), string(data), opts...); err != nil {
+		log.Err(err).Caller().Send()
+		return err
+	}
+	return nil
+}
+
+// 将只能使用一次的数据标记为已使用，返回数据及是否为首次使用，数据不存在时返回nil
+func (self *Etcd) useOnce(prefix, hash string) ([]byte, bool, error) {
+	var key strings.Builder
+	key.WriteString(self.KeyPrefix)
+	key.WriteString(prefix)
+	key.WriteString(hash)
+	usedKey := key.String() + "/used"
+
+	ctx, ctxCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer ctxCancel()
+	resp, err := self.client.Get(ctx, key.String())
+	if err != nil {
+		log.Err(err).Caller().Send()
+		return nil, false, err
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, false, nil
+	}
+	// 使用标记与数据共用租约，只有标记不存在时才写入，写入成功即为首次使用
+	var opts []clientv3.OpOption
+	if resp.Kvs[0].Lease != 0 {
+		opts = append(opts, clientv3.WithLease(clientv3.LeaseID(resp.Kvs[0].Lease)))
+	}
+	txnResp, err := self.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(usedKey), "=", 0)).
+		Then(clientv3.OpPut(usedKey, "", opts...)).
+		Commit()
+	if err != nil {
+		log.Err(err).Caller().Send()
+		return nil, false, err
+	}
+	return resp.Kvs[0].Value, txnResp.Succeeded, nil
+}
+
+// 读取只能使用一次的数据但不标记为已使用，返回数据及是否已被使用，数据不存在时返回nil
+func (self *Etcd) loadOnce(prefix, hash string) ([]byte, bool, error) {
+	var key strings.Builder
+	key.WriteString(self.KeyPrefix)
+	key.WriteString(prefix)
+	key.WriteString(hash)
+	usedKey := key.String() + "/used"
+
+	ctx, ctxCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer ctxCancel()
+	// 数据和使用标记的键名前缀相同，一次读取
+	resp, err := self.client.Get(ctx, key.String(), clientv3.WithPrefix())
+	if err != nil {
+		log.Err(err).Caller().Send()
+		return nil, false, err
+	}
+	var (
+		data []byte
+		used bool
+	)
+	for _, kv := range resp.Kvs {
+		switch string(kv.Key) {
+		case key.String():
+			data = kv.Value
+		case usedKey:
+			used = true
+		}
+	}
+	if data == nil {
+		return nil, false, nil
+	}
+	return data, used, nil
+}
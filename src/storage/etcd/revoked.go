@@ -0,0 +1,78 @@
+package etcd
+
+import (
+	"context"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"local/global"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/rs/zerolog/log"
+)
+
+// 将授权加入吊销列表，使用租约在授权过期时自动删除，永不过期的授权不设置租约
+func (self *Etcd) SaveRevoked(id string, expires int64) error {
+	var (
+		key  strings.Builder
+		opts []clientv3.OpOption
+	)
+	key.WriteString(self.KeyPrefix)
+	key.WriteString("/revoked/")
+	key.WriteString(id)
+
+	ctx, ctxCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer ctxCancel()
+	if expires > 0 {
+		ttl := expires - time.Now().Unix()
+		// 授权已过期，无需吊销
+		if ttl <= 0 {
+			return nil
+		}
+		lease, err := self.client.Grant(ctx, ttl)
+		if err != nil {
+			log.Err(err).Caller().Send()
+			return err
+		}
+		opts = append(opts, clientv3.WithLease(lease.ID))
+	}
+	if _, err := self.client.Put(ctx, key.String(), strconv.FormatInt(expires, 10), opts...); err != nil {
+		log.Err(err).Caller().Send()
+		return err
+	}
+	return nil
+}
+
+// 从存储器加载吊销列表到本地
+func (self *Etcd) LoadAllRevoked() error {
+	var key strings.Builder
+	key.WriteString(self.KeyPrefix)
+	key.WriteString("/revoked/")
+
+	ctx, ctxCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer ctxCancel()
+	resp, err := self.client.Get(ctx, key.String(), clientv3.WithPrefix())
+	if err != nil {
+		log.Err(err).Caller().Send()
+		return err
+	}
+	for k := range resp.Kvs {
+		if err = loadRevoked(resp.Kvs[k].Key, resp.Kvs[k].Value); err != nil {
+			log.Err(err).Caller().Send()
+			return err
+		}
+	}
+	return nil
+}
+
+// 将吊销的授权写入到本地
+func loadRevoked(key, value []byte) error {
+	expires, err := strconv.ParseInt(global.BytesToStr(value), 10, 64)
+	if err != nil {
+		return err
+	}
+	global.Revoked.Store(path.Base(global.BytesToStr(key)), expires)
+	return nil
+}
@@ -0,0 +1,50 @@
+package etcd
+
+import (
+	"testing"
+
+	"local/global"
+)
+
+// 监听到吊销列表的变更时同步到本地
+func TestWatchRevoked(t *testing.T) {
+	global.SyncMapClean(&global.Revoked)
+	storage := &Etcd{KeyPrefix: "/test"}
+	cases := []struct {
+		name    string
+		key     string
+		value   string
+		id      string
+		expires int64
+		err     bool
+	}{
+		{"有过期时间", "/test/revoked/a", "1700000000", "a", 1700000000, false},
+		{"没有过期时间", "/test/revoked/b", "0", "b", 0, false},
+		{"hash", "/test/revoked/0f1e2d", "1800000000", "0f1e2d", 1800000000, false},
+		{"无效的值", "/test/revoked/c", "abc", "c", 0, true},
+	}
+	for _, c := range cases {
+		err := storage.watchLoadData([]byte(c.key), []byte(c.value))
+		if (err != nil) != c.err {
+			t.Errorf("%s：期望错误%v，实际%v", c.name, c.err, err)
+			continue
+		}
+		value, exists := global.Revoked.Load(c.id)
+		if exists == c.err || (exists && value != c.expires) {
+			t.Errorf("%s：本地的吊销列表无效：%v %v", c.name, exists, value)
+		}
+	}
+
+	// 其它前缀的数据不影响吊销列表
+	if err := storage.watchLoadData([]byte("/other/revoked/d"), []byte("0")); err != nil || global.IsRevoked("d") {
+		t.Errorf("不应加载其它前缀的数据：%v", err)
+	}
+
+	// 租约到期或删除时从本地删除
+	if err := storage.watchDeleteData([]byte("/test/revoked/a")); err != nil {
+		t.Fatal(err)
+	}
+	if global.IsRevoked("a") || !global.IsRevoked("b") {
+		t.Error("删除吊销列表的数据后本地没有同步")
+	}
+}
@@ -3,12 +3,16 @@ package etcd
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"local/authorizer"
 	"local/updater"
+	"path"
 	"strings"
 	"time"
 
+	"local/envelope"
 	"local/global"
+	"local/policy"
 
 	"github.com/coreos/etcd/clientv3"
 	"github.com/rs/zerolog/log"
@@ -22,20 +26,33 @@ func (self *Etcd) LoadRule(data []byte) error {
 		log.Err(err).Caller().Send()
 		return err
 	}
+	// 类型名称不区分大小写，兼容之前保存的小写名称
+	rule.Authorizer.Type = strings.ToUpper(rule.Authorizer.Type)
+	rule.Updater.Type = strings.ToUpper(rule.Updater.Type)
+	// 解密密钥配置
+	if err = envelope.DecryptRule(&rule); err != nil {
+		log.Err(err).Caller().Str("rule", rule.Name).Msg("解密规则失败")
+		return err
+	}
 	// 构建授权器实例
-	rule.Authorizer.Instance, err = authorizer.Build(rule.Authorizer.Type, rule.Authorizer.Config)
+	rule.Authorizer.Instance, err = authorizer.BuildWithKeys(rule.Authorizer.Type, rule.Authorizer.Config, rule.Authorizer.Keys)
 	if err != nil {
 		log.Err(err).Caller().Msg("构建授权器实例失败")
 		return err
 	}
 	//构建更新器的实例
 	if rule.Updater.Type != "" {
-		rule.Updater.Instance, err = updater.Build(rule.Updater.Type, rule.Updater.Config)
+		rule.Updater.Instance, err = updater.BuildWithKeys(rule.Updater.Type, rule.Updater.Config, rule.Updater.Keys)
 		if err != nil {
 			log.Err(err).Caller().Msg("构建更新器实例失败")
 			return err
 		}
 	}
+	// 编译策略
+	if err = policy.Build(rule.Policies); err != nil {
+		log.Err(err).Caller().Str("rule", rule.Name).Msg("编译策略失败")
+		return err
+	}
 	// 将规则写入到本地
 	global.Rules.Store(rule.Name, rule)
 	return nil
@@ -72,6 +89,11 @@ func (self *Etcd) SaveRule(rule global.Rule) error {
 	key.WriteString("/rules/")
 	key.WriteString(global.EncodeKey(rule.Name))
 
+	// 加密密钥配置
+	if err := envelope.EncryptRule(&rule); err != nil {
+		log.Err(err).Caller().Send()
+		return err
+	}
 	ruleBytes, err := json.Marshal(&rule)
 	if err != nil {
 		log.Err(err).Caller().Send()
@@ -87,45 +109,63 @@ func (self *Etcd) SaveRule(rule global.Rule) error {
 	return nil
 }
 
-// 将本地所有规则数据保存到存储器
+// 将本地所有规则数据保存到存储器，并删除存储器中本地不存在的规则
 func (self *Etcd) SaveAllRule() error {
 	var (
-		err         error
-		key         strings.Builder
-		rules       = make(map[string]string, global.SyncMapLen(&global.Rules))
-		configBytes []byte
+		err   error
+		key   strings.Builder
+		rules = make([]global.Rule, 0, global.SyncMapLen(&global.Rules))
+		names = make(map[string]struct{}, cap(rules))
 	)
 
 	// 将数据保存到临时变量中
 	global.Rules.Range(func(k, v interface{}) bool {
 		rule, ok := v.(global.Rule)
 		if !ok {
-			log.Error().Caller().Msg("类型断言失败")
-			return false
-		}
-		if configBytes, err = json.Marshal(&rule); err != nil {
+			err = errors.New("类型断言失败")
 			log.Err(err).Caller().Send()
 			return false
 		}
-		rules[k.(string)] = global.BytesToStr(configBytes)
+		rules = append(rules, rule)
+		names[global.EncodeKey(rule.Name)] = struct{}{}
 		return true
 	})
+	if err != nil {
+		return err
+	}
 
-	// 清空存储器中的配置
+	// 逐个写入规则，启用加密时会使用当前的主密钥重新加密
+	for k := range rules {
+		if err = self.SaveRule(rules[k]); err != nil {
+			log.Err(err).Caller().Send()
+			return err
+		}
+	}
+
+	// 删除存储器中本地不存在的规则
 	key.WriteString(self.KeyPrefix)
 	key.WriteString("/rules/")
 	ctx, ctxCancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer ctxCancel()
-	_, err = self.client.Delete(ctx, key.String(), clientv3.WithPrefix())
+	resp, err := self.client.Get(ctx, key.String(), clientv3.WithPrefix(), clientv3.WithKeysOnly())
 	if err != nil {
 		log.Err(err).Caller().Send()
 		return err
 	}
+	for k := range resp.Kvs {
+		if _, exists := names[path.Base(string(resp.Kvs[k].Key))]; exists {
+			continue
+		}
+		if _, err = self.client.Delete(ctx, string(resp.Kvs[k].Key)); err != nil {
+			log.Err(err).Caller().Send()
+			return err
+		}
+	}
 
 	return nil
 }
 
-// 删除存储器的规则数据
+// 删除存储器的规则数据及其主体
 func (self *Etcd) DeleteRule(name string) error {
 	var key strings.Builder
 	key.WriteString(self.KeyPrefix)
@@ -133,7 +173,13 @@ func (self *Etcd) DeleteRule(name string) error {
 	key.WriteString(name)
 	ctx, ctxCancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer ctxCancel()
-	_, err := self.client.Delete(ctx, key.String())
+	// 在同一个事务中删除规则的所有主体，避免留下无法访问的主体数据
+	_, err := self.client.Txn(ctx).
+		Then(
+			clientv3.OpDelete(key.String()),
+			clientv3.OpDelete(self.KeyPrefix+rbacSubjectPrefix+name+"/", clientv3.WithPrefix()),
+		).
+		Commit()
 	if err != nil {
 		log.Err(err).Caller().Send()
 		return err
@@ -0,0 +1,73 @@
+package etcd
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/rs/zerolog/log"
+)
+
+// 保存不透明token的数据，ttl大于0时使用租约自动过期
+func (self *Etcd) SaveToken(hash string, data []byte, ttl int64) error {
+	var (
+		key  strings.Builder
+		opts []clientv3.OpOption
+	)
+	key.WriteString(self.KeyPrefix)
+	key.WriteString("/tokens/")
+	key.WriteString(hash)
+
+	ctx, ctxCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer ctxCancel()
+	if ttl > 0 {
+		lease, err := self.client.Grant(ctx, ttl)
+		if err != nil {
+			log.Err(err).Caller().Send()
+			return err
+		}
+		opts = append(opts, clientv3.WithLease(lease.ID))
+	}
+	if _, err := self.client.Put(ctx, key.String(), string(data), opts...); err != nil {
+		log.Err(err).Caller().Send()
+		return err
+	}
+	return nil
+}
+
+// 读取不透明token的数据
+func (self *Etcd) LoadToken(hash string) ([]byte, error) {
+	var key strings.Builder
+	key.WriteString(self.KeyPrefix)
+	key.WriteString("/tokens/")
+	key.WriteString(hash)
+
+	ctx, ctxCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer ctxCancel()
+	resp, err := self.client.Get(ctx, key.String())
+	if err != nil {
+		log.Err(err).Caller().Send()
+		return nil, err
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, nil
+	}
+	return resp.Kvs[0].Value, nil
+}
+
+// 删除不透明token的数据
+func (self *Etcd) DeleteToken(hash string) error {
+	var key strings.Builder
+	key.WriteString(self.KeyPrefix)
+	key.WriteString("/tokens/")
+	key.WriteString(hash)
+
+	ctx, ctxCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer ctxCancel()
+	if _, err := self.client.Delete(ctx, key.String()); err != nil {
+		log.Err(err).Caller().Send()
+		return err
+	}
+	return nil
+}
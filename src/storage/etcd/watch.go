@@ -2,6 +2,7 @@ package etcd
 
 import (
 	"context"
+	"path"
 	"strings"
 
 	"github.com/coreos/etcd/clientv3"
@@ -39,6 +40,22 @@ func (self *Etcd) watchLoadData(key, value []byte) error {
 	if strings.HasPrefix(keyStr, self.KeyPrefix+"/rules/") {
 		return self.LoadRule(value)
 	}
+	// 加载不透明token
+	if strings.HasPrefix(keyStr, self.KeyPrefix+"/tokens/") {
+		global.Tokens.Store(path.Base(keyStr), value)
+	}
+	// 加载吊销的授权
+	if strings.HasPrefix(keyStr, self.KeyPrefix+"/revoked/") {
+		return loadRevoked(key, value)
+	}
+	// 加载权限、角色和主体
+	if strings.HasPrefix(keyStr, self.KeyPrefix+rbacPrefix) {
+		return self.loadRBAC(key, value)
+	}
+	// 加载OAuth客户端
+	if strings.HasPrefix(keyStr, self.KeyPrefix+clientPrefix) {
+		return loadClient(value)
+	}
 	return nil
 }
 
@@ -48,5 +65,17 @@ func (self *Etcd) watchDeleteData(key []byte) error {
 	if strings.HasPrefix(keyStr, self.KeyPrefix+"/rules/") {
 		return global.DeleteRule(keyStr)
 	}
+	if strings.HasPrefix(keyStr, self.KeyPrefix+"/tokens/") {
+		global.DeleteToken(keyStr)
+	}
+	if strings.HasPrefix(keyStr, self.KeyPrefix+"/revoked/") {
+		global.DeleteRevoked(keyStr)
+	}
+	if strings.HasPrefix(keyStr, self.KeyPrefix+rbacPrefix) {
+		return self.unloadRBAC(key)
+	}
+	if strings.HasPrefix(keyStr, self.KeyPrefix+clientPrefix) {
+		return global.DeleteClient(keyStr)
+	}
 	return nil
 }
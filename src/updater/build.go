@@ -5,9 +5,13 @@ import (
 	"strings"
 
 	"local/global"
-	"local/updater/jwt_hs256"
-	"local/updater/jwt_rs256"
+	"local/updater/jwt_ecdsa"
+	"local/updater/jwt_eddsa"
+	"local/updater/jwt_hmac"
+	"local/updater/jwt_rsa"
 	"local/updater/jwt_sm2"
+	"local/updater/paseto_v4_local"
+	"local/updater/paseto_v4_public"
 
 	"github.com/rs/zerolog/log"
 )
@@ -16,15 +20,29 @@ import (
 func Build(name, config string) (global.UpdaterInstance, error) {
 	name = strings.ToUpper(name)
 	switch name {
-	case "JWT_HS256":
-		instance, err := jwt_hs256.New(config)
+	case "JWT_HS256", "JWT_HS384", "JWT_HS512":
+		instance, err := jwt_hmac.New(strings.TrimPrefix(name, "JWT_"), config)
 		if err != nil {
 			log.Err(err).Caller().Send()
 			return nil, err
 		}
 		return instance, nil
-	case "JWT_RS256":
-		instance, err := jwt_rs256.New(config)
+	case "JWT_RS256", "JWT_RS384", "JWT_RS512", "JWT_PS256", "JWT_PS384", "JWT_PS512":
+		instance, err := jwt_rsa.New(strings.TrimPrefix(name, "JWT_"), config)
+		if err != nil {
+			log.Err(err).Caller().Send()
+			return nil, err
+		}
+		return instance, nil
+	case "JWT_ES256", "JWT_ES384", "JWT_ES512":
+		instance, err := jwt_ecdsa.New(strings.TrimPrefix(name, "JWT_"), config)
+		if err != nil {
+			log.Err(err).Caller().Send()
+			return nil, err
+		}
+		return instance, nil
+	case "JWT_EDDSA":
+		instance, err := jwt_eddsa.New(config)
 		if err != nil {
 			log.Err(err).Caller().Send()
 			return nil, err
@@ -37,6 +55,42 @@ func Build(name, config string) (global.UpdaterInstance, error) {
 			return nil, err
 		}
 		return instance, nil
+	case "PASETO_V4_LOCAL":
+		instance, err := paseto_v4_local.New(config)
+		if err != nil {
+			log.Err(err).Caller().Send()
+			return nil, err
+		}
+		return instance, nil
+	case "PASETO_V4_PUBLIC":
+		instance, err := paseto_v4_public.New(config)
+		if err != nil {
+			log.Err(err).Caller().Send()
+			return nil, err
+		}
+		return instance, nil
 	}
 	return nil, errors.New("不支持的更新器类型")
 }
+
+// 生成更新器的密钥配置，config为更新器的基础配置
+func GenerateKey(name, config string) (string, error) {
+	name = strings.ToUpper(name)
+	switch name {
+	case "JWT_HS256", "JWT_HS384", "JWT_HS512":
+		return jwt_hmac.GenerateKey(strings.TrimPrefix(name, "JWT_"))
+	case "JWT_RS256", "JWT_RS384", "JWT_RS512", "JWT_PS256", "JWT_PS384", "JWT_PS512":
+		return jwt_rsa.GenerateKey(strings.TrimPrefix(name, "JWT_"), config)
+	case "JWT_ES256", "JWT_ES384", "JWT_ES512":
+		return jwt_ecdsa.GenerateKey(strings.TrimPrefix(name, "JWT_"))
+	case "JWT_EDDSA":
+		return jwt_eddsa.GenerateKey()
+	case "JWT_SM2":
+		return jwt_sm2.GenerateKey()
+	case "PASETO_V4_LOCAL":
+		return paseto_v4_local.GenerateKey()
+	case "PASETO_V4_PUBLIC":
+		return paseto_v4_public.GenerateKey()
+	}
+	return "", errors.New("规则类型不支持生成密钥")
+}
@@ -0,0 +1,134 @@
+package jwt_ecdsa
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"local/claimset"
+	"local/global"
+	"local/keyutil"
+
+	"github.com/pascaldekloe/jwt"
+	"github.com/rs/zerolog/log"
+)
+
+type Instance struct {
+	Alg           string            `json:"-"`
+	Expires       int64             `json:"expires"`
+	KeyID         string            `json:"key_id,omitempty"`
+	PublicKeyStr  string            `json:"public_key,omitempty"`
+	PublicKey     *ecdsa.PublicKey  `json:"-"`
+	PrivateKey    *ecdsa.PrivateKey `json:"-"`
+	PrivateKeyStr string            `json:"private_key"`
+	Certificate   string            `json:"certificate,omitempty"` // X.509证书链，PEM或Base64编码
+	X5C           bool              `json:"x5c,omitempty"`         // 在header中嵌入证书链
+	X5T           bool              `json:"x5t,omitempty"`         // 在header中嵌入证书的SHA-1指纹
+	ExtraHeaders  []json.RawMessage `json:"-"`
+}
+
+// alg的值为ES256、ES384、ES512之一
+func New(alg, config string) (*Instance, error) {
+	var instance Instance
+	err := json.Unmarshal(global.StrToBytes(config), &instance)
+	if err != nil {
+		return nil, err
+	}
+	instance.Alg = alg
+	curve, err := keyutil.ECDSACurve(alg)
+	if err != nil {
+		return nil, err
+	}
+	if instance.PrivateKeyStr == "" {
+		return nil, errors.New("private_key不能为空")
+	}
+	// 转换私钥
+	instance.PrivateKey, err = keyutil.ParseECDSAPrivateKey(instance.PrivateKeyStr)
+	if err != nil {
+		return nil, errors.New("无效的私钥：" + err.Error())
+	}
+	if instance.PrivateKey.Curve != curve {
+		return nil, errors.New("私钥的曲线与" + alg + "算法不匹配")
+	}
+	// 转换公钥，未传入时使用私钥中的公钥
+	if instance.PublicKeyStr == "" {
+		instance.PublicKey = &instance.PrivateKey.PublicKey
+	} else {
+		instance.PublicKey, err = keyutil.ParseECDSAPublicKey(instance.PublicKeyStr)
+		if err != nil {
+			return nil, errors.New("无效的公钥：" + err.Error())
+		}
+		if instance.PublicKey.Curve != curve {
+			return nil, errors.New("公钥的曲线与" + alg + "算法不匹配")
+		}
+		if !keyutil.PublicKeyEqual(instance.PublicKey, &instance.PrivateKey.PublicKey) {
+			return nil, errors.New("公钥与私钥不匹配")
+		}
+	}
+	// 生成证书相关的header
+	instance.ExtraHeaders, err = keyutil.CertHeaders(instance.Certificate, instance.PrivateKeyStr, &instance.PrivateKey.PublicKey, instance.X5C, instance.X5T)
+	if err != nil {
+		return nil, errors.New("无效的证书：" + err.Error())
+	}
+
+	return &instance, nil
+}
+
+func (receiver *Instance) Sign(tokenHash string) (tokenStr string, err error) {
+	var (
+		claims     jwt.Claims
+		tokenBytes []byte
+	)
+	if receiver.Expires > 0 {
+		claims.Expires = jwt.NewNumericTime(time.Now().Add(time.Duration(receiver.Expires) * time.Second))
+	}
+	claims.Set = make(map[string]interface{}, 1)
+	claims.KeyID = receiver.KeyID
+	claims.Set["token_hash"] = tokenHash
+	tokenBytes, err = claims.ECDSASign(receiver.Alg, receiver.PrivateKey, receiver.ExtraHeaders...)
+	if err != nil {
+		log.Err(err).Caller().Send()
+		return
+	}
+	tokenStr = global.BytesToStr(tokenBytes)
+	return
+}
+
+func (receiver *Instance) VeritySign(tokenStr string) (global.UpdaterClaims, error) {
+	var claims global.UpdaterClaims
+	// 只接受实例算法的token
+	if err := claimset.CheckAlg(global.StrToBytes(tokenStr), receiver.Alg); err != nil {
+		return claims, err
+	}
+	// 解密得到claims
+	jwtClaims, err := jwt.ECDSACheck(global.StrToBytes(tokenStr), receiver.PublicKey)
+	if err != nil {
+		log.Debug().Err(err).Caller().Send()
+		return claims, claimset.CheckError(err)
+	}
+	claims.Expires = claimset.Unix(jwtClaims.Expires)
+	claims.TokenHash, _ = jwtClaims.String("token_hash")
+	claims.Aud, _ = jwtClaims.String("aud")
+	claims.IP, _ = jwtClaims.String("ip")
+	return claims, nil
+}
+
+// 导出公钥
+func (receiver *Instance) ExportPublicKey() crypto.PublicKey {
+	return receiver.PublicKey
+}
+
+// 生成密钥配置，使用算法对应曲线的ECDSA密钥
+func GenerateKey(alg string) (string, error) {
+	curve, err := keyutil.ECDSACurve(alg)
+	if err != nil {
+		return "", err
+	}
+	privateKeyStr, publicKeyStr, err := keyutil.GenerateECDSAKey(curve)
+	if err != nil {
+		return "", err
+	}
+	return keyutil.EncodeConfig(map[string]string{"private_key": privateKeyStr, "public_key": publicKeyStr})
+}
@@ -0,0 +1,106 @@
+package jwt_eddsa
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"local/claimset"
+	"local/global"
+	"local/keyutil"
+
+	"github.com/pascaldekloe/jwt"
+	"github.com/rs/zerolog/log"
+)
+
+type Instance struct {
+	Expires       int64              `json:"expires"`
+	KeyID         string             `json:"key_id,omitempty"`
+	PublicKey     ed25519.PublicKey  `json:"-"`
+	PrivateKey    ed25519.PrivateKey `json:"-"`
+	PrivateKeyStr string             `json:"private_key"`
+	PublicKeyStr  string             `json:"public_key,omitempty"`  // 可选，传入时必须与私钥匹配
+	Certificate   string             `json:"certificate,omitempty"` // X.509证书链，PEM或Base64编码
+	X5C           bool               `json:"x5c,omitempty"`         // 在header中嵌入证书链
+	X5T           bool               `json:"x5t,omitempty"`         // 在header中嵌入证书的SHA-1指纹
+	ExtraHeaders  []json.RawMessage  `json:"-"`
+}
+
+func New(config string) (*Instance, error) {
+	var instance Instance
+	err := json.Unmarshal(global.StrToBytes(config), &instance)
+	if err != nil {
+		return nil, err
+	}
+	if instance.PrivateKeyStr == "" {
+		return nil, errors.New("private_key不能为空")
+	}
+	// 转换私钥，支持原始种子、PEM和PKCS8格式
+	instance.PrivateKey, err = keyutil.ParseEd25519PrivateKey(instance.PrivateKeyStr)
+	if err != nil {
+		return nil, errors.New("无效的Ed25519私钥：" + err.Error())
+	}
+	instance.PublicKey = instance.PrivateKey.Public().(ed25519.PublicKey)
+	if err = keyutil.CheckPublicKey(instance.PublicKeyStr, instance.PublicKey); err != nil {
+		return nil, err
+	}
+
+	// 生成证书相关的header
+	instance.ExtraHeaders, err = keyutil.CertHeaders(instance.Certificate, instance.PrivateKeyStr, instance.PrivateKey.Public(), instance.X5C, instance.X5T)
+	if err != nil {
+		return nil, errors.New("无效的证书：" + err.Error())
+	}
+
+	return &instance, nil
+}
+
+func (receiver *Instance) Sign(tokenHash string) (tokenStr string, err error) {
+	var (
+		claims     jwt.Claims
+		tokenBytes []byte
+	)
+	if receiver.Expires > 0 {
+		claims.Expires = jwt.NewNumericTime(time.Now().Add(time.Duration(receiver.Expires) * time.Second))
+	}
+	claims.Set = make(map[string]interface{}, 1)
+	claims.KeyID = receiver.KeyID
+	claims.Set["token_hash"] = tokenHash
+	tokenBytes, err = claims.EdDSASign(receiver.PrivateKey, receiver.ExtraHeaders...)
+	if err != nil {
+		log.Err(err).Caller().Send()
+		return
+	}
+	tokenStr = global.BytesToStr(tokenBytes)
+	return
+}
+
+func (receiver *Instance) VeritySign(tokenStr string) (global.UpdaterClaims, error) {
+	var claims global.UpdaterClaims
+	// 解密得到claims
+	jwtClaims, err := jwt.EdDSACheck(global.StrToBytes(tokenStr), receiver.PublicKey)
+	if err != nil {
+		log.Debug().Err(err).Caller().Send()
+		return claims, claimset.CheckError(err)
+	}
+	claims.Expires = claimset.Unix(jwtClaims.Expires)
+	claims.TokenHash, _ = jwtClaims.String("token_hash")
+	claims.Aud, _ = jwtClaims.String("aud")
+	claims.IP, _ = jwtClaims.String("ip")
+	return claims, nil
+}
+
+// 导出公钥
+func (receiver *Instance) ExportPublicKey() crypto.PublicKey {
+	return receiver.PublicKey
+}
+
+// 生成密钥配置
+func GenerateKey() (string, error) {
+	privateKeyStr, publicKeyStr, err := keyutil.GenerateEd25519Key()
+	if err != nil {
+		return "", err
+	}
+	return keyutil.EncodeConfig(map[string]string{"private_key": privateKeyStr, "public_key": publicKeyStr})
+}
@@ -1,6 +1,7 @@
 package jwt_sm2
 
 import (
+	"crypto"
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
@@ -9,15 +10,31 @@ import (
 	"time"
 
 	"local/global"
+	"local/keyutil"
 
 	"github.com/rs/zerolog/log"
 	"github.com/tjfoc/gmsm/sm2"
 )
 
 type Instance struct {
-	Expires       int64           `json:"expires"`
-	PrivateKey    *sm2.PrivateKey `json:"-"`
-	PrivateKeyStr string          `json:"private_key"`
+	Expires       int64             `json:"expires"`
+	KeyID         string            `json:"key_id,omitempty"`
+	PrivateKey    *sm2.PrivateKey   `json:"-"`
+	PrivateKeyStr string            `json:"private_key"`
+	PublicKeyStr  string            `json:"public_key,omitempty"`  // 可选，传入时必须与私钥匹配
+	Certificate   string            `json:"certificate,omitempty"` // X.509证书链(支持SM2证书)，PEM或Base64编码
+	X5C           bool              `json:"x5c,omitempty"`         // 在header中嵌入证书链
+	X5T           bool              `json:"x5t,omitempty"`         // 在header中嵌入证书的SHA-1指纹
+	ExtraHeaders  []json.RawMessage `json:"-"`
+}
+
+type _Header struct {
+	Alg   string   `json:"alg"`
+	Enc   string   `json:"enc,omitempty"`
+	Typ   string   `json:"typ"`
+	KeyID string   `json:"kid,omitempty"`
+	X5C   []string `json:"x5c,omitempty"`
+	X5T   string   `json:"x5t,omitempty"`
 }
 
 type _Claims struct {
@@ -37,12 +54,20 @@ func New(config string) (*Instance, error) {
 		return nil, errors.New("private_key不能为空")
 	}
 	// 转换私钥
-	instance.PrivateKey, err = base64ToSM2PrivateKey(instance.PrivateKeyStr)
+	instance.PrivateKey, err = keyutil.ParseSM2PrivateKey(instance.PrivateKeyStr)
+	if err != nil {
+		return nil, errors.New("无效的SM2私钥：" + err.Error())
+	}
+	if err = keyutil.CheckPublicKey(instance.PublicKeyStr, &instance.PrivateKey.PublicKey); err != nil {
+		return nil, err
+	}
+	// 生成证书相关的header
+	instance.ExtraHeaders, err = keyutil.CertHeaders(instance.Certificate, instance.PrivateKeyStr, &instance.PrivateKey.PublicKey, instance.X5C, instance.X5T)
 	if err != nil {
-		return nil, errors.New("无效的SM2私钥Base64字符串：" + err.Error())
+		return nil, errors.New("无效的证书：" + err.Error())
 	}
 
-	return &instance, err
+	return &instance, nil
 }
 
 func (receiver *Instance) Sign(tokenHash string) (tokenStr string, err error) {
@@ -58,7 +83,11 @@ func (receiver *Instance) Sign(tokenHash string) (tokenStr string, err error) {
 	}
 	claims.TokenHash = tokenHash
 	// header部份
-	header = `{"alg":"SM2","typ":"JWT"}`
+	header, err = buildHeader("SM2", "", receiver.KeyID, receiver.ExtraHeaders...)
+	if err != nil {
+		log.Err(err).Caller().Send()
+		return
+	}
 	// payload部份
 	claimsBytes, err = json.Marshal(&claims)
 	if err != nil {
@@ -82,66 +111,100 @@ func (receiver *Instance) Sign(tokenHash string) (tokenStr string, err error) {
 	return
 }
 
-func (receiver *Instance) VeritySign(tokenStr string) (global.UpdaterClaims, bool) {
+func (receiver *Instance) VeritySign(tokenStr string) (global.UpdaterClaims, error) {
 	var claims global.UpdaterClaims
 	jwtClaims, err := parseClaims(receiver.PrivateKey, tokenStr)
 	if err != nil {
-		return claims, false
+		return claims, err
 	}
 	claims.Expires = jwtClaims.Expires
 	claims.TokenHash = jwtClaims.TokenHash
 	claims.Aud = jwtClaims.Aud
 	claims.IP = jwtClaims.IP
-	return claims, true
+	return claims, nil
 }
 
-// base64转sm2私钥
-func base64ToSM2PrivateKey(privateKeyStr string) (*sm2.PrivateKey, error) {
-	var (
-		err        error
-		decoded    []byte
-		privateKey *sm2.PrivateKey
-	)
-	decoded, err = base64.RawURLEncoding.DecodeString(privateKeyStr)
-	if err != nil {
-		return nil, err
-	}
-	privateKey, err = sm2.ParsePKCS8PrivateKey(decoded, nil)
-	if err != nil {
-		return nil, err
-	}
-	return privateKey, nil
+// 导出公钥
+func (receiver *Instance) ExportPublicKey() crypto.PublicKey {
+	return &receiver.PrivateKey.PublicKey
 }
 
 func parseClaims(key *sm2.PrivateKey, tokenStr string) (claims _Claims, err error) {
 	var claimsBytes, signBytes []byte
 	arr := strings.Split(tokenStr, ".")
 	if len(arr) != 3 {
-		err = errors.New("token无效")
+		err = global.ErrTokenMalformed
 		log.Err(err).Caller().Send()
 		return
 	}
+	// 只接受与实例一致的算法
+	if err = checkHeader(arr[0], "SM2"); err != nil {
+		return
+	}
 	claimsBytes, err = base64.RawURLEncoding.DecodeString(arr[1])
 	if err != nil {
 		log.Err(err).Caller().Send()
+		err = global.ErrTokenMalformed
 		return
 	}
 	signBytes, err = base64.RawURLEncoding.DecodeString(arr[2])
 	if err != nil {
 		log.Err(err).Caller().Send()
+		err = global.ErrTokenMalformed
 		return
 	}
 	msg := arr[0] + "." + arr[1]
 	// 用私钥验签(也可以用公钥)
 	if !key.Verify(global.StrToBytes(msg), signBytes) {
-		err = errors.New("签名无效")
+		err = global.ErrSignatureInvalid
 		log.Err(err).Caller().Send()
 		return
 	}
 	// 解析claims
 	if err = json.Unmarshal(claimsBytes, &claims); err != nil {
 		log.Err(err).Caller().Send()
+		err = global.ErrTokenMalformed
 		return
 	}
 	return
 }
+
+// 生成header部份，extraHeaders中的字段会合并到header中
+func buildHeader(alg, enc, keyID string, extraHeaders ...json.RawMessage) (string, error) {
+	header := _Header{Alg: alg, Enc: enc, Typ: "JWT", KeyID: keyID}
+	for k := range extraHeaders {
+		if err := json.Unmarshal(extraHeaders[k], &header); err != nil {
+			return "", err
+		}
+	}
+	headerBytes, err := json.Marshal(&header)
+	if err != nil {
+		return "", err
+	}
+	return global.BytesToStr(headerBytes), nil
+}
+
+// 生成密钥配置
+func GenerateKey() (string, error) {
+	privateKeyStr, publicKeyStr, err := keyutil.GenerateSM2Key()
+	if err != nil {
+		return "", err
+	}
+	return keyutil.EncodeConfig(map[string]string{"private_key": privateKeyStr, "public_key": publicKeyStr})
+}
+
+// 校验header中的算法
+func checkHeader(segment, alg string) error {
+	var header _Header
+	headerBytes, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return global.ErrTokenMalformed
+	}
+	if err = json.Unmarshal(headerBytes, &header); err != nil {
+		return global.ErrTokenMalformed
+	}
+	if header.Alg != alg {
+		return global.ErrAlgorithmMismatch
+	}
+	return nil
+}
@@ -13,16 +13,25 @@ import (
 	"github.com/tjfoc/gmsm/sm4"
 
 	"local/global"
+	"local/keyutil"
 
 	"github.com/rs/zerolog/log"
 )
 
 type Instance struct {
 	Expires int64  `json:"expires,omitempty"`
+	KeyID   string `json:"key_id,omitempty"`
 	Key     string `json:"key"`
 	IV      string `json:"iv,omitempty"`
 }
 
+type _Header struct {
+	Alg   string `json:"alg"`
+	Enc   string `json:"enc,omitempty"`
+	Typ   string `json:"typ"`
+	KeyID string `json:"kid,omitempty"`
+}
+
 type _Claims struct {
 	Expires   int64  `json:"expires,omitempty"`
 	Aud       string `json:"aud,omitempty"`
@@ -58,7 +67,11 @@ func (receiver *Instance) Sign(tokenHash string) (tokenStr string, err error) {
 		claims.Expires = time.Now().Add(time.Duration(receiver.Expires) * time.Second).Unix()
 	}
 	// header部份
-	header = `{"alg":"SM4","typ":"JWT"}`
+	header, err = buildHeader("SM4", "", receiver.KeyID)
+	if err != nil {
+		log.Err(err).Caller().Send()
+		return
+	}
 	// payload部份
 	claimsBytes, err = json.Marshal(&claims)
 	if err != nil {
@@ -82,34 +95,40 @@ func (receiver *Instance) Sign(tokenHash string) (tokenStr string, err error) {
 	return
 }
 
-func (receiver *Instance) VeritySign(tokenStr string) (global.UpdaterClaims, bool) {
+func (receiver *Instance) VeritySign(tokenStr string) (global.UpdaterClaims, error) {
 	var claims global.UpdaterClaims
 	jwtClaims, err := parseClaims(receiver.Key, receiver.IV, tokenStr)
 	if err != nil {
-		return claims, false
+		return claims, err
 	}
 	claims.Expires = jwtClaims.Expires
 	claims.TokenHash = jwtClaims.TokenHash
 	claims.Aud = jwtClaims.Aud
 	claims.IP = jwtClaims.IP
-	return claims, true
+	return claims, nil
 }
 
 func parseClaims(key string, iv string, tokenStr string) (claims _Claims, err error) {
 	var claimsBytes, signBytes, plainTextBytes []byte
 	arr := strings.Split(tokenStr, ".")
 	if len(arr) != 3 {
-		err = errors.New("token无效")
+		err = global.ErrTokenMalformed
 		log.Err(err).Caller().Send()
 		return
 	}
+	// 只接受与实例一致的算法
+	if err = checkHeader(arr[0], "SM4"); err != nil {
+		return
+	}
 	claimsBytes, err = base64.RawURLEncoding.DecodeString(arr[1])
 	if err != nil {
 		log.Err(err).Caller().Send()
+		err = global.ErrTokenMalformed
 		return
 	}
 	signBytes, err = base64.RawURLEncoding.DecodeString(arr[2])
 	if err != nil {
+		err = global.ErrTokenMalformed
 		return
 	}
 	// 加密前的明文[base64(header).base64(claims)]
@@ -117,17 +136,18 @@ func parseClaims(key string, iv string, tokenStr string) (claims _Claims, err er
 	// 使用key解密签名部分
 	plainTextBytes, err = sm4Decrypt(global.StrToBytes(key), global.StrToBytes(iv), signBytes)
 	if err != nil {
-		err = errors.New("签名无效")
+		err = global.ErrSignatureInvalid
 		return
 	}
 	// 比较解密后的明文是否等于[header.claims]
 	if global.BytesToStr(plainTextBytes) != msg {
-		err = errors.New("签名无效")
+		err = global.ErrSignatureInvalid
 		return
 	}
 	// 解析claims
 	if err = json.Unmarshal(claimsBytes, &claims); err != nil {
 		log.Err(err).Caller().Send()
+		err = global.ErrTokenMalformed
 		return
 	}
 	return
@@ -156,6 +176,9 @@ func sm4Decrypt(key, iv, cipherText []byte) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	if len(cipherText) == 0 || len(cipherText)%block.BlockSize() != 0 {
+		return nil, errors.New("密文长度无效")
+	}
 	blockMode := cipher.NewCBCDecrypter(block, iv)
 	origData := make([]byte, len(cipherText))
 	blockMode.CryptBlocks(origData, cipherText)
@@ -176,5 +199,47 @@ func pkcs5UnPadding(src []byte) []byte {
 		return nil
 	}
 	unpadding := int(src[length-1])
+	// 填充无效时返回nil，防止篡改的数据导致越界
+	if unpadding == 0 || unpadding > length {
+		return nil
+	}
 	return src[:(length - unpadding)]
 }
+
+// 生成header部份
+func buildHeader(alg, enc, keyID string) (string, error) {
+	headerBytes, err := json.Marshal(&_Header{Alg: alg, Enc: enc, Typ: "JWT", KeyID: keyID})
+	if err != nil {
+		return "", err
+	}
+	return global.BytesToStr(headerBytes), nil
+}
+
+// 生成密钥配置，key和iv都是16个字符
+func GenerateKey() (string, error) {
+	key, err := keyutil.RandomBase64(12)
+	if err != nil {
+		return "", err
+	}
+	iv, err := keyutil.RandomBase64(12)
+	if err != nil {
+		return "", err
+	}
+	return keyutil.EncodeConfig(map[string]string{"key": key, "iv": iv})
+}
+
+// 校验header中的算法
+func checkHeader(segment, alg string) error {
+	var header _Header
+	headerBytes, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return global.ErrTokenMalformed
+	}
+	if err = json.Unmarshal(headerBytes, &header); err != nil {
+		return global.ErrTokenMalformed
+	}
+	if header.Alg != alg {
+		return global.ErrAlgorithmMismatch
+	}
+	return nil
+}
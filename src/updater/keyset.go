@@ -0,0 +1,97 @@
+package updater
+
+import (
+	"crypto"
+	"errors"
+
+	"local/global"
+	"local/keyutil"
+
+	"github.com/rs/zerolog/log"
+)
+
+// 多密钥更新器，使用活动密钥签发授权，根据token中的kid选择验证的密钥
+type KeySet struct {
+	ActiveID string
+	Keys     map[string]global.UpdaterInstance
+	Order    []string // 验证没有kid的token时尝试密钥的顺序
+}
+
+// 构建更新器，没有密钥集时使用基础配置构建单密钥的更新器
+func BuildWithKeys(name, config string, keys []global.Key) (global.UpdaterInstance, error) {
+	if len(keys) == 0 {
+		return Build(name, config)
+	}
+	if err := keyutil.CheckKeys(keys); err != nil {
+		log.Err(err).Caller().Send()
+		return nil, err
+	}
+	keySet := &KeySet{
+		Keys: make(map[string]global.UpdaterInstance, len(keys)),
+	}
+	for k := range keys {
+		keyConfig, err := keyutil.MergeConfig(config, keys[k].Config, keys[k].ID)
+		if err != nil {
+			log.Err(err).Caller().Str("kid", keys[k].ID).Msg("合并密钥配置失败")
+			return nil, err
+		}
+		instance, err := Build(name, keyConfig)
+		if err != nil {
+			log.Err(err).Caller().Str("kid", keys[k].ID).Send()
+			return nil, err
+		}
+		keySet.Keys[keys[k].ID] = instance
+		if keys[k].Status == global.KeyStatusActive {
+			keySet.ActiveID = keys[k].ID
+		}
+	}
+	keySet.Order = keyutil.KeyOrder(keys)
+	return keySet, nil
+}
+
+func (receiver *KeySet) Sign(tokenHash string) (string, error) {
+	instance, exists := receiver.Keys[receiver.ActiveID]
+	if !exists {
+		return "", errors.New("没有活动的密钥")
+	}
+	return instance.Sign(tokenHash)
+}
+
+func (receiver *KeySet) VeritySign(tokenStr string) (global.UpdaterClaims, error) {
+	kid := keyutil.TokenKeyID(tokenStr)
+	if kid != "" {
+		instance, exists := receiver.Keys[kid]
+		if !exists {
+			return global.UpdaterClaims{}, global.ErrSignatureInvalid
+		}
+		return instance.VeritySign(tokenStr)
+	}
+	// 启用密钥集之前签发的token没有kid，按固定顺序尝试所有密钥
+	// 某个密钥验证签名通过但之后的校验失败时返回该错误，所有密钥都无法验证签名时返回签名无效
+	for k := range receiver.Order {
+		claims, err := receiver.Keys[receiver.Order[k]].VeritySign(tokenStr)
+		if err == nil || !global.SignatureUnverified(err) {
+			return claims, err
+		}
+	}
+	return global.UpdaterClaims{}, global.ErrSignatureInvalid
+}
+
+// 导出活动密钥的公钥，对称算法返回nil
+func (receiver *KeySet) ExportPublicKey() crypto.PublicKey {
+	if instance, ok := receiver.Keys[receiver.ActiveID].(global.PublicKeyInstance); ok {
+		return instance.ExportPublicKey()
+	}
+	return nil
+}
+
+// 导出所有密钥的公钥，对称算法返回空map
+func (receiver *KeySet) ExportPublicKeys() map[string]crypto.PublicKey {
+	publicKeys := make(map[string]crypto.PublicKey, len(receiver.Keys))
+	for kid, instance := range receiver.Keys {
+		if publicKeyInstance, ok := instance.(global.PublicKeyInstance); ok {
+			publicKeys[kid] = publicKeyInstance.ExportPublicKey()
+		}
+	}
+	return publicKeys
+}
@@ -0,0 +1,115 @@
+package paseto_v4_local
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"local/global"
+	"local/keyutil"
+	"local/paseto"
+
+	"github.com/rs/zerolog/log"
+)
+
+type Instance struct {
+	Expires int64  `json:"expires"`
+	KeyStr  string `json:"key"`
+	Key     []byte `json:"-"`
+	KeyID   string `json:"key_id,omitempty"`
+}
+
+type _Claims struct {
+	Expires   string `json:"exp,omitempty"`
+	IssuedAt  string `json:"iat,omitempty"`
+	TokenHash string `json:"token_hash,omitempty"`
+}
+
+type _Footer struct {
+	KeyID string `json:"kid,omitempty"`
+}
+
+func New(config string) (*Instance, error) {
+	var instance Instance
+	err := json.Unmarshal(global.StrToBytes(config), &instance)
+	if err != nil {
+		return nil, err
+	}
+	if instance.KeyStr == "" {
+		return nil, errors.New("key不能为空")
+	}
+	instance.Key, err = base64.RawURLEncoding.DecodeString(instance.KeyStr)
+	if err != nil {
+		return nil, errors.New("无效的key Base64字符串：" + err.Error())
+	}
+	if len(instance.Key) != 32 {
+		return nil, errors.New("key的长度必须是32字节")
+	}
+	return &instance, nil
+}
+
+func (receiver *Instance) Sign(tokenHash string) (tokenStr string, err error) {
+	var (
+		claims      _Claims
+		claimsBytes []byte
+		footerBytes []byte
+		now         = time.Now()
+	)
+	claims.IssuedAt = now.Format(time.RFC3339)
+	if receiver.Expires > 0 {
+		claims.Expires = now.Add(time.Duration(receiver.Expires) * time.Second).Format(time.RFC3339)
+	}
+	claims.TokenHash = tokenHash
+	claimsBytes, err = json.Marshal(&claims)
+	if err != nil {
+		log.Err(err).Caller().Send()
+		return
+	}
+	// footer中携带密钥ID
+	if receiver.KeyID != "" {
+		footerBytes, err = json.Marshal(&_Footer{KeyID: receiver.KeyID})
+		if err != nil {
+			log.Err(err).Caller().Send()
+			return
+		}
+	}
+	tokenStr, err = paseto.V4Encrypt(receiver.Key, claimsBytes, footerBytes, nil)
+	if err != nil {
+		log.Err(err).Caller().Send()
+		return
+	}
+	return
+}
+
+func (receiver *Instance) VeritySign(tokenStr string) (global.UpdaterClaims, error) {
+	var (
+		claims      global.UpdaterClaims
+		tokenClaims _Claims
+	)
+	// 解密得到claims
+	claimsBytes, _, err := paseto.V4Decrypt(receiver.Key, tokenStr, nil)
+	if err != nil {
+		log.Debug().Err(err).Caller().Send()
+		return claims, err
+	}
+	if err = json.Unmarshal(claimsBytes, &tokenClaims); err != nil {
+		log.Debug().Err(err).Caller().Send()
+		return claims, global.ErrTokenMalformed
+	}
+	if claims.Expires, err = paseto.ParseTime(tokenClaims.Expires); err != nil {
+		log.Debug().Err(err).Caller().Send()
+		return claims, global.ErrTokenMalformed
+	}
+	claims.TokenHash = tokenClaims.TokenHash
+	return claims, nil
+}
+
+// 生成密钥配置
+func GenerateKey() (string, error) {
+	key, err := keyutil.RandomBase64(32)
+	if err != nil {
+		return "", err
+	}
+	return keyutil.EncodeConfig(map[string]string{"key": key})
+}
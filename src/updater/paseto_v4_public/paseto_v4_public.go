@@ -0,0 +1,121 @@
+package paseto_v4_public
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"local/global"
+	"local/keyutil"
+	"local/paseto"
+
+	"github.com/rs/zerolog/log"
+)
+
+type Instance struct {
+	Expires       int64              `json:"expires"`
+	PublicKey     ed25519.PublicKey  `json:"-"`
+	PrivateKey    ed25519.PrivateKey `json:"-"`
+	PrivateKeyStr string             `json:"private_key"`
+	PublicKeyStr  string             `json:"public_key,omitempty"` // 可选，传入时必须与私钥匹配
+	KeyID         string             `json:"key_id,omitempty"`
+}
+
+type _Claims struct {
+	Expires   string `json:"exp,omitempty"`
+	IssuedAt  string `json:"iat,omitempty"`
+	TokenHash string `json:"token_hash,omitempty"`
+}
+
+type _Footer struct {
+	KeyID string `json:"kid,omitempty"`
+}
+
+func New(config string) (*Instance, error) {
+	var instance Instance
+	err := json.Unmarshal(global.StrToBytes(config), &instance)
+	if err != nil {
+		return nil, err
+	}
+	if instance.PrivateKeyStr == "" {
+		return nil, errors.New("private_key不能为空")
+	}
+	// 转换私钥，支持原始种子和PKCS8格式
+	instance.PrivateKey, err = keyutil.ParseEd25519PrivateKey(instance.PrivateKeyStr)
+	if err != nil {
+		return nil, errors.New("无效的Ed25519私钥：" + err.Error())
+	}
+	instance.PublicKey = instance.PrivateKey.Public().(ed25519.PublicKey)
+	if err = keyutil.CheckPublicKey(instance.PublicKeyStr, instance.PublicKey); err != nil {
+		return nil, err
+	}
+	return &instance, nil
+}
+
+func (receiver *Instance) Sign(tokenHash string) (tokenStr string, err error) {
+	var (
+		claims      _Claims
+		claimsBytes []byte
+		footerBytes []byte
+		now         = time.Now()
+	)
+	claims.IssuedAt = now.Format(time.RFC3339)
+	if receiver.Expires > 0 {
+		claims.Expires = now.Add(time.Duration(receiver.Expires) * time.Second).Format(time.RFC3339)
+	}
+	claims.TokenHash = tokenHash
+	claimsBytes, err = json.Marshal(&claims)
+	if err != nil {
+		log.Err(err).Caller().Send()
+		return
+	}
+	// footer中携带密钥ID
+	if receiver.KeyID != "" {
+		footerBytes, err = json.Marshal(&_Footer{KeyID: receiver.KeyID})
+		if err != nil {
+			log.Err(err).Caller().Send()
+			return
+		}
+	}
+	tokenStr = paseto.V4Sign(receiver.PrivateKey, claimsBytes, footerBytes, nil)
+	return
+}
+
+func (receiver *Instance) VeritySign(tokenStr string) (global.UpdaterClaims, error) {
+	var (
+		claims      global.UpdaterClaims
+		tokenClaims _Claims
+	)
+	// 验签得到claims
+	claimsBytes, _, err := paseto.V4Verify(receiver.PublicKey, tokenStr, nil)
+	if err != nil {
+		log.Debug().Err(err).Caller().Send()
+		return claims, err
+	}
+	if err = json.Unmarshal(claimsBytes, &tokenClaims); err != nil {
+		log.Debug().Err(err).Caller().Send()
+		return claims, global.ErrTokenMalformed
+	}
+	if claims.Expires, err = paseto.ParseTime(tokenClaims.Expires); err != nil {
+		log.Debug().Err(err).Caller().Send()
+		return claims, global.ErrTokenMalformed
+	}
+	claims.TokenHash = tokenClaims.TokenHash
+	return claims, nil
+}
+
+// 导出公钥
+func (receiver *Instance) ExportPublicKey() crypto.PublicKey {
+	return receiver.PublicKey
+}
+
+// 生成密钥配置
+func GenerateKey() (string, error) {
+	privateKeyStr, publicKeyStr, err := keyutil.GenerateEd25519Key()
+	if err != nil {
+		return "", err
+	}
+	return keyutil.EncodeConfig(map[string]string{"private_key": privateKeyStr, "public_key": publicKeyStr})
+}